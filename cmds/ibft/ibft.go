@@ -0,0 +1,172 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// ibft dumps iSCSI Boot Firmware Tables as JSON.
+//
+// Synopsis:
+//     ibft [-show-secrets] [FILES]...
+//     ibft [-show-secrets] -hex|-base64
+//     ibft -fix-checksum FILE NEWFILE
+//
+// Description:
+//     Decode the IBFTs in FILES, or, with no arguments, the IBFTs found
+//     in sysfs or /proc/iomem, and print them as JSON. CHAP secrets are
+//     replaced with *** unless -show-secrets is given. Problems with a
+//     table in FILES or stdin which do not stop it being read, e.g. a
+//     bad checksum, are printed to stderr as warnings.
+//
+//     With -hex or -base64, decode one IBFT read from stdin in that
+//     encoding, e.g. one pasted from a bug report. White space in the
+//     input is ignored.
+//
+//     With -fix-checksum, write a copy of the IBFT in FILE to NEWFILE,
+//     with the checksum byte fixed, and print the old and new checksum
+//     bytes. Some firmware gets the checksum wrong; Linux does not
+//     check it, but we do.
+//
+// Options:
+//     -show-secrets: print CHAP secrets
+//     -hex:          read a hex encoded IBFT from stdin
+//     -base64:       read a base64 encoded IBFT from stdin
+//     -fix-checksum: write a copy of an IBFT with the checksum fixed
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/u-root/u-root/pkg/acpi"
+)
+
+var (
+	showSecrets = flag.Bool("show-secrets", false, "print CHAP secrets")
+	hexIn       = flag.Bool("hex", false, "read a hex encoded IBFT from stdin")
+	base64In    = flag.Bool("base64", false, "read a base64 encoded IBFT from stdin")
+	fixChecksum = flag.Bool("fix-checksum", false, "write a copy of an IBFT with the checksum fixed")
+)
+
+// decodeStdin reads stdin, drops the white space, and decodes it with f.
+func decodeStdin(f func(string) ([]byte, error)) ([]byte, error) {
+	b, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, err
+	}
+	return f(strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, string(b)))
+}
+
+// readerOptions are the options IBFTs are read with: firmware tables
+// are what we are dumping, and some use a variant signature.
+func readerOptions(r *acpi.IBFTReader) {
+	r.SignatureVariants = true
+}
+
+// unmarshal reads the IBFT in b, from n, and prints any warnings about
+// it, e.g. a bad checksum, to stderr.
+func unmarshal(n string, b []byte) *acpi.IBFT {
+	r, err := acpi.NewIBFTReader(b, readerOptions)
+	if err != nil {
+		log.Fatalf("%s: %v", n, err)
+	}
+	i, err := r.IBFT()
+	if err != nil {
+		log.Fatalf("%s: %v", n, err)
+	}
+	for _, w := range r.Warnings {
+		fmt.Fprintf(os.Stderr, "%s: warning: %s\n", n, w)
+	}
+	return i
+}
+
+// fix writes a copy of the IBFT in n, with the checksum fixed, to nn.
+func fix(n, nn string) error {
+	b, err := ioutil.ReadFile(n)
+	if err != nil {
+		return err
+	}
+	// Check that it is an IBFT, and drop anything after it.
+	if _, err := acpi.NewIBFTReader(b, readerOptions); err != nil {
+		return fmt.Errorf("%s: %v", n, err)
+	}
+	b = b[:binary.LittleEndian.Uint32(b[acpi.LengthOffset:])]
+	old, c, err := acpi.FixChecksum(b)
+	if err != nil {
+		return fmt.Errorf("%s: %v", n, err)
+	}
+	if err := ioutil.WriteFile(nn, b, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("%s: checksum byte was %#02x, is %#02x in %s\n", n, old, c, nn)
+	return nil
+}
+
+func main() {
+	flag.Parse()
+
+	if *fixChecksum {
+		if flag.NArg() != 2 {
+			log.Fatal("usage: ibft -fix-checksum FILE NEWFILE")
+		}
+		if err := fix(flag.Arg(0), flag.Arg(1)); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var ibfts []*acpi.IBFT
+	switch {
+	case *hexIn && *base64In:
+		log.Fatal("-hex and -base64 can not both be used")
+	case (*hexIn || *base64In) && flag.NArg() != 0:
+		log.Fatal("-hex and -base64 read stdin, not files")
+	case *hexIn:
+		b, err := decodeStdin(hex.DecodeString)
+		if err != nil {
+			log.Fatalf("Decoding hex: %v", err)
+		}
+		ibfts = append(ibfts, unmarshal("stdin", b))
+	case *base64In:
+		b, err := decodeStdin(base64.StdEncoding.DecodeString)
+		if err != nil {
+			log.Fatalf("Decoding base64: %v", err)
+		}
+		ibfts = append(ibfts, unmarshal("stdin", b))
+	case flag.NArg() == 0:
+		var err error
+		if ibfts, err = acpi.FindIBFTs(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for _, n := range flag.Args() {
+		b, err := ioutil.ReadFile(n)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ibfts = append(ibfts, unmarshal(n, b))
+	}
+
+	e := json.NewEncoder(os.Stdout)
+	e.SetIndent("", "\t")
+	for _, i := range ibfts {
+		if !*showSecrets {
+			i = i.Redact()
+		}
+		if err := e.Encode(i); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/acpi"
+	"github.com/u-root/u-root/pkg/testutil"
+)
+
+const testIBFT = "../../pkg/acpi/testdata/ibft.bin"
+
+func TestIBFT(t *testing.T) {
+	var tests = []struct {
+		args   []string
+		secret bool
+	}{
+		{[]string{testIBFT}, false},
+		{[]string{"-show-secrets", testIBFT}, true},
+	}
+	for _, tt := range tests {
+		out, err := testutil.Command(t, tt.args...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("ibft %v: got %v, want nil: %s", tt.args, err, out)
+		}
+		if got := bytes.Contains(out, []byte(`"adverb"`)); got != tt.secret {
+			t.Errorf("ibft %v: secret in output is %v, want %v", tt.args, got, tt.secret)
+		}
+		if !bytes.Contains(out, []byte(`"verb"`)) {
+			t.Errorf("ibft %v: CHAP name not in output", tt.args)
+		}
+	}
+}
+
+func TestIBFTEncoded(t *testing.T) {
+	b, err := ioutil.ReadFile(testIBFT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		flag string
+		in   string
+		ok   bool
+	}{
+		{"-hex", hex.EncodeToString(b), true},
+		{"-hex", wrap(hex.EncodeToString(b)), true},
+		{"-base64", base64.StdEncoding.EncodeToString(b), true},
+		{"-base64", wrap(base64.StdEncoding.EncodeToString(b)), true},
+		{"-hex", "not hex", false},
+		{"-base64", hex.EncodeToString(b), false},
+	}
+	for _, tt := range tests {
+		cmd := testutil.Command(t, tt.flag)
+		cmd.Stdin = strings.NewReader(tt.in)
+		out, err := cmd.CombinedOutput()
+		if (err == nil) != tt.ok {
+			t.Errorf("ibft %s: got %v, want ok %v: %s", tt.flag, err, tt.ok, out)
+			continue
+		}
+		if tt.ok && !bytes.Contains(out, []byte(`"bullseye"`)) {
+			t.Errorf("ibft %s: target name not in output: %s", tt.flag, out)
+		}
+	}
+}
+
+func TestIBFTFixChecksum(t *testing.T) {
+	b, err := ioutil.ReadFile(testIBFT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := ioutil.TempDir("", "ibft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	bad, fixed := filepath.Join(d, "bad"), filepath.Join(d, "fixed")
+	b[9]++
+	// Firmware tables can have junk after them.
+	if err := ioutil.WriteFile(bad, append(b, 0xff), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := testutil.Command(t, "-fix-checksum", bad, fixed).CombinedOutput()
+	if err != nil {
+		t.Fatalf("ibft -fix-checksum: got %v, want nil: %s", err, out)
+	}
+	want := fmt.Sprintf("checksum byte was %#02x, is %#02x", b[9], b[9]-1)
+	if !bytes.Contains(out, []byte(want)) {
+		t.Errorf("ibft -fix-checksum: got %q, want it to contain %q", out, want)
+	}
+	f, err := ioutil.ReadFile(fixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := acpi.VerifyChecksum(f); err != nil {
+		t.Errorf("Fixed IBFT: %v", err)
+	}
+
+	// Dumping the bad table warns about the checksum.
+	cmd := testutil.Command(t, bad)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if out, err := cmd.Output(); err != nil || !bytes.Contains(out, []byte(`"bullseye"`)) {
+		t.Errorf("ibft %s: got %v, want nil and the IBFT: %s", bad, err, out)
+	}
+	if want := "warning: checksum byte"; !strings.Contains(stderr.String(), want) {
+		t.Errorf("ibft %s: got stderr %q, want it to contain %q", bad, stderr.String(), want)
+	}
+
+	if out, err := testutil.Command(t, "-fix-checksum", bad).CombinedOutput(); err == nil {
+		t.Errorf("ibft -fix-checksum with one file: got nil, want error: %s", out)
+	}
+}
+
+// wrap splits s into lines of 76 characters, as in a mail message.
+func wrap(s string) string {
+	var w []string
+	for len(s) > 76 {
+		w, s = append(w, s[:76]), s[76:]
+	}
+	return strings.Join(append(w, s), "\n")
+}
+
+func TestMain(m *testing.M) {
+	testutil.Run(m, main)
+}
@@ -60,6 +60,13 @@ var (
 	// Debug implements fmt.Sprintf and can be used for debug printing
 	Debug        = func(string, ...interface{}) {}
 	unmarshalers = map[sig]func(Tabler) (Tabler, error){}
+
+	// HexUpper makes the hex digits in the string forms we display,
+	// i.e. the String and JSON of a MAC or PCI BDF, upper case. The
+	// default is lower case. It does not change what Unmarshal, or
+	// anything else, stores in an IBFT, which is always lower case.
+	// IP addresses are always lower case, as RFC 5952 says.
+	HexUpper bool
 )
 
 // addUnMarshaler is intended to be called by init functions
@@ -149,11 +156,11 @@ func uw(b *bytes.Buffer, s string, bits int) error {
 	return nil
 }
 
-// Marshal marshals a Tabler into a byte slice.
+// Marshal marshals a table into a byte slice.
 // Once marshaling is done, it inserts the length into
 // the standard place at LengthOffset, and then generates and inserts
 // a checksum at CSUMOffset.
-func Marshal(t Tabler) ([]byte, error) {
+func Marshal(t Marshaler) ([]byte, error) {
 	Debug("Marshal %T", t)
 	b, err := t.Marshal()
 	if err != nil {
@@ -165,6 +172,8 @@ func Marshal(t Tabler) ([]byte, error) {
 	}
 
 	binary.LittleEndian.PutUint32(b[LengthOffset:], uint32(len(b)))
+	// The old checksum, if any, must not be part of the new one.
+	b[CSUMOffset] = 0
 	c := gencsum(b)
 	Debug("CSUM is %#x", c)
 	b[CSUMOffset] = c
@@ -172,6 +181,28 @@ func Marshal(t Tabler) ([]byte, error) {
 	return b, nil
 }
 
+// MarshalForceLength marshals a table, as Marshal does, then sets the
+// Length in the header to l, whatever the real length is, and fixes
+// the checksum so that the first l bytes, or all of them if there are
+// fewer, sum to zero, as a parser will check. It is for testing how
+// parsers cope with a table which is shorter or longer than it says it
+// is; a table made this way is broken. Marshal never does this.
+func MarshalForceLength(t Marshaler, l uint32) ([]byte, error) {
+	b, err := Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	binary.LittleEndian.PutUint32(b[LengthOffset:], l)
+	n := len(b)
+	if int64(l) < int64(n) && l > CSUMOffset {
+		n = int(l)
+	}
+	b[CSUMOffset] = 0
+	b[CSUMOffset] = gencsum(b[:n])
+	Debug("Forced Length to %d; real length is %d", l, len(b))
+	return b, nil
+}
+
 // UnMarshal unmarshals a single table and returns a Tabler.
 // If the table is one of the many we don't care about we
 // just return a Raw table, which can be easily written out
@@ -190,6 +221,18 @@ func UnMarshal(a int64) (Tabler, error) {
 	return r, nil
 }
 
+// FindTable returns the first of tables with signature sig, e.g. one
+// of the tables from RawTables, and true; or, if there is none, nil
+// and false.
+func FindTable(tables []Tabler, sig string) (Tabler, bool) {
+	for _, t := range tables {
+		if t.Sig() == sig {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
 // UnMarshalSDT unmarshals an SDT.
 // It's pretty much impossible for the RSDP to point to
 // anything else so we mainly do the unmarshal and type assertion.
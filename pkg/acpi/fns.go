@@ -6,13 +6,29 @@ package acpi
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
 	"log"
+	"math"
 	"net"
 	"reflect"
+	"strconv"
+	"strings"
 )
 
+// hexCase returns s, a string with hex digits, in the case HexUpper
+// asks for. A 0x prefix stays lower case.
+func hexCase(s string) string {
+	if !HexUpper {
+		return s
+	}
+	if strings.HasPrefix(s, "0x") {
+		return "0x" + strings.ToUpper(s[2:])
+	}
+	return strings.ToUpper(s)
+}
+
 // gencsum generates a uint8 checksum of a []uint8
 func gencsum(b []uint8) uint8 {
 	var csum uint8
@@ -23,17 +39,201 @@ func gencsum(b []uint8) uint8 {
 	return ^csum + 1
 }
 
+// VerifyChecksum verifies that a marshaled table sums to zero,
+// as all ACPI tables must. It also checks that the table is long
+// enough to hold a checksum, and that the length at LengthOffset
+// matches the length of the slice.
+func VerifyChecksum(b []byte) error {
+	if len(b) < MinTableLength {
+		return fmt.Errorf("VerifyChecksum: table is %d bytes, must be at least %d", len(b), MinTableLength)
+	}
+	if l := binary.LittleEndian.Uint32(b[LengthOffset:]); l != uint32(len(b)) {
+		return fmt.Errorf("VerifyChecksum: %q table length is %d, but there are %d bytes", b[:4], l, len(b))
+	}
+	if c := gencsum(b); c != 0 {
+		return fmt.Errorf("VerifyChecksum: %q table does not sum to zero (checksum byte %#02x is off by %#02x)", b[:4], b[CSUMOffset], c)
+	}
+	return nil
+}
+
+// FixChecksum sets the checksum byte of the table at the start of b,
+// e.g. one from firmware with a bad checksum, so that the table sums
+// to zero. It returns the old and new checksum bytes. As for
+// VerifyChecksum, the Length of the table must be the length of b.
+func FixChecksum(b []byte) (uint8, uint8, error) {
+	if len(b) < MinTableLength {
+		return 0, 0, fmt.Errorf("FixChecksum: table is %d bytes, must be at least %d", len(b), MinTableLength)
+	}
+	if l := binary.LittleEndian.Uint32(b[LengthOffset:]); l != uint32(len(b)) {
+		return 0, 0, fmt.Errorf("FixChecksum: %q table length is %d, but there are %d bytes", b[:4], l, len(b))
+	}
+	old := b[CSUMOffset]
+	b[CSUMOffset] += gencsum(b)
+	return old, b[CSUMOffset], nil
+}
+
+// Hash returns the SHA-256 of the table, without its checksum byte,
+// for recognizing the same table found in different places, e.g. in
+// sysfs and in /dev/mem, where firmware or the kernel may have fixed
+// up the checksum. It hashes AllData, so for tables which are not
+// backed by bytes, e.g. an IBFT made by hand, Marshal them first.
+func Hash(t Tabler) [32]byte {
+	b := append([]byte{}, t.AllData()...)
+	if len(b) > CSUMOffset {
+		b[CSUMOffset] = 0
+	}
+	return sha256.Sum256(b)
+}
+
+// QuickVerify does a quick check of a table, without decoding any of
+// it: the signature must be there, the Length must be no longer than
+// data, and the table must sum to zero. There can be data after the
+// table, e.g. when data is a region of memory.
+func QuickVerify(data []byte) error {
+	if len(data) < MinTableLength {
+		return fmt.Errorf("QuickVerify: %d bytes is too short for a table", len(data))
+	}
+	for _, c := range data[:4] {
+		if !('A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '_') {
+			return fmt.Errorf("QuickVerify: %q is not a table signature", data[:4])
+		}
+	}
+	l := binary.LittleEndian.Uint32(data[LengthOffset:])
+	if l < MinTableLength || l > uint32(len(data)) {
+		return fmt.Errorf("QuickVerify: %q Length is %d, must be between %d and %d", data[:4], l, MinTableLength, len(data))
+	}
+	if c := gencsum(data[:l]); c != 0 {
+		return fmt.Errorf("QuickVerify: %q table does not sum to zero (off by %#02x)", data[:4], c)
+	}
+	return nil
+}
+
 // HeapTable is for ACPI tables that have a heap, i.e. the strings
 // are not subtables, as in most ACPI, but are contained in an area
 // at the end of the tables, after the other table elements. So far,
 // we only know of one such table, the IBFT.
+// Offsets to the heap are from the start of the table, not the
+// start of the heap, so HeapBase is added to each one.
 type HeapTable struct {
-	Head *bytes.Buffer
-	Heap *bytes.Buffer
+	Head     *bytes.Buffer
+	Heap     *bytes.Buffer
+	HeapBase uint16
+	// Field is the name of the field being marshaled, e.g.
+	// NIC0.HostName, for LogHeapAlloc. Callers which know it set it.
+	Field string
+	// Intern makes Marshal write each distinct string to the heap
+	// once; later copies point at the first. This is legal, since
+	// each entry has its own offset and length.
+	Intern   bool
+	interned map[sheap]uint16
+	// Annotations, if not nil, gets an entry for each field Marshal
+	// writes, named Field, where it was written: for a heap entry,
+	// Field.Length and Field.Offset in the head, and Field, and
+	// Field.NUL if it has one, in the heap.
+	// Offsets are from the start of Head.
+	Annotations *Layout
+}
+
+// HeapAlloc is a heap entry written by HeapTable.Marshal.
+type HeapAlloc struct {
+	Field   string
+	Offset  int
+	Len     int
+	Preview string
+}
+
+// heapPreviewLen is how much of a heap entry goes in a HeapAlloc Preview.
+const heapPreviewLen = 16
+
+// String returns the HeapAlloc as key=value pairs.
+func (a HeapAlloc) String() string {
+	return fmt.Sprintf("field=%s offset=%#x len=%d value-preview=%q", a.Field, a.Offset, a.Len, a.Preview)
+}
+
+// LogHeapAlloc is called for each heap entry HeapTable.Marshal writes,
+// so that how a heap was laid out can be worked out from the log. The
+// default sends it to Debug.
+var LogHeapAlloc = func(a HeapAlloc) {
+	Debug("heap alloc: %v", a)
+}
+
+// HeapKind is the kind of a heap entry, for HeapNUL.
+type HeapKind int
+
+const (
+	// HeapName is any entry which is not a secret, e.g. a TargetName.
+	HeapName HeapKind = iota
+	// HeapSecret is an entry whose Field ends in Secret, e.g. CHAPSecret.
+	HeapSecret
+)
+
+// HeapNUL says which kinds of heap entry HeapTable.Marshal follows with
+// a NUL. The NUL is not part of the entry, i.e. not counted in its
+// length. Names are terminated by default: firmware, e.g. iPXE, does
+// it, and so code which treats them as C strings works. Secrets are not,
+// as a secret can be any bytes, so the length is all that says where
+// it ends, and it should not be padded.
+var HeapNUL = map[HeapKind]bool{HeapName: true, HeapSecret: false}
+
+// heapKind returns the HeapKind of field.
+func heapKind(field string) HeapKind {
+	if strings.HasSuffix(field, "Secret") {
+		return HeapSecret
+	}
+	return HeapName
+}
+
+// heapAlloc returns the HeapAlloc for s at o. Secrets are not previewed.
+func heapAlloc(field string, o int, s sheap) HeapAlloc {
+	a := HeapAlloc{Field: field, Offset: o, Len: len(s), Preview: string(s)}
+	switch {
+	case strings.HasSuffix(field, "Secret"):
+		a.Preview = "<redacted>"
+	case len(s) > heapPreviewLen:
+		a.Preview = string(s[:heapPreviewLen]) + "..."
+	}
+	return a
 }
 
 // Marshal marshals basic types into HeapTable
 func (h *HeapTable) Marshal(i interface{}) error {
+	o, heap := h.Head.Len(), h.Heap.Len()
+	if err := h.marshal(i); err != nil {
+		return err
+	}
+	if h.Annotations != nil {
+		h.annotate(o, heap, i)
+	}
+	return nil
+}
+
+// annotate adds the Annotations for i, which Marshal wrote at offset o
+// in the head, and, if it added to it, heap in the heap.
+func (h *HeapTable) annotate(o, heap int, i interface{}) {
+	n := h.Head.Len() - o
+	if _, ok := i.(sheap); !ok {
+		if n > 0 {
+			*h.Annotations = append(*h.Annotations, LayoutEntry{Name: h.Field, Offset: o, Len: n})
+		}
+		return
+	}
+	b := h.Head.Bytes()
+	*h.Annotations = append(*h.Annotations,
+		LayoutEntry{Name: h.Field + ".Length", Offset: o, Len: 2},
+		LayoutEntry{Name: h.Field + ".Offset", Offset: o + 2, Len: 2})
+	// An interned entry points at an earlier copy, which is
+	// where it is, so the offset is read back from the head.
+	l := int(binary.LittleEndian.Uint16(b[o:]))
+	if l != 0 {
+		*h.Annotations = append(*h.Annotations, LayoutEntry{Name: h.Field, Offset: int(binary.LittleEndian.Uint16(b[o+2:])), Len: l})
+	}
+	if h.Heap.Len()-heap > l {
+		*h.Annotations = append(*h.Annotations, LayoutEntry{Name: h.Field + ".NUL", Offset: int(h.HeapBase) + heap + l, Len: 1})
+	}
+}
+
+// marshal does the work of Marshal.
+func (h *HeapTable) marshal(i interface{}) error {
 	switch s := i.(type) {
 	case sockaddr:
 		Debug("addr")
@@ -43,6 +243,11 @@ func (h *HeapTable) Marshal(i interface{}) error {
 		}
 		w(h.Head, a.IP.To16(), uint16(a.Port))
 	case ipaddr:
+		// An unset address is all zeros.
+		if s == "" {
+			w(h.Head, net.IPv6zero)
+			break
+		}
 		a, err := net.ResolveIPAddr("ip", string(s))
 		if err != nil {
 			return fmt.Errorf("addr %s: %v", s, err)
@@ -79,10 +284,42 @@ func (h *HeapTable) Marshal(i interface{}) error {
 		if err := uw(h.Head, string(s), 64); err != nil {
 			return err
 		}
+	case lun:
+		v, err := parseLUN(s)
+		if err != nil {
+			return err
+		}
+		w(h.Head, v)
 	case sheap:
-		w(h.Head, uint16(len(s)), uint16(h.Heap.Len()))
-		Debug("Write %q to heap", string(s))
+		// An empty entry is not in the heap, so it has no offset.
+		if s == "" {
+			w(h.Head, uint16(0), uint16(0))
+			break
+		}
+		// Lengths and offsets are 16 bits; do not let them wrap.
+		if len(s) > math.MaxUint16 {
+			return fmt.Errorf("heap entry is %d bytes, more than %d", len(s), math.MaxUint16)
+		}
+		if o, ok := h.interned[s]; ok {
+			w(h.Head, uint16(len(s)), o)
+			break
+		}
+		if o := int(h.HeapBase) + h.Heap.Len(); o > math.MaxUint16 {
+			return fmt.Errorf("heap offset is %d, more than %d", o, math.MaxUint16)
+		}
+		o := h.HeapBase + uint16(h.Heap.Len())
+		w(h.Head, uint16(len(s)), o)
+		LogHeapAlloc(heapAlloc(h.Field, int(o), s))
 		w(h.Heap, []byte(s))
+		if HeapNUL[heapKind(h.Field)] {
+			w(h.Heap, uint8(0))
+		}
+		if h.Intern {
+			if h.interned == nil {
+				h.interned = map[sheap]uint16{}
+			}
+			h.interned[s] = o
+		}
 	default:
 		return fmt.Errorf("Don't know what to do with %T", s)
 	}
@@ -161,3 +398,54 @@ func ShowTable(t Tabler) string {
 		t.CreatorRevision())
 
 }
+
+// FieldDesc describes a field of a table structure.
+type FieldDesc struct {
+	Name   string
+	Offset int
+	Size   int
+	Desc   string
+}
+
+// FieldDescriptions returns the fields of v, a table structure, e.g.
+// one of IBFTStructures, in order, with the descriptions from the spec
+// in their desc tags. Offsets are from the start of the structure:
+// the offset tag if there is one, else just after the previous field.
+// The fields of embedded structs, e.g. a structure header, are
+// included in place. Size is as binary.Size has it, so it is -1 for a
+// field which is not of fixed size. If v is not a struct, or a pointer
+// to one, FieldDescriptions returns nil.
+func FieldDescriptions(v interface{}) []FieldDesc {
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	var fds []FieldDesc
+	fieldDescriptions(&fds, t, 0)
+	return fds
+}
+
+// fieldDescriptions appends the fields of struct t, at base, to fds,
+// and returns the offset after them.
+func fieldDescriptions(fds *[]FieldDesc, t reflect.Type, base int) int {
+	next := base
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if o, err := strconv.Atoi(f.Tag.Get("offset")); err == nil {
+			next = base + o
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			next = fieldDescriptions(fds, f.Type, next)
+			continue
+		}
+		sz := binary.Size(reflect.Zero(f.Type).Interface())
+		*fds = append(*fds, FieldDesc{Name: f.Name, Offset: next, Size: sz, Desc: f.Tag.Get("desc")})
+		if sz > 0 {
+			next += sz
+		}
+	}
+	return next
+}
@@ -0,0 +1,312 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acpi
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// TestChecksums runs every table builder we have, verifies the
+// table checksum, then corrupts each byte in turn and makes sure
+// VerifyChecksum catches it. New builders should be added here.
+func TestChecksums(t *testing.T) {
+	var tests = []struct {
+		n string
+		f func() ([]byte, error)
+	}{
+		{"IBFT", func() ([]byte, error) {
+			return Marshal(testIBFT())
+		}},
+		{"XSDT", func() ([]byte, error) {
+			s, err := NewSDT()
+			if err != nil {
+				return nil, err
+			}
+			return Marshal(s)
+		}},
+		{"RSDT", func() ([]byte, error) {
+			s, err := NewSDT(func(s *SDT) { s.Header.Sig = "RSDT" })
+			if err != nil {
+				return nil, err
+			}
+			s.Tables = []int64{0x1000, 0x2000}
+			return Marshal(s)
+		}},
+		{"Generic", func() ([]byte, error) {
+			g, err := NewGeneric(genssdt([]byte{1, 2, 3, 4}))
+			if err != nil {
+				return nil, err
+			}
+			g.(*Generic).Header.OEMID = "U-ROOT"
+			return g.Marshal()
+		}},
+		{"SSDT", func() ([]byte, error) {
+			return genssdt([]byte{0x10, 0x20, 0x30}), nil
+		}},
+	}
+	for _, tt := range tests {
+		b, err := tt.f()
+		if err != nil {
+			t.Errorf("%s: got %v, want nil", tt.n, err)
+			continue
+		}
+		if err := VerifyChecksum(b); err != nil {
+			t.Errorf("%s: VerifyChecksum: got %v, want nil", tt.n, err)
+			continue
+		}
+		for i := range b {
+			// Corrupting the length is caught too, but for a different reason.
+			c := append([]byte{}, b...)
+			c[i]++
+			if err := VerifyChecksum(c); err == nil {
+				t.Errorf("%s: VerifyChecksum with byte %d corrupted: got nil, want error", tt.n, i)
+			}
+		}
+	}
+}
+
+func TestVerifyChecksumShort(t *testing.T) {
+	if err := VerifyChecksum([]byte("IBFT")); err == nil {
+		t.Errorf("VerifyChecksum(%q): got nil, want error", "IBFT")
+	}
+}
+
+func TestQuickVerify(t *testing.T) {
+	b := readTestIBFT(t)
+	if err := QuickVerify(b); err != nil {
+		t.Errorf("QuickVerify: got %v, want nil", err)
+	}
+	if err := QuickVerify(append(append([]byte{}, b...), 0xff, 0xff)); err != nil {
+		t.Errorf("QuickVerify with trailing bytes: got %v, want nil", err)
+	}
+	var tests = []struct {
+		n string
+		f func([]byte) []byte
+	}{
+		{"Short", func(b []byte) []byte { return b[:4] }},
+		{"Signature", func(b []byte) []byte { b[0] = 0; b[CSUMOffset]++; return b }},
+		{"Long Length", func(b []byte) []byte { return b[:len(b)-1] }},
+		{"Short Length", func(b []byte) []byte { b[LengthOffset] = 2; return b }},
+		{"Checksum", func(b []byte) []byte { b[len(b)-1]++; return b }},
+	}
+	for _, tt := range tests {
+		if err := QuickVerify(tt.f(append([]byte{}, b...))); err == nil {
+			t.Errorf("QuickVerify %s: got nil, want error", tt.n)
+		}
+	}
+}
+
+func BenchmarkQuickVerify(b *testing.B) {
+	t, err := Marshal(testIBFT())
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < b.N; i++ {
+		if err := QuickVerify(t); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkIBFTUnmarshal(b *testing.B) {
+	t, err := Marshal(testIBFT())
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < b.N; i++ {
+		if err := (&IBFT{}).Unmarshal(t); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestHexUpper(t *testing.T) {
+	defer func(u bool) { HexUpper = u }(HexUpper)
+	b := readTestIBFT(t)
+	var tests = []struct {
+		upper bool
+		bdf   string
+		mac   string
+	}{
+		{false, "0xab", "00:0c:29:12:a4:2e"},
+		{true, "0xAB", "00:0C:29:12:A4:2E"},
+	}
+	for _, tt := range tests {
+		HexUpper = tt.upper
+		if got := bdf("0xab").String(); got != tt.bdf {
+			t.Errorf("HexUpper %v: bdf String(): got %q, want %q", tt.upper, got, tt.bdf)
+		}
+		// What Unmarshal stores does not change; how it is shown does.
+		i := &IBFT{}
+		if err := i.Unmarshal(b); err != nil {
+			t.Fatalf("Unmarshal: got %v, want nil", err)
+		}
+		if want := "00:0c:29:12:a4:2e"; string(i.NIC0.MACAddress) != want {
+			t.Errorf("HexUpper %v: NIC0.MACAddress: got %q, want %q", tt.upper, string(i.NIC0.MACAddress), want)
+		}
+		if got := i.NIC0.MACAddress.String(); got != tt.mac {
+			t.Errorf("HexUpper %v: NIC0.MACAddress.String(): got %q, want %q", tt.upper, got, tt.mac)
+		}
+		j, err := json.Marshal(i.NIC0)
+		if err != nil {
+			t.Fatalf("json.Marshal: got %v, want nil", err)
+		}
+		if want := `"MACAddress":"` + tt.mac + `"`; !strings.Contains(string(j), want) {
+			t.Errorf("HexUpper %v: JSON: got %s, want %s in it", tt.upper, j, want)
+		}
+	}
+}
+
+func TestFieldDescriptions(t *testing.T) {
+	for _, tt := range []struct {
+		n   string
+		len uint16
+	}{
+		{"Header", ibftHeaderLen},
+		{"Control", ibftControlLen},
+		{"Initiator", ibftInitiatorLen},
+		{"NIC", ibftNICLen},
+		{"Target", ibftTargetLen},
+	} {
+		fds := FieldDescriptions(IBFTStructures[tt.n])
+		if len(fds) == 0 {
+			t.Errorf("%s: got no fields, want some", tt.n)
+			continue
+		}
+		// The fields are in order, with no gaps or overlaps.
+		next := 0
+		for _, fd := range fds {
+			if fd.Offset != next {
+				t.Errorf("%s.%s: Offset got %d, want %d", tt.n, fd.Name, fd.Offset, next)
+			}
+			next = fd.Offset + fd.Size
+		}
+		if next != int(tt.len) {
+			t.Errorf("%s: got length %d, want %d", tt.n, next, tt.len)
+		}
+	}
+
+	fds := FieldDescriptions(&acpiIBFTNIC{})
+	var got FieldDesc
+	for _, fd := range fds {
+		if fd.Name == "HostNameOffset" {
+			got = fd
+		}
+	}
+	if got.Offset != 100 || got.Size != 2 || !strings.Contains(got.Desc, "Option 12") {
+		t.Errorf("NIC HostNameOffset: got %+v, want offset 100, size 2, and a desc about Option 12", got)
+	}
+	if fds := FieldDescriptions(1); fds != nil {
+		t.Errorf("FieldDescriptions(1): got %v, want nil", fds)
+	}
+}
+
+func TestHash(t *testing.T) {
+	b := genssdt([]byte{1, 2, 3, 4})
+	a, err := NewRaw(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := a.CheckSum()
+	b = append([]byte{}, b...)
+	b[CSUMOffset]++
+	c, err := NewRaw(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Hash(a) != Hash(c) {
+		t.Errorf("Hash of tables differing only in checksum: got %x and %x, want them equal", Hash(a), Hash(c))
+	}
+	b = append([]byte{}, b...)
+	b[len(b)-1]++
+	d, err := NewRaw(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Hash(a) == Hash(d) {
+		t.Errorf("Hash of tables differing in data: got %x for both, want them different", Hash(a))
+	}
+	if a.AllData()[CSUMOffset] != sum {
+		t.Errorf("Hash changed the table's checksum byte")
+	}
+}
+
+func TestLogHeapAlloc(t *testing.T) {
+	defer func(f func(HeapAlloc)) { LogHeapAlloc = f }(LogHeapAlloc)
+	var got []HeapAlloc
+	LogHeapAlloc = func(a HeapAlloc) { got = append(got, a) }
+
+	i := testIBFT()
+	i.Initiator.Name = "iqn.2009-06.com.example:initiator"
+	b, l, err := i.MarshalVerbose()
+	if err != nil {
+		t.Fatalf("MarshalVerbose: got %v, want nil", err)
+	}
+	// The heap entries are the Layout entries after the structures.
+	heap := l[7:]
+	if len(got) != len(heap) {
+		t.Fatalf("LogHeapAlloc: got %d calls, want %d", len(got), len(heap))
+	}
+	for j, e := range heap {
+		a := got[j]
+		// The Layout includes the NUL after a name; the entry does not.
+		if HeapNUL[heapKind(e.Name)] {
+			e.Len--
+		}
+		if a.Field != e.Name || a.Offset != e.Offset || a.Len != e.Len {
+			t.Errorf("LogHeapAlloc %d: got %v, want field=%s offset=%#x len=%d", j, a, e.Name, e.Offset, e.Len)
+		}
+		want := string(b[e.Offset : e.Offset+e.Len])
+		switch {
+		case strings.HasSuffix(e.Name, "Secret"):
+			want = "<redacted>"
+		case len(want) > heapPreviewLen:
+			want = want[:heapPreviewLen] + "..."
+		}
+		if a.Preview != want {
+			t.Errorf("%s: got preview %q, want %q", e.Name, a.Preview, want)
+		}
+	}
+}
+
+func TestHeapNUL(t *testing.T) {
+	defer func(n, s bool) { HeapNUL[HeapName], HeapNUL[HeapSecret] = n, s }(HeapNUL[HeapName], HeapNUL[HeapSecret])
+	var tests = []struct {
+		name, secret bool
+		want         string
+	}{
+		{true, false, "clown\x00\x01\x00\x02"},
+		{false, false, "clown\x01\x00\x02"},
+		{true, true, "clown\x00\x01\x00\x02\x00"},
+		{false, true, "clown\x01\x00\x02\x00"},
+	}
+	for _, tt := range tests {
+		HeapNUL[HeapName], HeapNUL[HeapSecret] = tt.name, tt.secret
+		h := HeapTable{Head: &bytes.Buffer{}, Heap: &bytes.Buffer{}, HeapBase: 0x100}
+		for _, f := range []struct {
+			n string
+			s sheap
+		}{{"Target0.CHAPName", "clown"}, {"Target0.CHAPSecret", "\x01\x00\x02"}} {
+			h.Field = f.n
+			if err := h.Marshal(f.s); err != nil {
+				t.Fatalf("Marshal(%q): got %v, want nil", f.s, err)
+			}
+		}
+		if got := h.Heap.String(); got != tt.want {
+			t.Errorf("HeapNUL names %v, secrets %v: heap got %q, want %q", tt.name, tt.secret, got, tt.want)
+		}
+		// The lengths do not include the NUL.
+		want := []byte{5, 0, 0, 1, 3, 0, 5, 1}
+		if tt.name {
+			want[6]++
+		}
+		if got := h.Head.Bytes(); !bytes.Equal(got, want) {
+			t.Errorf("HeapNUL names %v, secrets %v: head got %#x, want %#x", tt.name, tt.secret, got, want)
+		}
+	}
+}
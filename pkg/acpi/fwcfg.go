@@ -0,0 +1,244 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acpi
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// QEMU gives a guest its ACPI tables through fw_cfg files, not memory.
+// The tables are in FwCfgTablesFile, and the RSDP in FwCfgRSDPFile;
+// since QEMU does not know where firmware will put them, pointers in
+// them are offsets, and FwCfgLoaderFile has commands telling firmware
+// how to allocate them, fix the pointers up, and fill in the checksums.
+// See hw/acpi/bios-linker-loader.c in QEMU.
+const (
+	FwCfgTablesFile = "etc/acpi/tables"
+	FwCfgRSDPFile   = "etc/acpi/rsdp"
+	FwCfgLoaderFile = "etc/table-loader"
+)
+
+// Table loader commands. Each is a 4 byte command, then its
+// arguments, padded to loaderEntryLen bytes.
+const (
+	loaderAllocate    uint32 = 1
+	loaderAddPointer  uint32 = 2
+	loaderAddChecksum uint32 = 3
+
+	loaderEntryLen = 128
+	loaderFileLen  = 56
+
+	// Allocation zones: anywhere, or the F segment, below 1MiB,
+	// where the RSDP has to be.
+	loaderZoneHigh uint8 = 1
+	loaderZoneFSeg uint8 = 2
+)
+
+// TableLoader builds the blob of commands in FwCfgLoaderFile.
+// Commands are run in the order they are added, so pointers must be
+// added before the checksums which cover them.
+type TableLoader struct {
+	b bytes.Buffer
+}
+
+// loaderFile returns a file name as it is in a command: NUL padded
+// to loaderFileLen bytes.
+func loaderFile(f string) ([]byte, error) {
+	if len(f) >= loaderFileLen {
+		return nil, fmt.Errorf("fw_cfg file name %q is %d bytes, must be less than %d", f, len(f), loaderFileLen)
+	}
+	b := make([]byte, loaderFileLen)
+	copy(b, f)
+	return b, nil
+}
+
+// add adds a command, and pads it out to loaderEntryLen.
+func (l *TableLoader) add(cmd uint32, files []string, args ...interface{}) error {
+	var e bytes.Buffer
+	w(&e, cmd)
+	for _, f := range files {
+		b, err := loaderFile(f)
+		if err != nil {
+			return err
+		}
+		w(&e, b)
+	}
+	w(&e, args...)
+	e.Write(make([]byte, loaderEntryLen-e.Len()))
+	l.b.Write(e.Bytes())
+	return nil
+}
+
+// Allocate adds a command to allocate memory for file, aligned to
+// align, and load file into it. If fseg is true, the memory is in the
+// F segment, as an RSDP must be.
+func (l *TableLoader) Allocate(file string, align uint32, fseg bool) error {
+	z := loaderZoneHigh
+	if fseg {
+		z = loaderZoneFSeg
+	}
+	return l.add(loaderAllocate, []string{file}, align, z)
+}
+
+// AddPointer adds a command to add the address of src to the size
+// byte pointer at offset in dest. The pointer should hold the offset
+// in src which it points to.
+func (l *TableLoader) AddPointer(dest, src string, offset uint32, size uint8) error {
+	switch size {
+	case 1, 2, 4, 8:
+	default:
+		return fmt.Errorf("AddPointer: size %d must be 1, 2, 4 or 8", size)
+	}
+	return l.add(loaderAddPointer, []string{dest, src}, offset, size)
+}
+
+// AddChecksum adds a command to fix the checksum byte at offset in file
+// so that the length bytes at start sum to zero.
+func (l *TableLoader) AddChecksum(file string, offset, start, length uint32) error {
+	return l.add(loaderAddChecksum, []string{file}, offset, start, length)
+}
+
+// Bytes returns the commands, the contents of FwCfgLoaderFile.
+func (l *TableLoader) Bytes() []byte {
+	return l.b.Bytes()
+}
+
+// FwCfg is a TableSet laid out for QEMU's fw_cfg, e.g. for
+// -fw_cfg name=etc/acpi/tables,file=...
+type FwCfg struct {
+	// Tables is the contents of FwCfgTablesFile: the XSDT, the RSDT
+	// if there is one, and the tables.
+	Tables []byte
+	// RSDP is the contents of FwCfgRSDPFile.
+	RSDP []byte
+	// Loader is the contents of FwCfgLoaderFile.
+	Loader []byte
+	// Offsets maps a signature to its offset in Tables, as the map
+	// from Assemble does to an address. The RSDP is not included.
+	Offsets map[string]uint64
+}
+
+// FwCfg lays out the TableSet for QEMU's fw_cfg, as Assemble does for
+// memory, but with pointers as offsets in Tables, and a table loader
+// to fix them up and fill in the checksums, e.g. of the IBFT. The
+// checksums are 0 until then: firmware, e.g. OVMF, sets a checksum to
+// the checksum of its bytes, the checksum itself included.
+func (ts *TableSet) FwCfg() (*FwCfg, error) {
+	x, rs, rev, err := ts.roots()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		roots      []*SDT
+		rootOffs   []uint64
+		ptrSizes   []uint8
+		next       uint64
+		tableAlign = uint32(64)
+	)
+	for _, s := range []struct {
+		s *SDT
+		n uint8
+	}{{x, 8}, {rs, 4}} {
+		if s.s == nil {
+			continue
+		}
+		roots, rootOffs, ptrSizes = append(roots, s.s), append(rootOffs, next), append(ptrSizes, s.n)
+		next += HeaderLength + uint64(int(s.n)*len(ts.Tables))
+	}
+
+	align := ts.Align
+	if align == 0 {
+		align = 1
+	}
+	if align > int(tableAlign) {
+		tableAlign = uint32(align)
+	}
+	pl, err := Place(next, ts.Tables, align)
+	if err != nil {
+		return nil, fmt.Errorf("TableSet: %v", err)
+	}
+	f := &FwCfg{Offsets: map[string]uint64{}}
+	var ptrs []int64
+	for _, p := range pl {
+		if _, ok := f.Offsets[string(p.data[:4])]; !ok {
+			f.Offsets[string(p.data[:4])] = p.Address
+		}
+		ptrs = append(ptrs, int64(p.Address))
+	}
+
+	var l TableLoader
+	if err := l.Allocate(FwCfgRSDPFile, 16, true); err != nil {
+		return nil, err
+	}
+	if err := l.Allocate(FwCfgTablesFile, tableAlign, false); err != nil {
+		return nil, err
+	}
+	var xoff uint64
+	var rsoff uint32
+	for i, s := range roots {
+		s.Tables = append([]int64{}, ptrs...)
+		sb, err := Marshal(s)
+		if err != nil {
+			return nil, fmt.Errorf("TableSet %s: %v", s.Sig(), err)
+		}
+		f.Offsets[string(sb[:4])] = rootOffs[i]
+		if s == x {
+			xoff = rootOffs[i]
+		} else {
+			rsoff = uint32(rootOffs[i])
+		}
+		f.Tables = append(f.Tables, sb...)
+		f.Tables[rootOffs[i]+CSUMOffset] = 0
+		for j := range ptrs {
+			o := rootOffs[i] + HeaderLength + uint64(j)*uint64(ptrSizes[i])
+			if err := l.AddPointer(FwCfgTablesFile, FwCfgTablesFile, uint32(o), ptrSizes[i]); err != nil {
+				return nil, err
+			}
+		}
+		if err := l.AddChecksum(FwCfgTablesFile, uint32(rootOffs[i])+CSUMOffset, uint32(rootOffs[i]), uint32(len(sb))); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range pl {
+		f.Tables = append(f.Tables, make([]byte, p.Address-uint64(len(f.Tables)))...)
+		f.Tables = append(f.Tables, p.data...)
+		f.Tables[p.Address+CSUMOffset] = 0
+		if err := l.AddChecksum(FwCfgTablesFile, uint32(p.Address)+CSUMOffset, uint32(p.Address), uint32(p.Size)); err != nil {
+			return nil, err
+		}
+	}
+
+	// The RSDP points to the roots, and has two checksums: one of
+	// the ACPI 1.0 part, and one of all of it.
+	f.RSDP = newRSDP(rev, rsoff, xoff)
+	if rev == 0 {
+		f.RSDP = f.RSDP[:rSDPV1Len]
+	} else {
+		f.RSDP[cSUM2Off] = 0
+	}
+	f.RSDP[cSUM1Off] = 0
+	if rs != nil {
+		if err := l.AddPointer(FwCfgRSDPFile, FwCfgTablesFile, rSDTAddrOff, 4); err != nil {
+			return nil, err
+		}
+	}
+	if x != nil {
+		if err := l.AddPointer(FwCfgRSDPFile, FwCfgTablesFile, xSDTAddrOff, 8); err != nil {
+			return nil, err
+		}
+	}
+	if err := l.AddChecksum(FwCfgRSDPFile, cSUM1Off, 0, rSDPV1Len); err != nil {
+		return nil, err
+	}
+	if rev != 0 {
+		if err := l.AddChecksum(FwCfgRSDPFile, cSUM2Off, 0, HeaderLength); err != nil {
+			return nil, err
+		}
+	}
+	f.Loader = l.Bytes()
+	Debug("FwCfg: %d tables, %d bytes, %d loader commands", len(pl), len(f.Tables), len(f.Loader)/loaderEntryLen)
+	return f, nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acpi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// runLoader runs the table loader commands in l, as firmware would,
+// on files, which are loaded at the addresses in addrs. Like OVMF, it
+// sets a checksum, rather than fixing it, so it must start at 0.
+func runLoader(t *testing.T, l []byte, files map[string][]byte, addrs map[string]uint64) {
+	if len(l)%loaderEntryLen != 0 {
+		t.Fatalf("Loader: got %d bytes, want a multiple of %d", len(l), loaderEntryLen)
+	}
+	file := func(b []byte) string {
+		return string(bytes.TrimRight(b[:loaderFileLen], "\x00"))
+	}
+	for ; len(l) > 0; l = l[loaderEntryLen:] {
+		e := l[:loaderEntryLen]
+		switch binary.LittleEndian.Uint32(e) {
+		case loaderAllocate:
+			f := file(e[4:])
+			if _, ok := files[f]; !ok {
+				t.Fatalf("Allocate %q: no such file", f)
+			}
+			if a := uint64(binary.LittleEndian.Uint32(e[60:])); addrs[f]%a != 0 {
+				t.Errorf("Allocate %q: address %#x is not aligned to %d", f, addrs[f], a)
+			}
+		case loaderAddPointer:
+			d, s := file(e[4:]), file(e[60:])
+			o, sz := binary.LittleEndian.Uint32(e[116:]), e[120]
+			var p [8]byte
+			copy(p[:sz], files[d][o:])
+			binary.LittleEndian.PutUint64(p[:], binary.LittleEndian.Uint64(p[:])+addrs[s])
+			copy(files[d][o:o+uint32(sz)], p[:])
+		case loaderAddChecksum:
+			f := files[file(e[4:])]
+			o, s, n := binary.LittleEndian.Uint32(e[60:]), binary.LittleEndian.Uint32(e[64:]), binary.LittleEndian.Uint32(e[68:])
+			f[o] = gencsum(f[s : s+n])
+		default:
+			t.Fatalf("Loader command %d: unknown", binary.LittleEndian.Uint32(e))
+		}
+	}
+}
+
+func TestTableSetFwCfg(t *testing.T) {
+	for _, rev := range []uint8{1, 2} {
+		ts := &TableSet{Revision: rev, Tables: []Marshaler{testIBFT()}}
+		f, err := ts.FwCfg()
+		if err != nil {
+			t.Fatalf("Revision %d: FwCfg: got %v, want nil", rev, err)
+		}
+		addrs := map[string]uint64{FwCfgTablesFile: 0x7fe0000, FwCfgRSDPFile: 0xf5a0}
+		files := map[string][]byte{FwCfgTablesFile: f.Tables, FwCfgRSDPFile: f.RSDP}
+		// The loader fills in the checksums, so they must be 0.
+		for _, sig := range []string{"IBFT", "XSDT", "RSDT"} {
+			if o, ok := f.Offsets[sig]; ok && f.Tables[o+CSUMOffset] != 0 {
+				t.Errorf("Revision %d: %s checksum: got %#x, want 0", rev, sig, f.Tables[o+CSUMOffset])
+			}
+		}
+		for _, o := range []int{cSUM1Off, cSUM2Off} {
+			if o < len(f.RSDP) && f.RSDP[o] != 0 {
+				t.Errorf("Revision %d: RSDP checksum at %d: got %#x, want 0", rev, o, f.RSDP[o])
+			}
+		}
+		runLoader(t, f.Loader, files, addrs)
+
+		if err := VerifyChecksum(f.Tables[f.Offsets["IBFT"]:]); err != nil {
+			t.Errorf("Revision %d: IBFT: %v", rev, err)
+		}
+		if c := gencsum(f.RSDP[:rSDPV1Len]); c != 0 {
+			t.Errorf("Revision %d: RSDP checksum: off by %#x", rev, c)
+		}
+		root, n := "XSDT", 8
+		if rev == 1 {
+			root, n = "RSDT", 4
+			if len(f.RSDP) != rSDPV1Len {
+				t.Errorf("Revision 1: RSDP: got %d bytes, want %d", len(f.RSDP), rSDPV1Len)
+			}
+			if a := binary.LittleEndian.Uint32(f.RSDP[rSDTAddrOff:]); uint64(a) != addrs[FwCfgTablesFile]+f.Offsets[root] {
+				t.Errorf("Revision 1: RSDP RSDT address: got %#x, want %#x", a, addrs[FwCfgTablesFile]+f.Offsets[root])
+			}
+		} else {
+			if c := gencsum(f.RSDP); c != 0 {
+				t.Errorf("Revision 2: RSDP extended checksum: off by %#x", c)
+			}
+			if a := binary.LittleEndian.Uint64(f.RSDP[xSDTAddrOff:]); a != addrs[FwCfgTablesFile]+f.Offsets[root] {
+				t.Errorf("Revision 2: RSDP XSDT address: got %#x, want %#x", a, addrs[FwCfgTablesFile]+f.Offsets[root])
+			}
+		}
+		r := f.Tables[f.Offsets[root] : f.Offsets[root]+HeaderLength+uint64(n*len(ts.Tables))]
+		if err := VerifyChecksum(r); err != nil {
+			t.Errorf("Revision %d: %s: %v", rev, root, err)
+		}
+		var p [8]byte
+		copy(p[:n], r[HeaderLength:])
+		if a, want := binary.LittleEndian.Uint64(p[:]), addrs[FwCfgTablesFile]+f.Offsets["IBFT"]; a != want {
+			t.Errorf("Revision %d: %s IBFT pointer: got %#x, want %#x", rev, root, a, want)
+		}
+	}
+}
+
+func TestTableLoaderErrors(t *testing.T) {
+	var l TableLoader
+	if err := l.AddPointer(FwCfgTablesFile, FwCfgTablesFile, 0, 3); err == nil {
+		t.Errorf("AddPointer with size 3: got nil, want error")
+	}
+	if err := l.Allocate(string(make([]byte, loaderFileLen)), 16, false); err == nil {
+		t.Errorf("Allocate with a %d byte name: got nil, want error", loaderFileLen)
+	}
+	if len(l.Bytes()) != 0 {
+		t.Errorf("Bytes after errors: got %d bytes, want 0", len(l.Bytes()))
+	}
+}
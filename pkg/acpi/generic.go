@@ -17,7 +17,11 @@ type Generic struct {
 	data []byte
 }
 
-var _ = Tabler(&Generic{})
+var (
+	_ = Tabler(&Generic{})
+	_ = Marshaler(&Generic{})
+	_ = Unmarshaler(&Generic{})
+)
 
 // NewGeneric creates a new Generic table from a byte slice.
 func NewGeneric(b []byte) (Tabler, error) {
@@ -47,6 +51,16 @@ func (g *Generic) Marshal() ([]byte, error) {
 	return h, nil
 }
 
+// Unmarshal unmarshals a byte slice into a Generic table.
+func (g *Generic) Unmarshal(b []byte) error {
+	t, err := NewRaw(b)
+	if err != nil {
+		return err
+	}
+	*g = Generic{Header: *GetHeader(t), data: t.AllData()}
+	return nil
+}
+
 // Len returns the length of an entire table.
 func (g *Generic) Len() uint32 {
 	return uint32(len(g.data))
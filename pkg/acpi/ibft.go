@@ -6,6 +6,7 @@ package acpi
 
 // The IBFT is another brain-dead design.
 // Lots of flexibility, which is basically impossible to use due to lots of limits.
+// The real ones are checked by Validate, which returns a LimitError.
 // Basic layout is like this:
 //	Name		size		offset		description
 //	Header		48		0		Primary Header
@@ -17,12 +18,21 @@ package acpi
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
+	"math"
+	"net"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 )
 
+// StructureID is the ID byte in the header of an IBFT structure.
+type StructureID uint8
+
 const (
-	reserved uint8 = iota
+	reserved StructureID = iota
 	ibftControl
 	ibftInitiator
 	ibftNIC
@@ -30,6 +40,26 @@ const (
 	ibftExtensions
 )
 
+// String returns the name of the structure, e.g. NIC, or unknown for
+// IDs the spec does not define, e.g. vendor-specific ones.
+func (id StructureID) String() string {
+	switch id {
+	case reserved:
+		return "Reserved"
+	case ibftControl:
+		return "Control"
+	case ibftInitiator:
+		return "Initiator"
+	case ibftNIC:
+		return "NIC"
+	case ibftTarget:
+		return "Target"
+	case ibftExtensions:
+		return "Extensions"
+	}
+	return "unknown"
+}
+
 const (
 	ibftHeaderLen    uint16 = 48
 	ibftControlLen   uint16 = 18
@@ -43,6 +73,12 @@ const (
 	ibftVersion uint8 = 1 // in all cases since 2009
 )
 
+// headersLen is the length Marshal checks the header and structures
+// add up to. It is the most important invariant in marshaling: if it
+// is wrong, all the pointers are wrong. It is a variable only so tests
+// can make the check fail.
+var headersLen = int(ibftHeadersLen)
+
 // We created a bunch of structs here so you don't have to go read the Big Bad Book of ACPI.
 // It turned out to be easier to use the structs we defined further below.
 // These structs were generated by running scripts across the pdf.
@@ -58,7 +94,7 @@ type acpiIBFTHeader struct {
 	Reserved   [24]byte `offset:"24" desc:"Reserved"`
 }
 
-var rawIBTFHeader = "IBFT\x00\x08\x00\x001\x00ACPIXXACPISUCK1234567890abcdef01234567"
+var rawIBTFHeader = "IBFT\x00\x08\x00\x001\x00ACPIXXACPISUCK\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"
 
 // acpiIBFTStructHeader defines the common components of the structure headers.
 // In the standard, IBM made the flags common, even though the values
@@ -66,10 +102,10 @@ var rawIBTFHeader = "IBFT\x00\x08\x00\x001\x00ACPIXXACPISUCK1234567890abcdef0123
 // In a fit of genius, whoever designed this made Bit 0 sometimes
 // mean valid, sometimes not. Awesome!
 type acpiIBFTStructHeader struct {
-	ID      byte   `offset:"0" desc:"ID"`
-	Version uint8  `offset:"1" desc:"Structure Version"`
-	Length  uint16 `offset:"2" desc:"Structure Length"`
-	Index   uint8  `offset:"4" desc:"Index"`
+	ID      StructureID `offset:"0" desc:"ID"`
+	Version uint8       `offset:"1" desc:"Structure Version"`
+	Length  uint16      `offset:"2" desc:"Structure Length"`
+	Index   uint8       `offset:"4" desc:"Index"`
 }
 
 type acpiIBFTControlFlags uint8
@@ -81,7 +117,7 @@ const (
 
 type acpiIBFTControl struct {
 	acpiIBFTStructHeader
-	Flags      acpiIBFTControlFlags `offset:"0" desc:"Bit 0 : Target Login Mode Control 0 = Multi-Login Mode 1 = Single Login Mode"`
+	Flags      acpiIBFTControlFlags `offset:"5" desc:"Bit 0 : Target Login Mode Control 0 = Multi-Login Mode 1 = Single Login Mode"`
 	Extensions uint16               `offset:"6" desc:"Optional. If unused must be zero. If used, must point to an Extensions Structure with a standard Structure header."`
 	Initiator  uint16               `offset:"8" desc:""`
 	NIC0       uint16               `offset:"10" desc:""`
@@ -91,15 +127,20 @@ type acpiIBFTControl struct {
 }
 
 // pre-filled-in control structure.
+// The pointers here are for packed structures, with no padding between
+// them; marshaling replaces them with ones for IBFTStructAlign. The
+// control Length is just the size of the control structure.
+// Length must not be longer than the space before the initiator:
+// Linux, for one, uses Length to decide how many pointers there are,
+// and if Length runs into the initiator, the initiator becomes pointers.
 var control = acpiIBFTControl{
 	acpiIBFTStructHeader: acpiIBFTStructHeader{
 		ID:      ibftControl,
 		Version: 1,
-		Length:  80, // round the next struct to 128 bytes
+		Length:  ibftControlLen,
 		Index:   0,
 	},
-	Flags: ibftSingleLogin,
-	// Just 64-byte align everything. Anything else is painful.
+	Flags:      ibftSingleLogin,
 	Extensions: 0,
 	Initiator:  ibftHeaderLen + ibftControlLen,
 	NIC0:       ibftHeaderLen + ibftControlLen + ibftInitiatorLen,
@@ -118,7 +159,7 @@ const (
 
 type acpiIBFTInitiator struct {
 	acpiIBFTStructHeader
-	Flags                 acpiIBFTInitiatorFlags `desc:"Bit0:  block valid flag 0 = no, 1 = yes Bit1 : Firmware Boot Selected Flag 0 = no, 1 = yes"`
+	Flags                 acpiIBFTInitiatorFlags `offset:"5" desc:"Bit0:  block valid flag 0 = no, 1 = yes Bit1 : Firmware Boot Selected Flag 0 = no, 1 = yes"`
 	iSNSServer            [16]uint8              `offset:"6" desc:"IP Address"`
 	SLPServer             [16]uint8              `offset:"22" desc:"IP Address"`
 	PrimaryRadiusServer   [16]uint8              `offset:"38" desc:"IP Address"`
@@ -137,8 +178,9 @@ const (
 
 type acpiIBFTNIC struct {
 	acpiIBFTStructHeader
-	Flags          acpiIBFTNICFlags `desc:"Bit0:  block valid flag 0 = no, 1 = yes Bit1 : Firmware Boot Selected Flag 0 = no, 1 = yes Bit2 : Global / Link Local 0 = Link Local, 1 = Global"`
-	IPAddress      [16]uint8        `offset:"6" desc:"IP Address Subnet Mask Prefix 1 22 The mask prefix length. For example, 255.255.255.0 has a prefix length of 24"`
+	Flags          acpiIBFTNICFlags `offset:"5" desc:"Bit0:  block valid flag 0 = no, 1 = yes Bit1 : Firmware Boot Selected Flag 0 = no, 1 = yes Bit2 : Global / Link Local 0 = Link Local, 1 = Global"`
+	IPAddress      [16]uint8        `offset:"6" desc:"IP Address"`
+	SubnetMask     uint8            `offset:"22" desc:"Subnet Mask Prefix. The mask prefix length. For example, 255.255.255.0 has a prefix length of 24"`
 	Origin         uint8            `offset:"23" desc:"See [origin]"`
 	Gateway        [16]uint8        `offset:"24" desc:"IP Address"`
 	PrimaryDNS     [16]uint8        `offset:"40" desc:"IP Address"`
@@ -162,7 +204,7 @@ const (
 
 type acpiIBFTTarget struct {
 	acpiIBFTStructHeader
-	Flags                   acpiIBFTTargetFlags `desc:"Bit0: block valid flag 0 = no, 1 = yes Bit1 : Firmware Boot Selected Flag 0 = no, 1 = yes Bit2 : Use Radius CHAP 0 = no, 1 = yes Bit3 : Use Radius rCHAP 0 = no, 1 = yes"`
+	Flags                   acpiIBFTTargetFlags `offset:"5" desc:"Bit0: block valid flag 0 = no, 1 = yes Bit1 : Firmware Boot Selected Flag 0 = no, 1 = yes Bit2 : Use Radius CHAP 0 = no, 1 = yes Bit3 : Use Radius rCHAP 0 = no, 1 = yes"`
 	TargetIPAddress         [16]uint8           `offset:"6" desc:"IP Address"`
 	TargetIPSocket          uint16              `offset:"22" desc:"Likely 3260"`
 	TargetBootLUN           uint64              `offset:"24" desc:"See [iscsi] Little Endian Quad Word"`
@@ -180,6 +222,16 @@ type acpiIBFTTarget struct {
 	ReverseCHAPSecretOffset uint16              `offset:"52" desc:"Offset from the beginning of the iBFT"`
 }
 
+// IBFTStructures are the raw IBFT structures, by name, for
+// FieldDescriptions, e.g. for tools which document the table.
+var IBFTStructures = map[string]interface{}{
+	"Header":    acpiIBFTHeader{},
+	"Control":   acpiIBFTControl{},
+	"Initiator": acpiIBFTInitiator{},
+	"NIC":       acpiIBFTNIC{},
+	"Target":    acpiIBFTTarget{},
+}
+
 // The structs below are designed to be JSON friendly.
 // Things are strings, but they are typed, to make marshaling
 // to a table easy and setting up initialized values easy.
@@ -192,13 +244,40 @@ type acpiIBFTTarget struct {
 
 // IBFTInitiator defines an initiator
 type IBFTInitiator struct {
-	Valid                 flag
+	// Valid is bit 0 of the initiator flags: the block is valid.
+	Valid flag
+	// Boot is bit 1 of the initiator flags: Firmware Boot Selected,
+	// i.e. firmware used this initiator to boot. It is not
+	// related to the Boot flags in the NIC and Target.
 	Boot                  flag
 	SNSServer             ipaddr
 	SLPServer             ipaddr
 	PrimaryRadiusServer   ipaddr
 	SecondaryRadiusServer ipaddr
 	Name                  sheap
+	// ReservedFlags are the flags bits the spec reserves, bits 2-7.
+	// Unmarshal keeps them, and Marshal writes them, so that a table
+	// from firmware which sets them is reproduced exactly.
+	ReservedFlags uint8 `json:"-" ibft:"-"`
+}
+
+// FirmwareBoot returns true if the Firmware Boot Selected flag is set.
+func (i *IBFTInitiator) FirmwareBoot() bool {
+	return i.Boot == "1"
+}
+
+// SetFirmwareBoot sets or clears the Firmware Boot Selected flag, bit 1,
+// independently of the Valid flag.
+func (i *IBFTInitiator) SetFirmwareBoot(b bool) {
+	i.Boot = boolFlag(b)
+}
+
+// boolFlag returns the flag for a bool.
+func boolFlag(b bool) flag {
+	if b {
+		return "1"
+	}
+	return "0"
 }
 
 // IBFTNIC defines an IBFT NIC structure.
@@ -213,14 +292,75 @@ type IBFTNIC struct {
 	Gateway      ipaddr
 	PrimaryDNS   ipaddr
 	SecondaryDNS ipaddr
-	DHCP         ipaddr
-	VLAN         u16
-	MACAddress   mac
-	PCIBDF       bdf
-	HostName     sheap
+	// DHCP is the address of the DHCP server the NIC was configured
+	// by. It is all zeros for a NIC configured statically. In JSON, a
+	// DHCP which is not set is left out, so that it can be told apart
+	// from one which is present but zero, e.g. "0.0.0.0".
+	DHCP       ipaddr `json:",omitempty"`
+	VLAN       u16
+	MACAddress mac
+	PCIBDF     bdf
+	HostName   sheap
+	// ReservedFlags are the flags bits the spec reserves, bits 3-7,
+	// kept as in IBFTInitiator.
+	ReservedFlags uint8 `json:"-" ibft:"-"`
+}
+
+// IBFTOrigin is the origin of a NIC's address, from the Origin field.
+// The IBFT uses the values of the Windows NL_PREFIX_ORIGIN enumeration.
+type IBFTOrigin uint8
+
+// IBFT origins.
+const (
+	OriginOther               IBFTOrigin = 0
+	OriginManual              IBFTOrigin = 1
+	OriginWellKnown           IBFTOrigin = 2
+	OriginDHCP                IBFTOrigin = 3
+	OriginRouterAdvertisement IBFTOrigin = 4
+	OriginUnchanged           IBFTOrigin = 16
+)
+
+var originNames = map[IBFTOrigin]string{
+	OriginOther:               "Other",
+	OriginManual:              "Manual",
+	OriginWellKnown:           "WellKnown",
+	OriginDHCP:                "DHCP",
+	OriginRouterAdvertisement: "RouterAdvertisement",
+	OriginUnchanged:           "Unchanged",
+}
+
+// String returns the origin with its name from the spec, e.g. "3 = DHCP".
+func (o IBFTOrigin) String() string {
+	n, ok := originNames[o]
+	if !ok {
+		n = "unknown"
+	}
+	return fmt.Sprintf("%d = %s", uint8(o), n)
+}
+
+// ibftOriginDHCP is the Origin of an address from DHCP.
+const ibftOriginDHCP u8 = "3"
+
+// IsStatic returns true if the NIC was configured statically, i.e. its
+// DHCP server address is not set or is all zeros.
+func (n IBFTNIC) IsStatic() bool {
+	return n.DHCP == "" || net.ParseIP(string(n.DHCP)).IsUnspecified()
+}
+
+// IsGlobal returns true if the Global flag, bit 2 of the NIC flags, is
+// set: the NIC's address is global, rather than link local.
+func (n IBFTNIC) IsGlobal() bool {
+	return n.Global == "1"
 }
 
 // IBFTTarget defines an IBFT target, a.k.a. server
+//
+// The table has the target address and port in separate fields, which
+// we combine in TargetIP. TargetPort is for when it is more convenient
+// to set the port separately. If it is set, TargetIP may leave out the
+// port; if TargetIP has a port too, they must agree, or Marshal and
+// Validate return an error. TargetPort is not part of the table, so
+// Unmarshal puts the port in TargetIP, and leaves TargetPort unset.
 type IBFTTarget struct {
 	Valid             flag
 	Boot              flag
@@ -228,7 +368,8 @@ type IBFTTarget struct {
 	RCHAP             flag     // can you do both? Standard implies yes.
 	Index             flag     // 0 or 1
 	TargetIP          sockaddr // in host:port format
-	BootLUN           u64
+	TargetPort        u16      `json:",omitempty" ibft:"-"`
+	BootLUN           lun      // a number, or 16 hex digits; see parseLUN
 	ChapType          u8
 	Association       u8
 	TargetName        sheap
@@ -236,54 +377,662 @@ type IBFTTarget struct {
 	CHAPSecret        sheap
 	ReverseCHAPName   sheap
 	ReverseCHAPSecret sheap
+	// ReservedFlags are the flags bits the spec reserves, bits 4-7,
+	// kept as in IBFTInitiator.
+	ReservedFlags uint8 `json:"-" ibft:"-"`
+}
+
+// socket returns the TargetIP, in host:port format, with the port from
+// TargetPort if it is set. It is an error for TargetIP and TargetPort to
+// have different ports.
+func (t *IBFTTarget) socket() (sockaddr, error) {
+	if t.TargetPort == "" {
+		return t.TargetIP, nil
+	}
+	p, err := strconv.ParseUint(string(t.TargetPort), 0, 16)
+	if err != nil {
+		return "", fmt.Errorf("TargetPort %q: %v", t.TargetPort, err)
+	}
+	port := strconv.FormatUint(p, 10)
+	h, ip, err := net.SplitHostPort(string(t.TargetIP))
+	if err != nil {
+		// No port, or an IPv6 address without brackets.
+		return sockaddr(net.JoinHostPort(string(t.TargetIP), port)), nil
+	}
+	if q, err := strconv.ParseUint(ip, 10, 16); err != nil || q != p {
+		return "", fmt.Errorf("TargetIP %q has port %s, but TargetPort is %s", t.TargetIP, ip, t.TargetPort)
+	}
+	return sockaddr(net.JoinHostPort(h, port)), nil
+}
+
+// iscsiPort is the well known iSCSI port, used by SetTargetHost for a
+// TargetIP with no port.
+const iscsiPort = 3260
+
+// hostPort splits the TargetIP into host and port. The port is
+// TargetPort, or iscsiPort, if TargetIP has none.
+func (t *IBFTTarget) hostPort() (string, string) {
+	if h, p, err := net.SplitHostPort(string(t.TargetIP)); err == nil {
+		return h, p
+	}
+	if t.TargetPort != "" {
+		return string(t.TargetIP), string(t.TargetPort)
+	}
+	return string(t.TargetIP), strconv.Itoa(iscsiPort)
+}
+
+// SetTargetHost sets the address in TargetIP, keeping its port, for
+// callers which have the address and port separately, e.g. from
+// iscsiadm. The address must be an IPv4 or IPv6 address, which may be
+// in brackets; a host name can not be put in the table.
+func (t *IBFTTarget) SetTargetHost(h string) error {
+	ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(h, "["), "]"))
+	if ip == nil {
+		return fmt.Errorf("target host %q is not an IP address", h)
+	}
+	_, p := t.hostPort()
+	t.TargetIP = sockaddr(net.JoinHostPort(ip.String(), p))
+	return nil
+}
+
+// SetTargetPort sets the port in TargetIP, keeping its address, and
+// TargetPort, if it is set, so that the two agree.
+func (t *IBFTTarget) SetTargetPort(p uint16) {
+	h, _ := t.hostPort()
+	port := strconv.Itoa(int(p))
+	t.TargetIP = sockaddr(net.JoinHostPort(h, port))
+	if t.TargetPort != "" {
+		t.TargetPort = u16(port)
+	}
 }
 
 // IBFT defines all the bits of an IBFT users might want to set.
 type IBFT struct {
 	Generic
-	// Control
+	// Multi is bit 0 of the control flags, the Target Login Mode.
+	// Despite the name, "1" is Single Login Mode, and "0" Multi-Login.
 	Multi     flag
 	Initiator IBFTInitiator
 	NIC0      IBFTNIC
 	Target0   IBFTTarget
 	NIC1      IBFTNIC
 	Target1   IBFTTarget
+
+	// Reserved is the 24 byte Reserved block of the header, which
+	// the spec says is zero. Some firmware puts things there anyway,
+	// so Unmarshal keeps it, and Marshal writes it back. It is left
+	// out of the JSON.
+	Reserved [24]byte `json:"-" ibft:"-"`
+}
+
+// ValidTargetCount returns how many of the targets are valid, 0, 1 or
+// 2. IBFTReader.ValidTargetCount does the same for a binary table,
+// without unmarshaling it.
+func (ibft *IBFT) ValidTargetCount() int {
+	var n int
+	for _, t := range []*IBFTTarget{&ibft.Target0, &ibft.Target1} {
+		if t.Valid == "1" {
+			n++
+		}
+	}
+	return n
+}
+
+// Redacted replaces a CHAP secret in a redacted IBFT.
+const Redacted = "***"
+
+// redact replaces the target's CHAP secrets, if set, with Redacted.
+// The names are left alone.
+func (t *IBFTTarget) redact() {
+	if t.CHAPSecret != "" {
+		t.CHAPSecret = Redacted
+	}
+	if t.ReverseCHAPSecret != "" {
+		t.ReverseCHAPSecret = Redacted
+	}
+}
+
+// Redact returns a copy of the IBFT with the CHAP secrets replaced
+// with Redacted, suitable for logging or dumping. The raw table data,
+// which holds the secrets too, is dropped from the copy.
+func (ibft *IBFT) Redact() *IBFT {
+	r := ibft.Clone()
+	r.Generic.data = nil
+	r.Target0.redact()
+	r.Target1.redact()
+	return r
+}
+
+// SetTargetCHAPSecret sets the CHAP secret of Target0 or Target1, by
+// index, e.g. to rotate it in a table read by Unmarshal. The raw table
+// data Unmarshal kept, which has the old secret, is dropped, since it
+// no longer matches; Marshal makes a new table, with the heap offsets,
+// Length and checksum to match the new secret.
+func (ibft *IBFT) SetTargetCHAPSecret(index int, secret string) error {
+	var t *IBFTTarget
+	switch index {
+	case 0:
+		t = &ibft.Target0
+	case 1:
+		t = &ibft.Target1
+	default:
+		return fmt.Errorf("IBFT has no Target%d, only Target0 and Target1", index)
+	}
+	t.CHAPSecret = sheap(secret)
+	ibft.Generic.data = nil
+	return nil
+}
+
+// Clone returns a deep copy of the IBFT. The string fields are
+// immutable, so only the byte slice, the raw table data, needs copying.
+func (ibft *IBFT) Clone() *IBFT {
+	c := *ibft
+	if ibft.Generic.data != nil {
+		c.Generic.data = append([]byte{}, ibft.Generic.data...)
+	}
+	return &c
+}
+
+// header returns the IBFT header. Any part of the Generic header
+// which is set overrides the default.
+// The IBFT header has a 24 byte reserved block where most tables have
+// an OEMRevision, CreatorID and CreatorRevision; those are not used,
+// and the block is Reserved.
+func (ibft *IBFT) header() []byte {
+	b := []byte(rawIBTFHeader)
+	if ibft.Header.Sig != "" {
+		copy(b[0:4], ibft.Header.Sig)
+	}
+	if ibft.Header.Revision != 0 {
+		b[8] = ibft.Header.Revision
+	}
+	if ibft.Header.OEMID != "" {
+		copy(b[10:16], make([]byte, 6))
+		copy(b[10:16], ibft.Header.OEMID)
+	}
+	if ibft.Header.OEMTableID != "" {
+		copy(b[16:24], make([]byte, 8))
+		copy(b[16:24], ibft.Header.OEMTableID)
+	}
+	copy(b[24:48], ibft.Reserved[:])
+	return b
 }
 
 // Marshal marshals an IBFT to a byte slice. It is somewhat complicated
 // by the fact that we need to marshal to two things, a header and a heao;
 // and record pointers to the heap in the head.
 func (ibft *IBFT) Marshal() ([]byte, error) {
-	var h = HeapTable{Head: &bytes.Buffer{}, Heap: &bytes.Buffer{}}
-	Debug("IBFT")
-	f, err := flags(ibft.Multi)
+	return ibft.marshal(nil, nil)
+}
+
+// IBFTInternHeap makes IBFT marshaling write strings which appear more
+// than once, e.g. a CHAP name shared by both targets, to the heap once.
+var IBFTInternHeap bool
+
+// IBFTActualLength makes IBFT marshaling set the Length of each
+// structure to the bytes it actually takes up in the table, i.e. up to
+// the next structure, with the padding IBFTStructAlign puts before it,
+// rather than to its length in the spec, e.g. 102 for a NIC. That is
+// what a parser which advances by Length, rather than following the
+// pointers, needs. Linux checks Length against the spec, so it is not
+// the default; with packed structures, the two are the same.
+var IBFTActualLength bool
+
+// IBFTStructAlign is the alignment, from the start of the table, of
+// each structure IBFT marshaling writes, with zeros before a structure
+// to align it, and the control pointers to match. The default, 1, and
+// 0 both pack the structures, as firmware does, and Linux expects.
+// Aligning is opt in: set it to, e.g., 64 for firmware which wants the
+// structures 64-byte aligned. The heap follows the last structure,
+// unaligned.
+var IBFTStructAlign = 1
+
+// ibftStructOffsets returns the offset of each structure in present,
+// given by their index in ibftStructs, one after the other after a
+// control structure which ends at start, aligned to IBFTStructAlign;
+// and where the last one ends, i.e. where the heap starts.
+func ibftStructOffsets(present []int, start uint16) ([]uint16, uint16, error) {
+	align := IBFTStructAlign
+	if align < 0 {
+		return nil, 0, fmt.Errorf("IBFT structure alignment %d must be >= 0", align)
+	}
+	if align == 0 {
+		align = 1
+	}
+	lens := map[string]int{"Initiator": int(ibftInitiatorLen), "NIC0": int(ibftNICLen), "Target0": int(ibftTargetLen), "NIC1": int(ibftNICLen), "Target1": int(ibftTargetLen)}
+	var o []uint16
+	next := int(start)
+	for _, x := range present {
+		if r := next % align; r != 0 {
+			next += align - r
+		}
+		if next+lens[ibftStructs[x]] > math.MaxUint16 {
+			return nil, 0, fmt.Errorf("IBFT structure %s at %#x, aligned to %d, is past the 64 KiB the pointers can reach", ibftStructs[x], next, align)
+		}
+		o = append(o, uint16(next))
+		next += lens[ibftStructs[x]]
+	}
+	return o, uint16(next), nil
+}
+
+// padTo writes zeros to b until its length is a multiple of align.
+// An align of 0, or less, is packed, as 1 is.
+func padTo(b *bytes.Buffer, align int) {
+	if align <= 1 {
+		return
+	}
+	if r := b.Len() % align; r != 0 {
+		b.Write(make([]byte, align-r))
+	}
+}
+
+// LayoutEntry is where one part of a marshaled table went.
+type LayoutEntry struct {
+	Name   string
+	Offset int
+	Len    int
+}
+
+// Layout is where everything in a marshaled IBFT went: the header,
+// control structure, and structures, named as the IBFT fields are,
+// e.g. NIC0, then the heap entries, e.g. NIC0.HostName, including the
+// NUL after the entry, if HeapNUL says there is one. It is sorted by
+// Offset. Empty heap entries take no space, so they are left out.
+type Layout []LayoutEntry
+
+// ibftLayout marshals an IBFT and records its Layout.
+type ibftLayout struct {
+	ibft *IBFT
+	l    Layout
+}
+
+func (i *ibftLayout) Marshal() ([]byte, error) {
+	return i.ibft.marshal(&i.l, nil)
+}
+
+// MarshalVerbose marshals an IBFT, as Marshal does, and returns
+// the Layout of the table as well, from the same pass.
+func (ibft *IBFT) MarshalVerbose() ([]byte, Layout, error) {
+	i := &ibftLayout{ibft: ibft}
+	b, err := Marshal(i)
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.SliceStable(i.l, func(a, b int) bool { return i.l[a].Offset < i.l[b].Offset })
+	return b, i.l, nil
+}
+
+// ibftAnnotated marshals an IBFT and records where each field went.
+type ibftAnnotated struct {
+	ibft *IBFT
+	a    Layout
+}
+
+func (i *ibftAnnotated) Marshal() ([]byte, error) {
+	return i.ibft.marshal(nil, &i.a)
+}
+
+// MarshalAnnotated marshals an IBFT, as Marshal does, and returns where
+// each field went, as it was written, rather than by parsing the table
+// afterwards: the header and control structure fields, e.g.
+// Control.NIC0, each structure's header fields, e.g. NIC0.Flags, and its
+// fields, e.g. NIC0.IPAddress. A heap entry, e.g. NIC0.HostName, has
+// NIC0.HostName.Length and NIC0.HostName.Offset in the structure, and
+// NIC0.HostName in the heap, unless it is empty. The Layout is sorted by
+// Offset. Entries may overlap: an interned heap entry is named for each
+// field which points at it.
+func (ibft *IBFT) MarshalAnnotated() ([]byte, Layout, error) {
+	i := &ibftAnnotated{ibft: ibft}
+	b, err := Marshal(i)
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.SliceStable(i.a, func(a, b int) bool { return i.a[a].Offset < i.a[b].Offset })
+	return b, i.a, nil
+}
+
+// annotateFixed adds an entry to a for each field of the struct type t,
+// at o plus the field's offset tag, with its name prefixed by prefix.
+// Embedded structs are added as if their fields were t's.
+func annotateFixed(a *Layout, prefix string, o int, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous {
+			annotateFixed(a, prefix, o, f.Type)
+			continue
+		}
+		off, _ := strconv.Atoi(f.Tag.Get("offset"))
+		*a = append(*a, LayoutEntry{Name: prefix + f.Name, Offset: o + off, Len: int(f.Type.Size())})
+	}
+}
+
+// marshal marshals an IBFT. If l is not nil, the layout is added to it;
+// if a is not nil, the annotations for MarshalAnnotated are.
+func (ibft *IBFT) marshal(l, a *Layout) ([]byte, error) {
+	c, end, err := ibft.controlStruct()
 	if err != nil {
 		return nil, err
 	}
-	control.Flags = acpiIBFTControlFlags(f)
-	w(h.Head, 1, []byte(rawIBTFHeader), control)
+	var h = HeapTable{Head: &bytes.Buffer{}, Heap: &bytes.Buffer{}, HeapBase: end, Intern: IBFTInternHeap, Annotations: a}
+	Debug("IBFT")
+	w(h.Head, 1, ibft.header(), c)
 	Debug("Done IBFTHeader: head is %d bytes", h.Head.Len())
-	if err := mIBFT(&h, ibft); err != nil {
+	if l != nil {
+		*l = append(*l, LayoutEntry{Name: "Header", Offset: 0, Len: int(ibftHeaderLen)},
+			LayoutEntry{Name: "Control", Offset: int(ibftHeaderLen), Len: int(ibftControlLen)})
+	}
+	if a != nil {
+		annotateFixed(a, "Header.", 0, reflect.TypeOf(acpiIBFTHeader{}))
+		annotateFixed(a, "Control.", int(ibftHeaderLen), reflect.TypeOf(acpiIBFTControl{}))
+	}
+	if err := mIBFT(&h, l, "", ibft); err != nil {
 		return nil, err
 	}
-	if h.Head.Len() != int(ibftHeadersLen) {
-		return nil, fmt.Errorf("Expected headers len is wrong; got %d, want %d", h.Head.Len(), ibftHeadersLen)
+	// headersLen is for packed structures; add any padding.
+	if want := headersLen + int(end-ibftHeadersLen); h.Head.Len() != want {
+		return nil, fmt.Errorf("Expected headers len is wrong; got %d, want %d", h.Head.Len(), want)
 	}
 	w(h.Head, 1, h.Heap.Bytes())
 
 	return h.Head.Bytes(), nil
 }
 
+// ibftStrict marshals an IBFT in the spec's general form.
+type ibftStrict struct {
+	ibft *IBFT
+}
+
+func (i *ibftStrict) Marshal() ([]byte, error) {
+	return i.ibft.marshalStrict()
+}
+
+// ibftStructs are the structures the control structure points to,
+// in the order of the pointers.
+var ibftStructs = []string{"Initiator", "NIC0", "Target0", "NIC1", "Target1"}
+
+// ibftAllStructs are all of ibftStructs, by index, as Marshal writes them.
+var ibftAllStructs = []int{0, 1, 2, 3, 4}
+
+// MarshalStrict marshals an IBFT in the general form of the spec,
+// rather than the fixed layout Marshal uses: only the structures which
+// are valid are in the table, the pointers to the rest are 0, and the
+// control structure has only as many pointers as are needed, and a
+// Length to match. Marshal always has all five structures, and the
+// same control structure, which is simpler, and what Linux expects;
+// MarshalStrict is for parsers which follow the spec to the letter.
+func (ibft *IBFT) MarshalStrict() ([]byte, error) {
+	return Marshal(&ibftStrict{ibft: ibft})
+}
+
+// marshalStrict marshals an IBFT for MarshalStrict.
+func (ibft *IBFT) marshalStrict() ([]byte, error) {
+	v := reflect.ValueOf(ibft).Elem()
+	var (
+		present []int
+		np      int
+	)
+	for x, n := range ibftStructs {
+		if v.FieldByName(n).FieldByName("Valid").String() == "1" {
+			present, np = append(present, x), x+1
+		}
+	}
+	// The control structure has a pointer for each structure up to
+	// the last one present.
+	return ibft.marshalStructs(present, uint16(ibftStructHeaderLen+2+2*np))
+}
+
+// ibftCompact marshals an IBFT without its unused trailing structures.
+type ibftCompact struct {
+	ibft *IBFT
+}
+
+func (i *ibftCompact) Marshal() ([]byte, error) {
+	return i.ibft.marshalCompact()
+}
+
+// MarshalCompact marshals an IBFT as Marshal does, but leaves out
+// NIC1 and Target1, the last two structures, if they are not valid,
+// and sets their pointers in the control structure to 0, for when
+// space is tight. Target1 goes first: NIC1 is only left out if Target1
+// is too, so that everything which is in the table is where Marshal
+// puts it. Only the heap moves. Linux skips structures with a pointer
+// of 0.
+func (ibft *IBFT) MarshalCompact() ([]byte, error) {
+	return Marshal(&ibftCompact{ibft: ibft})
+}
+
+// marshalCompact marshals an IBFT for MarshalCompact.
+func (ibft *IBFT) marshalCompact() ([]byte, error) {
+	v := reflect.ValueOf(ibft).Elem()
+	n := len(ibftStructs)
+	for n > 3 && v.FieldByName(ibftStructs[n-1]).FieldByName("Valid").String() != "1" {
+		n--
+	}
+	var present []int
+	for x := 0; x < n; x++ {
+		present = append(present, x)
+	}
+	return ibft.marshalStructs(present, control.Length)
+}
+
+// marshalStructs marshals an IBFT with only the structures in present,
+// given by their index in ibftStructs, one after the other after a
+// control structure of length clen. The pointers to the rest are 0.
+func (ibft *IBFT) marshalStructs(present []int, clen uint16) ([]byte, error) {
+	f, err := flags(ibft.Multi)
+	if err != nil {
+		return nil, err
+	}
+	v := reflect.ValueOf(ibft).Elem()
+	o, next, err := ibftStructOffsets(present, ibftHeaderLen+clen)
+	if err != nil {
+		return nil, err
+	}
+
+	c := control
+	c.Flags = acpiIBFTControlFlags(f)
+	c.Length = clen
+	ptrs := []*uint16{&c.Initiator, &c.NIC0, &c.Target0, &c.NIC1, &c.Target1}
+	for x := range ptrs {
+		*ptrs[x] = 0
+	}
+	for i, x := range present {
+		*ptrs[x] = o[i]
+	}
+
+	h := HeapTable{Head: &bytes.Buffer{}, Heap: &bytes.Buffer{}, HeapBase: next, Intern: IBFTInternHeap}
+	var cb bytes.Buffer
+	w(&cb, c)
+	w(h.Head, ibft.header(), cb.Bytes()[:c.Length])
+	for _, x := range present {
+		// mIBFT marshals the fields of a struct, so give it a
+		// struct with just this structure in it.
+		n := ibftStructs[x]
+		sf, _ := reflect.TypeOf(ibft).Elem().FieldByName(n)
+		s := reflect.New(reflect.StructOf([]reflect.StructField{{Name: n, Type: sf.Type}}))
+		s.Elem().Field(0).Set(v.FieldByName(n))
+		if err := mIBFT(&h, nil, "", s.Interface()); err != nil {
+			return nil, err
+		}
+	}
+	if h.Head.Len() != int(next) {
+		return nil, fmt.Errorf("Expected headers len is wrong; got %d, want %d", h.Head.Len(), next)
+	}
+	w(h.Head, h.Heap.Bytes())
+	return h.Head.Bytes(), nil
+}
+
+// MarshalAligned marshals an IBFT, as Marshal does, then pads it with
+// zeros to a multiple of align, e.g. the page size, for placing it in
+// EFI reserved memory. The header Length is the real length of the
+// table, not including the padding; the checksum is not affected by it.
+func (ibft *IBFT) MarshalAligned(align int) ([]byte, error) {
+	if align <= 0 {
+		return nil, fmt.Errorf("MarshalAligned: alignment %d must be > 0", align)
+	}
+	b, err := Marshal(ibft)
+	if err != nil {
+		return nil, err
+	}
+	if r := len(b) % align; r != 0 {
+		b = append(b, make([]byte, align-r)...)
+	}
+	return b, nil
+}
+
+// MarshalSize returns the length of the table MarshalAligned(align)
+// returns, without marshaling it, e.g. to size a buffer for it. The
+// IBFT is not checked, so if MarshalAligned would fail, the size is
+// meaningless. It is 0 if align is not > 0.
+func (ibft *IBFT) MarshalSize(align int) int {
+	if align <= 0 {
+		return 0
+	}
+	_, end, _ := ibftStructOffsets(ibftAllStructs, ibftHeaderLen+ibftControlLen)
+	n := int(end)
+	seen := map[sheap]bool{}
+	add := func(s sheap, k HeapKind) {
+		if s == "" || (IBFTInternHeap && seen[s]) {
+			return
+		}
+		seen[s] = true
+		n += len(s)
+		if HeapNUL[k] {
+			n++
+		}
+	}
+	add(ibft.Initiator.Name, HeapName)
+	add(ibft.NIC0.HostName, HeapName)
+	for _, t := range []*IBFTTarget{&ibft.Target0, &ibft.Target1} {
+		if t == &ibft.Target1 {
+			add(ibft.NIC1.HostName, HeapName)
+		}
+		add(t.TargetName, HeapName)
+		add(t.CHAPName, HeapName)
+		add(t.CHAPSecret, HeapSecret)
+		add(t.ReverseCHAPName, HeapName)
+		add(t.ReverseCHAPSecret, HeapSecret)
+	}
+	if r := n % align; r != 0 {
+		n += align - r
+	}
+	return n
+}
+
+// ibftAlign is the alignment the IBFT spec requires of the table.
+const ibftAlign = 16
+
+// FitsIn returns true if the IBFT, padded to a multiple of 16 bytes, the
+// alignment the spec requires of it, fits in size bytes, e.g. a reserved
+// region it is to be placed in. It is MarshalSize, but reads better
+// where a placement is decided.
+func (ibft *IBFT) FitsIn(size int) bool {
+	return ibft.MarshalSize(ibftAlign) <= size
+}
+
+// MarshalSplit marshals an IBFT, as Marshal does, and returns it in two
+// parts: the head, i.e. the header, control structure and structures,
+// and the heap, which their heap entries point into, for callers which
+// place them separately. The spec requires a table to be contiguous, so
+// the heap must go gap bytes after the end of the head, and the gap
+// must be zeros; the offsets in the head, the Length and the checksum
+// are for the table laid out that way. For now the gap is always 0.
+func (ibft *IBFT) MarshalSplit() (head, heap []byte, gap int, err error) {
+	b, err := Marshal(ibft)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	_, end, err := ibftStructOffsets(ibftAllStructs, ibftHeaderLen+ibftControlLen)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return b[:end], b[end:], 0, nil
+}
+
+// MarshalControl returns just the control structure Marshal writes,
+// with its pointers to the structures, for checking the pointers
+// without the rest of the table. The pointers are offsets in the
+// whole table, so they point past the end of what is returned.
+func (ibft *IBFT) MarshalControl() ([]byte, error) {
+	c, _, err := ibft.controlStruct()
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	w(&b, c)
+	return b.Bytes(), nil
+}
+
+// controlStruct returns the control structure Marshal writes, with
+// the pointers for IBFTStructAlign, and where the heap starts.
+func (ibft *IBFT) controlStruct() (acpiIBFTControl, uint16, error) {
+	o, end, err := ibftStructOffsets(ibftAllStructs, ibftHeaderLen+ibftControlLen)
+	if err != nil {
+		return acpiIBFTControl{}, 0, err
+	}
+	f, err := flags(ibft.Multi)
+	if err != nil {
+		return acpiIBFTControl{}, 0, err
+	}
+	c := control
+	c.Flags = acpiIBFTControlFlags(f)
+	c.Initiator, c.NIC0, c.Target0, c.NIC1, c.Target1 = o[0], o[1], o[2], o[3], o[4]
+	if err := checkControl(&c); err != nil {
+		return acpiIBFTControl{}, 0, err
+	}
+	return c, end, nil
+}
+
+// checkControl checks that the control structure Length covers the
+// control structure, and fits in the space between it and the initiator,
+// i.e. the control structure and any padding after it.
+func checkControl(c *acpiIBFTControl) error {
+	space := c.Initiator - ibftHeaderLen
+	if c.Length < ibftControlLen || c.Length > space {
+		return fmt.Errorf("IBFT control Length is %d, must be between %d and %d, the space before the initiator", c.Length, ibftControlLen, space)
+	}
+	return nil
+}
+
+// annotateHeader adds the Annotations for the header of the structure
+// name, at o, if h has Annotations.
+func (h *HeapTable) annotateHeader(name string, o int) {
+	if h.Annotations == nil {
+		return
+	}
+	annotateFixed(h.Annotations, name+".", o, reflect.TypeOf(acpiIBFTStructHeader{}))
+	*h.Annotations = append(*h.Annotations, LayoutEntry{Name: name + ".Flags", Offset: o + 5, Len: 1})
+}
+
 // mIBFT is the workhorse of IBFT marshaling.
-func mIBFT(h *HeapTable, i interface{}) error {
+// If l is not nil, where each structure and heap entry went is added to
+// it, with the field names prefixed by prefix.
+func mIBFT(h *HeapTable, l *Layout, prefix string, i interface{}) error {
 	nt := reflect.TypeOf(i).Elem()
 	nv := reflect.ValueOf(i).Elem()
+	// last is where the last structure written starts, if any.
+	last := -1
 	for i := 0; i < nt.NumField(); i++ {
 		f := nt.Field(i)
 		ft := f.Type
 		fv := nv.Field(i)
+		// Unexported fields, and those tagged ibft:"-", are not
+		// part of the table.
+		if f.PkgPath != "" || f.Tag.Get("ibft") == "-" {
+			continue
+		}
 
 		Debug("Field %d: (%d, %d) ml %v %T (%v, %v)", i, h.Head.Len(), h.Heap.Len(), f, f, ft, fv)
+		switch fv.Interface().(type) {
+		case IBFTInitiator, IBFTNIC, IBFTTarget:
+			padTo(h.Head, IBFTStructAlign)
+			// The last structure takes up the padding too.
+			if IBFTActualLength && last >= 0 {
+				binary.LittleEndian.PutUint16(h.Head.Bytes()[last+2:], uint16(h.Head.Len()-last))
+			}
+			last = h.Head.Len()
+		}
+		name, head, heap := f.Name, h.Head.Len(), h.Heap.Len()
 		switch s := fv.Interface().(type) {
 		case Generic:
 			// This is not used yet. When we started this work, we never thought we'd
@@ -293,11 +1042,13 @@ func mIBFT(h *HeapTable, i interface{}) error {
 			if err != nil {
 				return fmt.Errorf("Parsing %v: %v", []flag{s.Valid, s.Boot}, err)
 			}
+			f |= s.ReservedFlags &^ 0x03
 			// we can do this hack with Index; it will only ever be
 			// 0 or 1. The IBFT allows lots, in principle, but only 2, in practice.
 			w(h.Head, ibftInitiator, ibftVersion, ibftInitiatorLen, uint8(0), f)
+			h.annotateHeader(name, head)
 			Debug("Wrote initiatior header len is %d", h.Head.Len())
-			if err := mIBFT(h, &s); err != nil {
+			if err := mIBFT(h, l, name+".", &s); err != nil {
 				return err
 			}
 		case IBFTNIC:
@@ -305,6 +1056,7 @@ func mIBFT(h *HeapTable, i interface{}) error {
 			if err != nil {
 				return fmt.Errorf("Parsing %v: %v", []flag{s.Valid, s.Boot, s.Global}, err)
 			}
+			f |= s.ReservedFlags &^ 0x07
 			// we can do this hack with Index; it will only ever be
 			// 0 or 1. See above snarky comment.
 			x, err := flags(s.Index)
@@ -312,7 +1064,8 @@ func mIBFT(h *HeapTable, i interface{}) error {
 				return fmt.Errorf("Parsing NICIndex %s: %v", s.Index, err)
 			}
 			w(h.Head, ibftNIC, ibftVersion, ibftNICLen, x, f)
-			if err := mIBFT(h, &s); err != nil {
+			h.annotateHeader(name, head)
+			if err := mIBFT(h, l, name+".", &s); err != nil {
 				return err
 			}
 
@@ -321,20 +1074,43 @@ func mIBFT(h *HeapTable, i interface{}) error {
 			if err != nil {
 				return fmt.Errorf("Parsing %v: %v", []flag{s.Valid, s.Boot, s.CHAP, s.RCHAP}, err)
 			}
+			f |= s.ReservedFlags &^ 0x0f
 			x, err := flags(s.Index)
 			if err != nil {
 				return fmt.Errorf("Parsing NICIndex %s: %v", s.Index, err)
 			}
+			if s.TargetIP, err = s.socket(); err != nil {
+				return err
+			}
 			w(h.Head, ibftTarget, ibftVersion, ibftTargetLen, x, f)
-			if err := mIBFT(h, &s); err != nil {
+			h.annotateHeader(name, head)
+			if err := mIBFT(h, l, name+".", &s); err != nil {
 				return err
 			}
 
 		default:
+			h.Field = prefix + name
 			if err := h.Marshal(s); err != nil {
 				return err
 			}
 		}
+		switch fv.Interface().(type) {
+		case IBFTInitiator, IBFTNIC, IBFTTarget:
+			if IBFTActualLength {
+				binary.LittleEndian.PutUint16(h.Head.Bytes()[head+2:], uint16(h.Head.Len()-head))
+			}
+		}
+		if l == nil {
+			continue
+		}
+		switch fv.Interface().(type) {
+		case IBFTInitiator, IBFTNIC, IBFTTarget:
+			*l = append(*l, LayoutEntry{Name: name, Offset: head, Len: h.Head.Len() - head})
+		case sheap:
+			if n := h.Heap.Len() - heap; n > 0 {
+				*l = append(*l, LayoutEntry{Name: prefix + name, Offset: int(h.HeapBase) + heap, Len: n})
+			}
+		}
 	}
 	Debug("mIBFT done, head is %d bytes, heap is %d bytes", h.Head.Len(), h.Heap.Len())
 	return nil
@@ -0,0 +1,259 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package acpi
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/io"
+)
+
+// SysfsReader is the part of a file system sysfsIBFT reads tables
+// with. It is io/fs's GlobFS and ReadFileFS, which we can not use yet,
+// as they need Go 1.16; any fs.FS can be wrapped to be one.
+type SysfsReader interface {
+	Glob(pattern string) ([]string, error)
+	ReadFile(name string) ([]byte, error)
+}
+
+// osSysfs is the SysfsReader for the OS's file system.
+type osSysfs struct{}
+
+func (osSysfs) Glob(pattern string) ([]string, error) {
+	return filepath.Glob(pattern)
+}
+
+func (osSysfs) ReadFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(name)
+}
+
+var (
+	// SysfsFS is where sysfsIBFT reads SysfsTablesPath. It is the
+	// OS's file system; tests can replace it with synthetic tables.
+	SysfsFS SysfsReader = osSysfs{}
+	// SysfsTablesPath is the directory where Linux puts ACPI tables.
+	// If there is more than one IBFT, the second is iBFT1, and so on.
+	SysfsTablesPath = "/sys/firmware/acpi/tables"
+
+	// IOMemPath is the file describing physical memory regions.
+	IOMemPath = "/proc/iomem"
+	// IOMemIBFTLabel is the label of the IBFT region in IOMemPath.
+	// Not all kernels label it.
+	IOMemIBFTLabel = "iBFT"
+
+	// IBFTPath, if set, is a file FindIBFTs reads the IBFT from,
+	// instead of looking for it, e.g. a table captured on another
+	// system, to debug it with the code which reads a live one.
+	IBFTPath = ""
+
+	// readPhys reads n bytes of physical memory at base.
+	// You can change it for testing.
+	readPhys = func(base, n int64) ([]byte, error) {
+		dat := io.ByteSlice(make([]byte, n))
+		if err := io.Read(base, &dat); err != nil {
+			return nil, err
+		}
+		return []byte(dat), nil
+	}
+)
+
+// iomemRegion finds the first region in IOMemPath with
+// IOMemIBFTLabel, and returns its start and end, inclusive.
+// Lines in the file look like this, with nesting indicated by indentation:
+//	000f0000-000fffff : System ROM
+func iomemRegion() (int64, int64, error) {
+	f, err := os.Open(IOMemPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		l := strings.SplitN(strings.TrimSpace(s.Text()), " : ", 2)
+		if len(l) != 2 || l[1] != IOMemIBFTLabel {
+			continue
+		}
+		r := strings.SplitN(l[0], "-", 2)
+		if len(r) != 2 {
+			return 0, 0, fmt.Errorf("%s: %q is not a valid region", IOMemPath, s.Text())
+		}
+		start, err := strconv.ParseInt(r[0], 16, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%s: %q: %v", IOMemPath, s.Text(), err)
+		}
+		end, err := strconv.ParseInt(r[1], 16, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%s: %q: %v", IOMemPath, s.Text(), err)
+		}
+		if end < start {
+			return 0, 0, fmt.Errorf("%s: %q: end is before start", IOMemPath, s.Text())
+		}
+		return start, end, nil
+	}
+	if err := s.Err(); err != nil {
+		return 0, 0, err
+	}
+	return 0, 0, fmt.Errorf("%s: no %q region", IOMemPath, IOMemIBFTLabel)
+}
+
+// iomemIBFT reads the physical memory region labeled
+// IOMemIBFTLabel in IOMemPath.
+func iomemIBFT() ([][]byte, error) {
+	start, end, err := iomemRegion()
+	if err != nil {
+		return nil, err
+	}
+	Debug("IBFT region in %s is %#x-%#x", IOMemPath, start, end)
+	if end-start+1 > int64(MaxTableSize) {
+		return nil, fmt.Errorf("%s: %q region is %d bytes, which is more than MaxTableSize (%d)", IOMemPath, IOMemIBFTLabel, end-start+1, MaxTableSize)
+	}
+	b, err := readPhys(start, end-start+1)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{b}, nil
+}
+
+// IBFTFromIOMem reads the IBFT from the physical memory region
+// labeled IOMemIBFTLabel in IOMemPath.
+func IBFTFromIOMem() (*IBFT, error) {
+	b, err := iomemIBFT()
+	if err != nil {
+		return nil, err
+	}
+	ibft := &IBFT{}
+	if err := ibft.Unmarshal(b[0]); err != nil {
+		return nil, err
+	}
+	return ibft, nil
+}
+
+// sysfsIBFT reads all the IBFTs in SysfsTablesPath in SysfsFS.
+func sysfsIBFT() ([][]byte, error) {
+	n, err := SysfsFS.Glob(filepath.Join(SysfsTablesPath, "[iI]BFT*"))
+	if err != nil {
+		return nil, err
+	}
+	var tabs [][]byte
+	for _, f := range n {
+		b, err := SysfsFS.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		tabs = append(tabs, b)
+	}
+	if len(tabs) == 0 {
+		return nil, fmt.Errorf("no IBFT in %s", SysfsTablesPath)
+	}
+	return tabs, nil
+}
+
+// ibftFinders are the ways we find IBFTs, in the order we try them.
+// You can change them for testing.
+var ibftFinders = []func() ([][]byte, error){sysfsIBFT, iomemIBFT}
+
+// ErrNoIBFT is returned by FindIBFTs when it finds no IBFT.
+var ErrNoIBFT = errors.New("no IBFT found")
+
+// FindIBFTs finds all the IBFTs it can, using every method we have,
+// and returns them. The same table is often found more than once,
+// e.g. in sysfs and in memory; it is only returned once.
+// FindIBFTs only returns an error, ErrNoIBFT, if it finds no IBFTs,
+// or, if IBFTPath is set, the error from reading it.
+func FindIBFTs() ([]*IBFT, error) {
+	var (
+		ibfts   []*IBFT
+		seen    = map[[sha256.Size]byte]bool{}
+		finders = ibftFinders
+	)
+	if IBFTPath != "" {
+		b, err := ioutil.ReadFile(IBFTPath)
+		if err != nil {
+			return nil, err
+		}
+		finders = []func() ([][]byte, error){func() ([][]byte, error) { return [][]byte{b}, nil }}
+	}
+	for _, f := range finders {
+		tabs, err := f()
+		if err != nil {
+			Debug("FindIBFTs: %v", err)
+			continue
+		}
+		for _, b := range tabs {
+			// These come from firmware, which uses all sorts of
+			// signatures.
+			r, err := NewIBFTReader(b, func(r *IBFTReader) { r.SignatureVariants = true })
+			if err != nil {
+				Debug("FindIBFTs: %v", err)
+				continue
+			}
+			h := sha256.Sum256(r.data)
+			if seen[h] {
+				continue
+			}
+			seen[h] = true
+			ibft, err := r.IBFT()
+			if err != nil {
+				Debug("FindIBFTs: %v", err)
+				continue
+			}
+			ibfts = append(ibfts, ibft)
+		}
+	}
+	if len(ibfts) == 0 {
+		return nil, ErrNoIBFT
+	}
+	return ibfts, nil
+}
+
+// BootedFromISCSI returns true, and the IBFT, if the system booted from
+// iSCSI, i.e. there is an IBFT, found as FindIBFTs finds them, with a
+// valid target which firmware selected for boot. It is for boot scripts
+// to branch on. Having no IBFT is not an error: the system just did not
+// boot from iSCSI.
+func BootedFromISCSI() (bool, *IBFT, error) {
+	ibfts, err := FindIBFTs()
+	if err == ErrNoIBFT {
+		return false, nil, nil
+	}
+	if err != nil {
+		return false, nil, err
+	}
+	for _, i := range ibfts {
+		for _, t := range []*IBFTTarget{&i.Target0, &i.Target1} {
+			if t.Valid == "1" && t.Boot == "1" {
+				return true, i, nil
+			}
+		}
+	}
+	return false, nil, nil
+}
+
+// SelectByInitiator finds all the IBFTs, as FindIBFTs does, and
+// returns the one with the initiator name. This is for systems with
+// more than one adapter, each with its own IBFT.
+func SelectByInitiator(name string) (*IBFT, error) {
+	ibfts, err := FindIBFTs()
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range ibfts {
+		if string(i.Initiator.Name) == name {
+			return i, nil
+		}
+	}
+	return nil, fmt.Errorf("none of the %d IBFTs have initiator %q", len(ibfts), name)
+}
@@ -0,0 +1,260 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package acpi
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestIBFTFromIOMem(t *testing.T) {
+	defer func(p, l string, r func(int64, int64) ([]byte, error)) {
+		IOMemPath, IOMemIBFTLabel, readPhys = p, l, r
+	}(IOMemPath, IOMemIBFTLabel, readPhys)
+
+	b := readTestIBFT(t)
+	IOMemPath = "testdata/iomem"
+	readPhys = func(base, n int64) ([]byte, error) {
+		if base != 0x9a000 || n != 0x400 {
+			return nil, fmt.Errorf("readPhys(%#x, %#x): want (0x9a000, 0x400)", base, n)
+		}
+		return append(b, make([]byte, n-int64(len(b)))...), nil
+	}
+	i, err := IBFTFromIOMem()
+	if err != nil {
+		t.Fatalf("IBFTFromIOMem: got %v, want nil", err)
+	}
+	if i.Initiator.Name != "iqn.2009-06.com.example:initiator" {
+		t.Errorf("Initiator.Name: got %q, want %q", i.Initiator.Name, "iqn.2009-06.com.example:initiator")
+	}
+
+	IOMemIBFTLabel = "Video ROM"
+	if _, err := IBFTFromIOMem(); err == nil {
+		t.Errorf("IBFTFromIOMem with label %q: got nil, want error", IOMemIBFTLabel)
+	}
+	IOMemIBFTLabel = "iSCSI"
+	if _, err := IBFTFromIOMem(); err == nil {
+		t.Errorf("IBFTFromIOMem with label %q: got nil, want error", IOMemIBFTLabel)
+	}
+}
+
+func TestIBFTFromIOMemTooBig(t *testing.T) {
+	defer func(p string, m uint32, r func(int64, int64) ([]byte, error)) {
+		IOMemPath, MaxTableSize, readPhys = p, m, r
+	}(IOMemPath, MaxTableSize, readPhys)
+
+	IOMemPath = "testdata/iomem"
+	MaxTableSize = 0x100
+	readPhys = func(base, n int64) ([]byte, error) {
+		return nil, fmt.Errorf("readPhys(%#x, %#x): should not be called", base, n)
+	}
+	if _, err := IBFTFromIOMem(); err == nil || !strings.Contains(err.Error(), "MaxTableSize") {
+		t.Errorf("IBFTFromIOMem with a 0x400 byte region: got %v, want MaxTableSize error", err)
+	}
+}
+
+func TestFindIBFTs(t *testing.T) {
+	defer func(f []func() ([][]byte, error)) { ibftFinders = f }(ibftFinders)
+
+	a := readTestIBFT(t)
+	i := &IBFT{}
+	if err := i.Unmarshal(a); err != nil {
+		t.Fatal(err)
+	}
+	i.Initiator.Name = "iqn.2009-06.com.example:second"
+	b, err := Marshal(i)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ibftFinders = []func() ([][]byte, error){
+		func() ([][]byte, error) { return [][]byte{a, b}, nil },
+		func() ([][]byte, error) { return nil, fmt.Errorf("not here") },
+		// The same table again, with trailing memory, as /dev/mem would have it.
+		func() ([][]byte, error) { return [][]byte{append(append([]byte{}, a...), 1, 2, 3)}, nil },
+		func() ([][]byte, error) { return [][]byte{[]byte("garbage")}, nil },
+	}
+	ibfts, err := FindIBFTs()
+	if err != nil {
+		t.Fatalf("FindIBFTs: got %v, want nil", err)
+	}
+	if len(ibfts) != 2 {
+		t.Fatalf("FindIBFTs: got %d IBFTs, want 2", len(ibfts))
+	}
+
+	for _, n := range []string{"iqn.2009-06.com.example:initiator", "iqn.2009-06.com.example:second"} {
+		i, err := SelectByInitiator(n)
+		if err != nil {
+			t.Errorf("SelectByInitiator(%q): got %v, want nil", n, err)
+			continue
+		}
+		if string(i.Initiator.Name) != n {
+			t.Errorf("SelectByInitiator(%q): got initiator %q", n, i.Initiator.Name)
+		}
+	}
+	if _, err := SelectByInitiator("iqn.2009-06.com.example:third"); err == nil {
+		t.Errorf("SelectByInitiator of a missing initiator: got nil, want error")
+	}
+
+	ibftFinders = ibftFinders[1:2]
+	if _, err := FindIBFTs(); err == nil {
+		t.Errorf("FindIBFTs with no IBFTs: got nil, want error")
+	}
+}
+
+func TestFindIBFTsPath(t *testing.T) {
+	defer func(f []func() ([][]byte, error), p string) { ibftFinders, IBFTPath = f, p }(ibftFinders, IBFTPath)
+	ibftFinders = []func() ([][]byte, error){
+		func() ([][]byte, error) {
+			t.Errorf("FindIBFTs with IBFTPath set: looked for an IBFT")
+			return nil, fmt.Errorf("not here")
+		},
+	}
+
+	IBFTPath = "testdata/ibft.bin"
+	ibfts, err := FindIBFTs()
+	if err != nil {
+		t.Fatalf("FindIBFTs from %s: got %v, want nil", IBFTPath, err)
+	}
+	if len(ibfts) != 1 || ibfts[0].Initiator.Name != "iqn.2009-06.com.example:initiator" {
+		t.Errorf("FindIBFTs from %s: got %v, want the one IBFT in it", IBFTPath, ibfts)
+	}
+	b, i, err := BootedFromISCSI()
+	if !b || i == nil || err != nil {
+		t.Errorf("BootedFromISCSI from %s: got (%v, %v, %v), want (true, the IBFT, nil)", IBFTPath, b, i, err)
+	}
+
+	IBFTPath = "testdata/iomem"
+	if _, err := FindIBFTs(); err != ErrNoIBFT {
+		t.Errorf("FindIBFTs from %s: got %v, want %v", IBFTPath, err, ErrNoIBFT)
+	}
+	IBFTPath = "testdata/nonexistent"
+	if _, err := FindIBFTs(); err == nil || err == ErrNoIBFT {
+		t.Errorf("FindIBFTs from %s: got %v, want an error reading it", IBFTPath, err)
+	}
+}
+
+// mapSysfs is a SysfsReader with the files in the map, for tests.
+type mapSysfs map[string][]byte
+
+func (m mapSysfs) Glob(pattern string) ([]string, error) {
+	var n []string
+	for f := range m {
+		ok, err := filepath.Match(pattern, f)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			n = append(n, f)
+		}
+	}
+	sort.Strings(n)
+	return n, nil
+}
+
+func (m mapSysfs) ReadFile(name string) ([]byte, error) {
+	b, ok := m[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return b, nil
+}
+
+func TestSysfsIBFT(t *testing.T) {
+	defer func(f SysfsReader) { SysfsFS = f }(SysfsFS)
+
+	b := readTestIBFT(t)
+	SysfsFS = mapSysfs{"/sys/firmware/acpi/tables/DSDT": []byte("DSDT")}
+	if _, err := sysfsIBFT(); err == nil {
+		t.Errorf("sysfsIBFT with no IBFT: got nil, want error")
+	}
+	SysfsFS = mapSysfs{
+		"/sys/firmware/acpi/tables/iBFT":  b,
+		"/sys/firmware/acpi/tables/iBFT1": b,
+		"/sys/firmware/acpi/tables/DSDT":  []byte("DSDT"),
+	}
+	tabs, err := sysfsIBFT()
+	if err != nil {
+		t.Fatalf("sysfsIBFT: got %v, want nil", err)
+	}
+	if len(tabs) != 2 {
+		t.Errorf("sysfsIBFT: got %d tables, want 2", len(tabs))
+	}
+	for i, tab := range tabs {
+		if !bytes.Equal(tab, b) {
+			t.Errorf("sysfsIBFT table %d: got %d bytes, want the %d of testdata/ibft.bin", i, len(tab), len(b))
+		}
+	}
+
+	// It reads the real file system by default.
+	SysfsFS = osSysfs{}
+	defer func(p string) { SysfsTablesPath = p }(SysfsTablesPath)
+	d, err := ioutil.TempDir("", "sysfsibft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	SysfsTablesPath = d
+	if err := ioutil.WriteFile(filepath.Join(d, "iBFT"), b, 0444); err != nil {
+		t.Fatal(err)
+	}
+	if tabs, err := sysfsIBFT(); err != nil || len(tabs) != 1 {
+		t.Errorf("sysfsIBFT from %s: got %d tables, %v, want 1, nil", d, len(tabs), err)
+	}
+}
+
+func TestBootedFromISCSI(t *testing.T) {
+	defer func(f []func() ([][]byte, error)) { ibftFinders = f }(ibftFinders)
+
+	noBoot := validIBFT()
+	noBoot.Initiator.Name = "iqn.2009-06.com.example:noboot"
+	// Boot selected, but not valid, does not count.
+	noBoot.Target0.Valid, noBoot.Target1.Boot = "0", "0"
+	boot := validIBFT()
+	boot.Target1.Valid = "0"
+	var tabs [][]byte
+	for _, i := range []*IBFT{noBoot, boot} {
+		b, err := Marshal(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tabs = append(tabs, b)
+	}
+
+	for _, tt := range []struct {
+		n    string
+		tabs [][]byte
+		want sheap
+	}{
+		{"no IBFT", nil, ""},
+		{"no boot target", tabs[:1], ""},
+		{"boot target", tabs, boot.Initiator.Name},
+	} {
+		tabs := tt.tabs
+		ibftFinders = []func() ([][]byte, error){
+			func() ([][]byte, error) { return nil, fmt.Errorf("not here") },
+			func() ([][]byte, error) { return tabs, nil },
+		}
+		ok, i, err := BootedFromISCSI()
+		if err != nil {
+			t.Errorf("%s: BootedFromISCSI: got %v, want nil", tt.n, err)
+			continue
+		}
+		if ok != (tt.want != "") || (i != nil) != ok {
+			t.Errorf("%s: BootedFromISCSI: got (%v, %v), want (%v, an IBFT if true)", tt.n, ok, i, tt.want != "")
+			continue
+		}
+		if ok && i.Initiator.Name != tt.want {
+			t.Errorf("%s: BootedFromISCSI: got initiator %q, want %q", tt.n, i.Initiator.Name, tt.want)
+		}
+	}
+}
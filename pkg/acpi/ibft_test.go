@@ -5,9 +5,15 @@
 package acpi
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"log"
+	"net"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -37,8 +43,9 @@ func TestIBFTSizes(t *testing.T) {
 	}
 }
 
-func TestIBFTMarshal(t *testing.T) {
-	i := &IBFT{
+// testIBFT returns a fully populated IBFT, for use in tests.
+func testIBFT() *IBFT {
+	return &IBFT{
 		Multi: "1",
 		Initiator: IBFTInitiator{
 			Valid:                 "1",
@@ -110,7 +117,12 @@ func TestIBFTMarshal(t *testing.T) {
 			ReverseCHAPSecret: "arg",
 		},
 	}
+}
 
+func TestIBFTMarshal(t *testing.T) {
+	i := testIBFT()
+
+	defer func(d func(string, ...interface{})) { Debug = d }(Debug)
 	Debug = t.Logf
 	if false {
 		b, err := json.MarshalIndent(i, "", "\t")
@@ -132,7 +144,7 @@ func TestIBFTMarshal(t *testing.T) {
 		t.Fatalf("Marshal: got %v, want nil", err)
 	}
 	t.Logf("Marshal to %v", err)
-	if len(b) != 524 {
+	if len(b) != 533 {
 		t.Fatalf("Marshall: len is %d bytes and should be 2048", len(b))
 	}
 	f, err := ioutil.TempFile("", "acpi")
@@ -146,3 +158,1128 @@ func TestIBFTMarshal(t *testing.T) {
 	}
 	t.Logf("Wrote %d bytes to %q", n, f.Name())
 }
+
+func TestIBFTInitiatorFlags(t *testing.T) {
+	var tests = []struct {
+		valid, boot bool
+		want        acpiIBFTInitiatorFlags
+	}{
+		{false, false, 0},
+		{true, false, acpiIBFTInitiatorValid},
+		{false, true, acpiIBFTInitiatorFirmwareBoot},
+		{true, true, acpiIBFTInitiatorValid | acpiIBFTInitiatorFirmwareBoot},
+	}
+	for _, tt := range tests {
+		i := testIBFT()
+		i.Initiator.Valid = boolFlag(tt.valid)
+		i.Initiator.SetFirmwareBoot(tt.boot)
+		if i.Initiator.FirmwareBoot() != tt.boot {
+			t.Errorf("FirmwareBoot(): got %v, want %v", i.Initiator.FirmwareBoot(), tt.boot)
+		}
+		b, err := Marshal(i)
+		if err != nil {
+			t.Fatalf("Marshal: got %v, want nil", err)
+		}
+		// The flags are the sixth byte of the initiator.
+		got := acpiIBFTInitiatorFlags(b[ibftHeaderLen+ibftControlLen+5])
+		if got != tt.want {
+			t.Errorf("Valid %v, FirmwareBoot %v: got flags %#02x, want %#02x", tt.valid, tt.boot, got, tt.want)
+		}
+		// And it reads back the same, e.g. for an initiator
+		// the OS, not firmware, logs in with.
+		var u IBFT
+		if err := u.Unmarshal(b); err != nil {
+			t.Fatalf("Unmarshal: got %v, want nil", err)
+		}
+		if u.Initiator.Valid != boolFlag(tt.valid) || u.Initiator.FirmwareBoot() != tt.boot {
+			t.Errorf("Valid %v, FirmwareBoot %v: Unmarshal got Valid %q, FirmwareBoot %v", tt.valid, tt.boot, u.Initiator.Valid, u.Initiator.FirmwareBoot())
+		}
+	}
+}
+
+func TestIBFTMarshalAligned(t *testing.T) {
+	b, err := Marshal(testIBFT())
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+	for _, align := range []int{1, 16, 4096, 16384, 65536, len(b)} {
+		a, err := testIBFT().MarshalAligned(align)
+		if err != nil {
+			t.Errorf("MarshalAligned(%d): got %v, want nil", align, err)
+			continue
+		}
+		if len(a)%align != 0 || len(a) < len(b) || len(a) >= len(b)+align {
+			t.Errorf("MarshalAligned(%d): got %d bytes, want the next multiple of %d above %d", align, len(a), align, len(b))
+		}
+		if l := binary.LittleEndian.Uint32(a[LengthOffset:]); l != uint32(len(b)) {
+			t.Errorf("MarshalAligned(%d): header Length is %d, want %d", align, l, len(b))
+		}
+		if !bytes.Equal(a[:len(b)], b) {
+			t.Errorf("MarshalAligned(%d): table differs from Marshal", align)
+		}
+		if err := VerifyChecksum(a[:len(b)]); err != nil {
+			t.Errorf("MarshalAligned(%d): %v", align, err)
+		}
+	}
+	for _, align := range []int{0, -4096} {
+		if _, err := testIBFT().MarshalAligned(align); err == nil {
+			t.Errorf("MarshalAligned(%d): got nil, want error", align)
+		}
+	}
+}
+
+func TestIBFTMarshalSize(t *testing.T) {
+	defer func(o bool) { IBFTInternHeap = o }(IBFTInternHeap)
+	var tests = []struct {
+		n      string
+		f      func(*IBFT)
+		intern bool
+	}{
+		{"testIBFT", func(*IBFT) {}, false},
+		{"No NIC1 HostName", func(i *IBFT) { i.NIC1.HostName = "" }, false},
+		{"No heap", func(i *IBFT) {
+			i.Initiator.Name, i.NIC0.HostName, i.NIC1.HostName = "", "", ""
+			for _, t := range []*IBFTTarget{&i.Target0, &i.Target1} {
+				t.TargetName, t.CHAPName, t.CHAPSecret, t.ReverseCHAPName, t.ReverseCHAPSecret = "", "", "", "", ""
+			}
+		}, false},
+		{"Shared CHAP name", func(i *IBFT) { i.Target1.CHAPName = i.Target0.CHAPName }, false},
+		{"Shared CHAP name, interned", func(i *IBFT) { i.Target1.CHAPName = i.Target0.CHAPName }, true},
+	}
+	for _, tt := range tests {
+		i := testIBFT()
+		tt.f(i)
+		IBFTInternHeap = tt.intern
+		for _, align := range []int{1, 3, 16, 4096} {
+			b, err := i.MarshalAligned(align)
+			if err != nil {
+				t.Errorf("%s: MarshalAligned(%d): got %v, want nil", tt.n, align, err)
+				continue
+			}
+			if got := i.MarshalSize(align); got != len(b) {
+				t.Errorf("%s: MarshalSize(%d): got %d, want %d", tt.n, align, got, len(b))
+			}
+		}
+	}
+	if got := testIBFT().MarshalSize(0); got != 0 {
+		t.Errorf("MarshalSize(0): got %d, want 0", got)
+	}
+}
+
+func TestIBFTFitsIn(t *testing.T) {
+	i := testIBFT()
+	b, err := Marshal(i)
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+	if len(b)%ibftAlign == 0 {
+		t.Fatalf("testIBFT is %d bytes, a multiple of %d; the test needs it not to be", len(b), ibftAlign)
+	}
+	padded := (len(b) + ibftAlign - 1) &^ (ibftAlign - 1)
+	var tests = []struct {
+		size int
+		want bool
+	}{
+		{0, false},
+		{len(b), false},
+		{padded - 1, false},
+		{padded, true},
+		{padded + 1, true},
+	}
+	for _, tt := range tests {
+		if got := i.FitsIn(tt.size); got != tt.want {
+			t.Errorf("FitsIn(%d) for a %d byte table: got %v, want %v", tt.size, len(b), got, tt.want)
+		}
+	}
+}
+
+func TestIBFTHeadersLen(t *testing.T) {
+	b, err := Marshal(testIBFT())
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+	// The heap follows the headers, and the initiator name is first in it.
+	if p := binary.LittleEndian.Uint16(b[ibftHeaderLen+ibftControlLen+72:]); p != ibftHeadersLen {
+		t.Errorf("Initiator name offset: got %d, want %d", p, ibftHeadersLen)
+	}
+
+	defer func(l int) { headersLen = l }(headersLen)
+	headersLen = int(ibftHeadersLen) - 1
+	_, err = Marshal(testIBFT())
+	want := fmt.Sprintf("Expected headers len is wrong; got %d, want %d", ibftHeadersLen, ibftHeadersLen-1)
+	if err == nil || err.Error() != want {
+		t.Errorf("Marshal with wrong headers length: got %v, want %q", err, want)
+	}
+}
+
+func TestIBFTControlLength(t *testing.T) {
+	b, err := Marshal(testIBFT())
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+	l := binary.LittleEndian.Uint16(b[ibftHeaderLen+2:])
+	ini := binary.LittleEndian.Uint16(b[ibftHeaderLen+8:])
+	if l != ibftControlLen || ini != ibftHeaderLen+l {
+		t.Errorf("Control Length %d and initiator pointer %d: want %d and %d", l, ini, ibftControlLen, ibftHeaderLen+ibftControlLen)
+	}
+
+	defer func(c acpiIBFTControl) { control = c }(control)
+	for _, l := range []uint16{80, ibftControlLen - 2} {
+		control.Length = l
+		if _, err := Marshal(testIBFT()); err == nil {
+			t.Errorf("Marshal with control Length %d: got nil, want error", l)
+		}
+	}
+}
+
+func TestIBFTRedact(t *testing.T) {
+	i := testIBFT()
+	b, err := json.Marshal(i.Redact())
+	if err != nil {
+		t.Fatalf("json.Marshal: got %v, want nil", err)
+	}
+	for _, s := range []string{`"noun"`, `"adverb"`, `"bee"`, `"arg"`} {
+		if bytes.Contains(b, []byte(s)) {
+			t.Errorf("Redacted JSON %s: contains secret %s", b, s)
+		}
+	}
+	for _, s := range []string{`"clown"`, `"verb"`, `"bozo"`, `"barg"`, `"***"`} {
+		if !bytes.Contains(b, []byte(s)) {
+			t.Errorf("Redacted JSON %s: does not contain %s", b, s)
+		}
+	}
+	if i.Target0.CHAPSecret != "noun" {
+		t.Errorf("Redact changed the original: got %q, want %q", i.Target0.CHAPSecret, "noun")
+	}
+
+	// Unset secrets stay unset.
+	i.Target1.CHAPSecret = ""
+	if r := i.Redact(); r.Target1.CHAPSecret != "" {
+		t.Errorf("Redacting an unset secret: got %q, want %q", r.Target1.CHAPSecret, "")
+	}
+}
+
+// This builds an IBFT for a machine with one NIC, configured statically,
+// which boots from LUN 0 of one target. Marshal needs every field but
+// the IP addresses and heap strings set, even for the unused second NIC
+// and target; unused numbers are "0".
+func Example_marshalIBFT() {
+	i := &IBFT{
+		Multi: "0",
+		Initiator: IBFTInitiator{
+			Valid: "1",
+			Boot:  "1",
+			Name:  "iqn.2019-01.org.u-root:client",
+		},
+		NIC0: IBFTNIC{
+			Valid:      "1",
+			Boot:       "1",
+			Global:     "1",
+			Index:      "0",
+			IPAddress:  "192.168.1.10",
+			SubNet:     "24",
+			Origin:     "1",
+			Gateway:    "192.168.1.1",
+			PrimaryDNS: "192.168.1.1",
+			VLAN:       "0",
+			MACAddress: "52:54:00:12:34:56",
+			PCIBDF:     "0x18",
+		},
+		Target0: IBFTTarget{
+			Valid:       "1",
+			Boot:        "1",
+			CHAP:        "0",
+			RCHAP:       "0",
+			Index:       "0",
+			TargetIP:    "192.168.1.2:3260",
+			BootLUN:     "0",
+			ChapType:    "0",
+			Association: "0",
+			TargetName:  "iqn.2019-01.org.u-root:disk",
+		},
+		NIC1: IBFTNIC{
+			Valid:      "0",
+			Boot:       "0",
+			Global:     "0",
+			Index:      "1",
+			SubNet:     "0",
+			Origin:     "0",
+			VLAN:       "0",
+			MACAddress: "00:00:00:00:00:00",
+			PCIBDF:     "0",
+		},
+		Target1: IBFTTarget{
+			Valid:       "0",
+			Boot:        "0",
+			CHAP:        "0",
+			RCHAP:       "0",
+			Index:       "0",
+			TargetIP:    "0.0.0.0:0",
+			BootLUN:     "0",
+			ChapType:    "0",
+			Association: "0",
+		},
+	}
+	b, err := Marshal(i)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s table, %d bytes\n", b[:4], len(b))
+	// Output: IBFT table, 510 bytes
+}
+
+func TestIBFTNICIsStatic(t *testing.T) {
+	var tests = []struct {
+		dhcp   ipaddr
+		static bool
+	}{
+		{"", true},
+		{"0.0.0.0", true},
+		{"::", true},
+		{"10.0.0.1", false},
+		{"fe80::1", false},
+	}
+	for _, tt := range tests {
+		n := IBFTNIC{DHCP: tt.dhcp}
+		if got := n.IsStatic(); got != tt.static {
+			t.Errorf("IsStatic() with DHCP %q: got %v, want %v", tt.dhcp, got, tt.static)
+		}
+	}
+}
+
+func TestIBFTNICDHCPJSON(t *testing.T) {
+	var tests = []struct {
+		dhcp ipaddr
+		want string
+	}{
+		{"", ""},
+		{"0.0.0.0", `"DHCP":"0.0.0.0"`},
+		{"10.0.0.1", `"DHCP":"10.0.0.1"`},
+	}
+	for _, tt := range tests {
+		b, err := json.Marshal(IBFTNIC{DHCP: tt.dhcp})
+		if err != nil {
+			t.Fatalf("json.Marshal: got %v, want nil", err)
+		}
+		if tt.want == "" {
+			if bytes.Contains(b, []byte(`"DHCP"`)) {
+				t.Errorf("JSON for unset DHCP: got %s, want no DHCP", b)
+			}
+			continue
+		}
+		if !bytes.Contains(b, []byte(tt.want)) {
+			t.Errorf("JSON for DHCP %q: got %s, want %s", tt.dhcp, b, tt.want)
+		}
+	}
+}
+
+func TestIBFTTargetPort(t *testing.T) {
+	var tests = []struct {
+		ip   sockaddr
+		port u16
+		want sockaddr
+		ok   bool
+	}{
+		{"1.2.3.4:88", "", "1.2.3.4:88", true},
+		{"1.2.3.4", "88", "1.2.3.4:88", true},
+		{"1.2.3.4:88", "88", "1.2.3.4:88", true},
+		{"fe80::1", "3260", "[fe80::1]:3260", true},
+		{"[fe80::1]:3260", "3260", "[fe80::1]:3260", true},
+		{"1.2.3.4:88", "3260", "", false},
+		{"1.2.3.4", "70000", "", false},
+	}
+	for _, tt := range tests {
+		tgt := &IBFTTarget{TargetIP: tt.ip, TargetPort: tt.port}
+		got, err := tgt.socket()
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("TargetIP %q, TargetPort %q: got (%q, %v), want %q, ok %v", tt.ip, tt.port, got, err, tt.want, tt.ok)
+		}
+	}
+
+	want, err := Marshal(testIBFT())
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+	i := testIBFT()
+	i.Target0.TargetIP, i.Target0.TargetPort = "1.2.3.4", "88"
+	got, err := Marshal(i)
+	if err != nil {
+		t.Fatalf("Marshal with TargetPort: got %v, want nil", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Marshal with TargetPort: got %#x, want %#x", got, want)
+	}
+
+	// A conflict is an error, not a guess.
+	i.Target0.TargetIP = "1.2.3.4:3260"
+	if _, err := Marshal(i); err == nil {
+		t.Errorf("Marshal with TargetIP %q and TargetPort %q: got nil, want error", i.Target0.TargetIP, i.Target0.TargetPort)
+	}
+	if err := i.Validate(); err == nil || IsWarning(err) || !strings.Contains(err.Error(), "Target0") {
+		t.Errorf("Validate with TargetIP %q and TargetPort %q: got %v, want a Target0 error", i.Target0.TargetIP, i.Target0.TargetPort, err)
+	}
+}
+
+// v4 returns an IPv4 address as the 16 bytes of an IBFT IP address field.
+func v4(a, b, c, d byte) []byte {
+	return []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, a, b, c, d}
+}
+
+// TestIBFTStructureGolden checks that every field of the initiator,
+// NIC and target lands at the offset the spec gives for it.
+func TestIBFTStructureGolden(t *testing.T) {
+	i := &IBFT{
+		Multi: "0",
+		Initiator: IBFTInitiator{
+			Valid:                 "1",
+			Boot:                  "1",
+			SNSServer:             "10.0.0.1",
+			SLPServer:             "10.0.0.2",
+			PrimaryRadiusServer:   "10.0.0.3",
+			SecondaryRadiusServer: "10.0.0.4",
+			Name:                  "iqn.i",
+		},
+		NIC0: IBFTNIC{
+			Valid:        "1",
+			Boot:         "1",
+			Global:       "1",
+			Index:        "0",
+			IPAddress:    "10.0.0.5",
+			SubNet:       "24",
+			Origin:       "3",
+			Gateway:      "10.0.0.6",
+			PrimaryDNS:   "10.0.0.7",
+			SecondaryDNS: "10.0.0.8",
+			DHCP:         "10.0.0.9",
+			VLAN:         "0x0102",
+			MACAddress:   "02:03:04:05:06:07",
+			PCIBDF:       "0x0304",
+			HostName:     "host",
+		},
+		Target0: IBFTTarget{
+			Valid:             "1",
+			Boot:              "1",
+			CHAP:              "1",
+			RCHAP:             "1",
+			Index:             "0",
+			TargetIP:          "10.0.0.10:3260",
+			BootLUN:           "0x0102030405060708",
+			ChapType:          "2",
+			Association:       "1",
+			TargetName:        "iqn.t",
+			CHAPName:          "cn",
+			CHAPSecret:        "cs",
+			ReverseCHAPName:   "rn",
+			ReverseCHAPSecret: "rs",
+		},
+		NIC1:    IBFTNIC{Valid: "0", Boot: "0", Global: "0", Index: "1", MACAddress: "00:00:00:00:00:00"},
+		Target1: IBFTTarget{Valid: "0", Boot: "0", CHAP: "0", RCHAP: "0", Index: "1", TargetIP: "0.0.0.0:0"},
+	}
+	b, err := Marshal(i)
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+	r, err := NewIBFTReader(b)
+	if err != nil {
+		t.Fatalf("NewIBFTReader: got %v, want nil", err)
+	}
+
+	// The heap starts right after the structures, at 452 (0x1c4),
+	// and the strings are in the order of the fields.
+	type field struct {
+		n    string
+		o    int
+		want []byte
+	}
+	var tests = []struct {
+		id     StructureID
+		l      uint16
+		fields []field
+	}{
+		{ibftInitiator, ibftInitiatorLen, []field{
+			{"Header", 0, []byte{byte(ibftInitiator), 1, 74, 0, 0, 3}},
+			{"iSNSServer", 6, v4(10, 0, 0, 1)},
+			{"SLPServer", 22, v4(10, 0, 0, 2)},
+			{"PrimaryRadiusServer", 38, v4(10, 0, 0, 3)},
+			{"SecondaryRadiusServer", 54, v4(10, 0, 0, 4)},
+			{"InitiatorName", 70, []byte{5, 0, 0xc4, 0x01}},
+		}},
+		{ibftNIC, ibftNICLen, []field{
+			{"Header", 0, []byte{byte(ibftNIC), 1, 102, 0, 0, 7}},
+			{"IPAddress", 6, v4(10, 0, 0, 5)},
+			{"SubNet", 22, []byte{24}},
+			{"Origin", 23, []byte{3}},
+			{"Gateway", 24, v4(10, 0, 0, 6)},
+			{"PrimaryDNS", 40, v4(10, 0, 0, 7)},
+			{"SecondaryDNS", 56, v4(10, 0, 0, 8)},
+			{"DHCP", 72, v4(10, 0, 0, 9)},
+			{"VLAN", 88, []byte{2, 1}},
+			{"MACAddress", 90, []byte{2, 3, 4, 5, 6, 7}},
+			{"PCIBDF", 96, []byte{4, 3}},
+			{"HostName", 98, []byte{4, 0, 0xca, 0x01}},
+		}},
+		{ibftTarget, ibftTargetLen, []field{
+			{"Header", 0, []byte{byte(ibftTarget), 1, 54, 0, 0, 0xf}},
+			{"TargetIPAddress", 6, v4(10, 0, 0, 10)},
+			{"TargetIPSocket", 22, []byte{0xbc, 0x0c}},
+			{"TargetBootLUN", 24, []byte{8, 7, 6, 5, 4, 3, 2, 1}},
+			{"CHAPType", 32, []byte{2}},
+			{"NICAssociation", 33, []byte{1}},
+			{"TargetName", 34, []byte{5, 0, 0xcf, 0x01}},
+			{"CHAPName", 38, []byte{2, 0, 0xd5, 0x01}},
+			{"CHAPSecret", 42, []byte{2, 0, 0xd8, 0x01}},
+			{"ReverseCHAPName", 46, []byte{2, 0, 0xda, 0x01}},
+			{"ReverseCHAPSecret", 50, []byte{2, 0, 0xdd, 0x01}},
+		}},
+	}
+	for _, tt := range tests {
+		s, err := r.RawStructure(tt.id, 0)
+		if err != nil {
+			t.Fatalf("RawStructure(%d, 0): got %v, want nil", tt.id, err)
+		}
+		if len(s) != int(tt.l) {
+			t.Errorf("Structure %d: got %d bytes, want %d", tt.id, len(s), tt.l)
+			continue
+		}
+		// The fields must cover the structure, with no gaps.
+		o := 0
+		for _, f := range tt.fields {
+			if f.o != o {
+				t.Errorf("Structure %d: %s is at %d, want the previous field to end there, not at %d", tt.id, f.n, f.o, o)
+			}
+			o = f.o + len(f.want)
+			if got := s[f.o:o]; !bytes.Equal(got, f.want) {
+				t.Errorf("Structure %d: %s at %d: got %#x, want %#x", tt.id, f.n, f.o, got, f.want)
+			}
+		}
+		if o != int(tt.l) {
+			t.Errorf("Structure %d: fields end at %d, want %d", tt.id, o, tt.l)
+		}
+	}
+	// Names are followed by a NUL; secrets are not.
+	if got, want := string(b[ibftHeadersLen:ibftHeadersLen+27]), "iqn.i\x00host\x00iqn.t\x00cn\x00csrn\x00rs"; got != want {
+		t.Errorf("Heap: got %q, want %q", got, want)
+	}
+}
+
+func TestIBFTMarshalVerbose(t *testing.T) {
+	i := testIBFT()
+	want, err := Marshal(i)
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+	b, l, err := i.MarshalVerbose()
+	if err != nil {
+		t.Fatalf("MarshalVerbose: got %v, want nil", err)
+	}
+	if !bytes.Equal(b, want) {
+		t.Fatalf("MarshalVerbose: got %#x, want %#x", b, want)
+	}
+
+	var names []string
+	o := 0
+	for _, e := range l {
+		names = append(names, e.Name)
+		if e.Offset != o {
+			t.Errorf("%s: got offset %d, want %d, the end of the previous entry", e.Name, e.Offset, o)
+		}
+		o = e.Offset + e.Len
+	}
+	if o != len(b) {
+		t.Errorf("Layout: ends at %d, want %d", o, len(b))
+	}
+	wantNames := []string{"Header", "Control", "Initiator", "NIC0", "Target0", "NIC1", "Target1",
+		"Initiator.Name", "NIC0.HostName",
+		"Target0.TargetName", "Target0.CHAPName", "Target0.CHAPSecret", "Target0.ReverseCHAPName", "Target0.ReverseCHAPSecret",
+		"NIC1.HostName",
+		"Target1.TargetName", "Target1.CHAPName", "Target1.CHAPSecret", "Target1.ReverseCHAPName", "Target1.ReverseCHAPSecret"}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Fatalf("Layout: got %q, want %q", names, wantNames)
+	}
+	// Each heap entry holds the field it is named for.
+	v := reflect.ValueOf(*i)
+	for _, e := range l[7:] {
+		n := strings.SplitN(e.Name, ".", 2)
+		want := v.FieldByName(n[0]).FieldByName(n[1]).String()
+		if HeapNUL[heapKind(n[1])] {
+			want += "\x00"
+		}
+		if got := string(b[e.Offset : e.Offset+e.Len]); got != want {
+			t.Errorf("%s: got %q, want %q", e.Name, got, want)
+		}
+	}
+}
+
+func TestIBFTEmptyHeapEntry(t *testing.T) {
+	i := testIBFT()
+	i.NIC1.HostName = ""
+	b, err := Marshal(i)
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+	// NIC1 is the sixth structure; HostName is at 98.
+	o := ibftHeadersLen - ibftTargetLen - ibftNICLen + 98
+	if got := b[o : o+4]; !bytes.Equal(got, []byte{0, 0, 0, 0}) {
+		t.Errorf("Empty HostName: got length and offset %#x, want zeros", got)
+	}
+	r := &IBFT{}
+	if err := r.Unmarshal(b); err != nil {
+		t.Fatalf("Unmarshal: got %v, want nil", err)
+	}
+	if r.NIC1.HostName != "" {
+		t.Errorf("Unmarshaled HostName: got %q, want %q", r.NIC1.HostName, "")
+	}
+}
+
+func TestIBFTMarshalStrict(t *testing.T) {
+	// With every structure valid, the general form is the same as
+	// the fixed layout.
+	want, err := Marshal(testIBFT())
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+	got, err := testIBFT().MarshalStrict()
+	if err != nil {
+		t.Fatalf("MarshalStrict: got %v, want nil", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("MarshalStrict with all structures valid: got %#x, want %#x", got, want)
+	}
+
+	// With one path, the fixed layout still has every structure,
+	// but the general form leaves NIC1 and Target1 out.
+	i := testIBFT()
+	i.NIC1.Valid, i.Target1.Valid = "0", "0"
+	fixed, err := Marshal(i)
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+	strict, err := i.MarshalStrict()
+	if err != nil {
+		t.Fatalf("MarshalStrict: got %v, want nil", err)
+	}
+	if err := VerifyChecksum(strict); err != nil {
+		t.Errorf("MarshalStrict: %v", err)
+	}
+	c := ibftHeaderLen
+	for _, tt := range []struct {
+		n      string
+		b      []byte
+		length uint16
+		nic1   uint16
+	}{
+		{"Marshal", fixed, ibftControlLen, control.NIC1},
+		{"MarshalStrict", strict, 14, 0},
+	} {
+		if l := binary.LittleEndian.Uint16(tt.b[c+2:]); l != tt.length {
+			t.Errorf("%s: control Length got %d, want %d", tt.n, l, tt.length)
+		}
+		if tt.length < 16 {
+			continue
+		}
+		if p := binary.LittleEndian.Uint16(tt.b[c+14:]); p != tt.nic1 {
+			t.Errorf("%s: NIC1 pointer got %#x, want %#x", tt.n, p, tt.nic1)
+		}
+	}
+	// The strict table is the control structure without the last two
+	// pointers, and the missing structures and their heap entries, and
+	// the NULs after the four names, shorter.
+	heap := len(i.NIC1.HostName) + len(i.Target1.TargetName) + len(i.Target1.CHAPName) + len(i.Target1.CHAPSecret) + len(i.Target1.ReverseCHAPName) + len(i.Target1.ReverseCHAPSecret) + 4
+	if d := len(fixed) - len(strict); d != 4+int(ibftNICLen+ibftTargetLen)+heap {
+		t.Errorf("MarshalStrict: got %d bytes fewer than Marshal, want %d", d, 4+int(ibftNICLen+ibftTargetLen)+heap)
+	}
+
+	r, err := NewIBFTReader(strict)
+	if err != nil {
+		t.Fatalf("NewIBFTReader: got %v, want nil", err)
+	}
+	u, err := r.IBFT()
+	if err != nil {
+		t.Fatalf("Reading the strict table: got %v, want nil", err)
+	}
+	if len(r.Warnings) != 0 {
+		t.Errorf("Reading the strict table: got warnings %q, want none", r.Warnings)
+	}
+	f := &IBFT{}
+	if err := f.Unmarshal(fixed); err != nil {
+		t.Fatalf("Unmarshal: got %v, want nil", err)
+	}
+	if u.Initiator != f.Initiator || u.NIC0 != f.NIC0 || u.Target0 != f.Target0 {
+		t.Errorf("Reading the strict table: got %v, %v, %v, want %v, %v, %v", u.Initiator, u.NIC0, u.Target0, f.Initiator, f.NIC0, f.Target0)
+	}
+	if u.NIC1.Valid != "0" || u.NIC1.HostName != "" || u.Target1.Valid != "0" || u.Target1.TargetName != "" {
+		t.Errorf("Reading the strict table: got NIC1 %v, Target1 %v, want them empty", u.NIC1, u.Target1)
+	}
+}
+
+func TestIBFTMarshalCompact(t *testing.T) {
+	c := int(ibftHeaderLen)
+	for _, tt := range []struct {
+		n            string
+		nic1, tgt1   flag
+		nic1p, tgt1p uint16
+	}{
+		{"two paths", "1", "1", control.NIC1, control.Target1},
+		{"one path", "0", "0", 0, 0},
+		{"no Target1", "1", "0", control.NIC1, 0},
+		// NIC1 can not go without moving Target1.
+		{"no NIC1", "0", "1", control.NIC1, control.Target1},
+	} {
+		i := validIBFT()
+		i.NIC1.Valid, i.Target1.Valid = tt.nic1, tt.tgt1
+		fixed, err := Marshal(i)
+		if err != nil {
+			t.Fatalf("%s: Marshal: got %v, want nil", tt.n, err)
+		}
+		b, err := i.MarshalCompact()
+		if err != nil {
+			t.Fatalf("%s: MarshalCompact: got %v, want nil", tt.n, err)
+		}
+		if err := VerifyChecksum(b); err != nil {
+			t.Errorf("%s: MarshalCompact: %v", tt.n, err)
+		}
+		if l := binary.LittleEndian.Uint16(b[c+2:]); l != ibftControlLen {
+			t.Errorf("%s: control Length got %d, want %d", tt.n, l, ibftControlLen)
+		}
+		for x, p := range []uint16{control.Initiator, control.NIC0, control.Target0, tt.nic1p, tt.tgt1p} {
+			if got := binary.LittleEndian.Uint16(b[c+8+2*x:]); got != p {
+				t.Errorf("%s: %s pointer got %#x, want %#x", tt.n, ibftStructs[x], got, p)
+			}
+		}
+		dropped := 0
+		if tt.nic1p == 0 {
+			dropped += int(ibftNICLen) + len(i.NIC1.HostName) + 1
+		}
+		if tt.tgt1p == 0 {
+			t1 := i.Target1
+			// The three names are followed by a NUL.
+			dropped += int(ibftTargetLen) + len(t1.TargetName) + len(t1.CHAPName) + len(t1.CHAPSecret) + len(t1.ReverseCHAPName) + len(t1.ReverseCHAPSecret) + 3
+		}
+		if len(b) != len(fixed)-dropped {
+			t.Errorf("%s: MarshalCompact: got %d bytes, want %d", tt.n, len(b), len(fixed)-dropped)
+		}
+
+		r := &IBFT{}
+		if err := r.Unmarshal(b); err != nil {
+			t.Fatalf("%s: Unmarshal: got %v, want nil", tt.n, err)
+		}
+		if r.Initiator.Name != i.Initiator.Name || r.NIC0.IPAddress != i.NIC0.IPAddress || r.Target0.TargetName != i.Target0.TargetName {
+			t.Errorf("%s: Unmarshal: got %v, want the first path of %v", tt.n, r, i)
+		}
+		if tt.tgt1p != 0 && r.Target1.TargetName != i.Target1.TargetName {
+			t.Errorf("%s: Target1.TargetName: got %q, want %q", tt.n, r.Target1.TargetName, i.Target1.TargetName)
+		}
+	}
+}
+
+func TestIBFTMarshalSplit(t *testing.T) {
+	i := testIBFT()
+	want, err := Marshal(i)
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+	head, heap, gap, err := i.MarshalSplit()
+	if err != nil {
+		t.Fatalf("MarshalSplit: got %v, want nil", err)
+	}
+	if len(head) != int(ibftHeadersLen) || len(heap) == 0 {
+		t.Errorf("MarshalSplit: head is %d bytes and heap %d, want %d and more than 0", len(head), len(heap), ibftHeadersLen)
+	}
+	got := append(append(append([]byte{}, head...), make([]byte, gap)...), heap...)
+	if !bytes.Equal(got, want) {
+		t.Errorf("MarshalSplit: head, gap and heap are %#x, want %#x", got, want)
+	}
+}
+
+func TestIBFTInternHeap(t *testing.T) {
+	defer func(o bool) { IBFTInternHeap = o }(IBFTInternHeap)
+	i := testIBFT()
+	i.Target1.CHAPName = i.Target0.CHAPName
+	IBFTInternHeap = false
+	dup, err := Marshal(i)
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+	IBFTInternHeap = true
+	b, err := Marshal(i)
+	if err != nil {
+		t.Fatalf("Marshal interned: got %v, want nil", err)
+	}
+	if got, want := len(b), len(dup)-len(i.Target0.CHAPName)-1; got != want {
+		t.Errorf("Interned length: got %d, want %d", got, want)
+	}
+	// CHAPName is at 38 in a Target.
+	t1 := ibftHeadersLen - ibftTargetLen + 38
+	t0 := t1 - ibftTargetLen - ibftNICLen
+	if !bytes.Equal(b[t0:t0+4], b[t1:t1+4]) {
+		t.Errorf("Target1.CHAPName: got length and offset %#x, want %#x, the same as Target0", b[t1:t1+4], b[t0:t0+4])
+	}
+	if n := bytes.Count(b[ibftHeadersLen:], []byte(i.Target0.CHAPName)); n != 1 {
+		t.Errorf("Heap has %d copies of %q, want 1", n, i.Target0.CHAPName)
+	}
+	r := &IBFT{}
+	if err := r.Unmarshal(b); err != nil {
+		t.Fatalf("Unmarshal: got %v, want nil", err)
+	}
+	if r.Target1.CHAPName != i.Target0.CHAPName {
+		t.Errorf("Unmarshaled Target1.CHAPName: got %q, want %q", r.Target1.CHAPName, i.Target0.CHAPName)
+	}
+}
+
+func TestIBFTMarshalAnnotated(t *testing.T) {
+	i := testIBFT()
+	want, err := Marshal(i)
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+	b, a, err := i.MarshalAnnotated()
+	if err != nil {
+		t.Fatalf("MarshalAnnotated: got %v, want nil", err)
+	}
+	if !bytes.Equal(b, want) {
+		t.Fatalf("MarshalAnnotated: got %#x, want %#x", b, want)
+	}
+	// With no interning, the entries cover each byte of the table
+	// exactly once, padding included.
+	o := 0
+	m := map[string]LayoutEntry{}
+	for _, e := range a {
+		if e.Offset != o {
+			t.Errorf("%s: got offset %d, want %d, the end of the previous entry", e.Name, e.Offset, o)
+		}
+		o = e.Offset + e.Len
+		m[e.Name] = e
+	}
+	if o != len(b) {
+		t.Errorf("Annotations: end at %d, want %d", o, len(b))
+	}
+	at := func(n string) []byte {
+		e, ok := m[n]
+		if !ok {
+			t.Fatalf("No annotation for %s", n)
+		}
+		return b[e.Offset : e.Offset+e.Len]
+	}
+	var tests = []struct {
+		n    string
+		want []byte
+	}{
+		{"Header.Signature", []byte("IBFT")},
+		{"Control.NIC0", []byte{byte(m["NIC0.ID"].Offset), byte(m["NIC0.ID"].Offset >> 8)}},
+		{"NIC0.ID", []byte{byte(ibftNIC)}},
+		{"NIC0.Flags", []byte{7}},
+		{"NIC0.IPAddress", net.ParseIP("5.5.5.5").To16()},
+		{"Target0.CHAPName.Length", []byte{5, 0}},
+		{"Target0.CHAPName.Offset", []byte{byte(m["Target0.CHAPName"].Offset), byte(m["Target0.CHAPName"].Offset >> 8)}},
+		{"Target0.CHAPName", []byte("clown")},
+	}
+	for _, tt := range tests {
+		if got := at(tt.n); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got %#x, want %#x", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestIBFTActualLength(t *testing.T) {
+	defer func(o bool) { IBFTActualLength = o }(IBFTActualLength)
+	// The structures are padded, so the two modes differ: e.g. NIC0,
+	// at 256, takes up 128 bytes, up to Target0 at 384.
+	defer func(a int) { IBFTStructAlign = a }(IBFTStructAlign)
+	IBFTStructAlign = 64
+	want := map[string]uint16{"Initiator": ibftInitiatorLen, "NIC0": ibftNICLen, "Target0": ibftTargetLen, "NIC1": ibftNICLen, "Target1": ibftTargetLen}
+	for _, actual := range []bool{false, true} {
+		IBFTActualLength = actual
+		b, l, err := testIBFT().MarshalVerbose()
+		if err != nil {
+			t.Fatalf("MarshalVerbose, actual %v: got %v, want nil", actual, err)
+		}
+		// The structures, in the order they are in the table.
+		var s []LayoutEntry
+		for _, e := range l {
+			if _, ok := want[e.Name]; ok {
+				s = append(s, e)
+			}
+		}
+		if len(s) != len(want) {
+			t.Fatalf("Layout, actual %v: got %d structures, want %d", actual, len(s), len(want))
+		}
+		for x, e := range s {
+			w := want[e.Name]
+			// With the actual length, each Length reaches the next
+			// structure, past the padding for IBFTStructAlign.
+			if actual && x+1 < len(s) {
+				w = uint16(s[x+1].Offset - e.Offset)
+			}
+			if got := binary.LittleEndian.Uint16(b[e.Offset+2:]); got != w {
+				t.Errorf("%s Length, actual %v: got %d, want %d", e.Name, actual, got, w)
+			}
+		}
+		if got := binary.LittleEndian.Uint16(b[s[1].Offset+2:]); actual && got != 128 {
+			t.Errorf("NIC0 Length, actual %v: got %d, want 128", actual, got)
+		}
+		r, err := NewIBFTReader(b)
+		if err != nil {
+			t.Fatalf("NewIBFTReader, actual %v: got %v, want nil", actual, err)
+		}
+		i, err := r.IBFT()
+		if err != nil {
+			t.Fatalf("IBFT, actual %v: got %v, want nil", actual, err)
+		}
+		if len(r.Warnings) != 0 {
+			t.Errorf("IBFT, actual %v: got warnings %q, want none", actual, r.Warnings)
+		}
+		if i.NIC1.HostName != testIBFT().NIC1.HostName || i.Target1.TargetName != testIBFT().Target1.TargetName {
+			t.Errorf("IBFT, actual %v: got NIC1.HostName %q, Target1.TargetName %q, want %q, %q", actual, i.NIC1.HostName, i.Target1.TargetName, testIBFT().NIC1.HostName, testIBFT().Target1.TargetName)
+		}
+	}
+}
+
+func TestIBFTMarshalControl(t *testing.T) {
+	i := testIBFT()
+	c, err := i.MarshalControl()
+	if err != nil {
+		t.Fatalf("MarshalControl: got %v, want nil", err)
+	}
+	if len(c) != int(ibftControlLen) {
+		t.Fatalf("MarshalControl: got %d bytes, want %d", len(c), ibftControlLen)
+	}
+	if got, want := c[:6], []byte{byte(ibftControl), 1, byte(ibftControlLen), 0, 0, 1}; !bytes.Equal(got, want) {
+		t.Errorf("Header: got %#x, want %#x", got, want)
+	}
+	// The structures follow the control structure, one after the
+	// other: Initiator, NIC0, Target0, NIC1, Target1.
+	var tests = []struct {
+		n    string
+		o    int
+		want uint16
+	}{
+		{"Extensions", 6, 0},
+		{"Initiator", 8, 66},
+		{"NIC0", 10, 140},
+		{"Target0", 12, 242},
+		{"NIC1", 14, 296},
+		{"Target1", 16, 398},
+	}
+	for _, tt := range tests {
+		if got := binary.LittleEndian.Uint16(c[tt.o:]); got != tt.want {
+			t.Errorf("%s pointer: got %d, want %d", tt.n, got, tt.want)
+		}
+	}
+	b, err := Marshal(i)
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+	if m := b[ibftHeaderLen : ibftHeaderLen+ibftControlLen]; !bytes.Equal(c, m) {
+		t.Errorf("MarshalControl: got %#x, want %#x, as Marshal writes it", c, m)
+	}
+
+	// The pointers follow IBFTStructAlign, as Marshal's do.
+	func() {
+		defer func(a int) { IBFTStructAlign = a }(IBFTStructAlign)
+		IBFTStructAlign = 64
+		c, err := i.MarshalControl()
+		if err != nil {
+			t.Fatalf("MarshalControl with IBFTStructAlign 64: got %v, want nil", err)
+		}
+		if got := binary.LittleEndian.Uint16(c[8:]); got != 128 {
+			t.Errorf("Initiator pointer with IBFTStructAlign 64: got %d, want 128", got)
+		}
+		b, err := Marshal(i)
+		if err != nil {
+			t.Fatalf("Marshal with IBFTStructAlign 64: got %v, want nil", err)
+		}
+		if m := b[ibftHeaderLen : ibftHeaderLen+ibftControlLen]; !bytes.Equal(c, m) {
+			t.Errorf("MarshalControl with IBFTStructAlign 64: got %#x, want %#x, as Marshal writes it", c, m)
+		}
+	}()
+
+	i.Multi = "0"
+	if c, err = i.MarshalControl(); err != nil {
+		t.Errorf("MarshalControl with Multi 0: got %v, want nil", err)
+	} else if c[5] != 0 {
+		t.Errorf("MarshalControl with Multi 0: got flags %#x, want 0", c[5])
+	}
+	i.Multi = "x"
+	if _, err := i.MarshalControl(); err == nil {
+		t.Errorf("MarshalControl with Multi %q: got nil, want error", i.Multi)
+	}
+}
+
+func TestIBFTStructAlign(t *testing.T) {
+	defer func(a int) { IBFTStructAlign = a }(IBFTStructAlign)
+	packed, err := Marshal(testIBFT())
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+	var tests = []struct {
+		align int
+		ptrs  []uint16
+		end   int
+	}{
+		{0, []uint16{66, 140, 242, 296, 398}, 452},
+		{1, []uint16{66, 140, 242, 296, 398}, 452},
+		{64, []uint16{128, 256, 384, 448, 576}, 630},
+	}
+	for _, tt := range tests {
+		IBFTStructAlign = tt.align
+		i := testIBFT()
+		b, err := Marshal(i)
+		if err != nil {
+			t.Fatalf("Marshal, align %d: got %v, want nil", tt.align, err)
+		}
+		for x, want := range tt.ptrs {
+			if got := binary.LittleEndian.Uint16(b[int(ibftHeaderLen)+8+2*x:]); got != want {
+				t.Errorf("%s pointer, align %d: got %d, want %d", ibftStructs[x], tt.align, got, want)
+			}
+		}
+		if got, want := len(b), len(packed)+tt.end-int(ibftHeadersLen); got != want {
+			t.Errorf("Length, align %d: got %d, want %d", tt.align, got, want)
+		}
+		if got, want := i.MarshalSize(1), len(b); got != want {
+			t.Errorf("MarshalSize(1), align %d: got %d, want %d", tt.align, got, want)
+		}
+		head, heap, _, err := i.MarshalSplit()
+		if err != nil || len(head) != tt.end || !bytes.Equal(heap, packed[ibftHeadersLen:]) {
+			t.Errorf("MarshalSplit, align %d: got a %d byte head, err %v, want %d, nil, and the same heap", tt.align, len(head), err, tt.end)
+		}
+		r, err := NewIBFTReader(b)
+		if err != nil {
+			t.Fatalf("NewIBFTReader, align %d: got %v, want nil", tt.align, err)
+		}
+		u, err := r.IBFT()
+		if err != nil {
+			t.Fatalf("IBFT, align %d: got %v, want nil", tt.align, err)
+		}
+		if len(r.Warnings) != 0 {
+			t.Errorf("IBFT, align %d: got warnings %q, want none", tt.align, r.Warnings)
+		}
+		if u.Target1.TargetName != i.Target1.TargetName || u.NIC1.MACAddress != i.NIC1.MACAddress {
+			t.Errorf("IBFT, align %d: got Target1.TargetName %q, NIC1.MACAddress %q, want %q, %q", tt.align, u.Target1.TargetName, u.NIC1.MACAddress, i.Target1.TargetName, i.NIC1.MACAddress)
+		}
+		if _, err := i.MarshalStrict(); err != nil {
+			t.Errorf("MarshalStrict, align %d: got %v, want nil", tt.align, err)
+		}
+	}
+	for _, a := range []int{-1, 1 << 16} {
+		IBFTStructAlign = a
+		if _, err := Marshal(testIBFT()); err == nil {
+			t.Errorf("Marshal, align %d: got nil, want error", a)
+		}
+	}
+}
+
+func TestIBFTTargetHostPort(t *testing.T) {
+	var tests = []struct {
+		combined sockaddr
+		host     string
+		port     uint16
+	}{
+		{"10.0.0.1:3260", "10.0.0.1", 3260},
+		{"10.0.0.1:860", "10.0.0.1", 860},
+		{"[fd00::1]:3260", "fd00::1", 3260},
+		{"[fd00::1]:3261", "[fd00::1]", 3261},
+	}
+	for _, tt := range tests {
+		want := testIBFT()
+		want.Target0.TargetIP = tt.combined
+		wb, err := Marshal(want)
+		if err != nil {
+			t.Fatalf("Marshal %q: got %v, want nil", tt.combined, err)
+		}
+		// Host then port, and port then host, from nothing.
+		for _, hostFirst := range []bool{true, false} {
+			i := testIBFT()
+			i.Target0.TargetIP = ""
+			if !hostFirst {
+				i.Target0.SetTargetPort(tt.port)
+			}
+			if err := i.Target0.SetTargetHost(tt.host); err != nil {
+				t.Fatalf("SetTargetHost(%q): got %v, want nil", tt.host, err)
+			}
+			if hostFirst {
+				i.Target0.SetTargetPort(tt.port)
+			}
+			b, err := Marshal(i)
+			if err != nil {
+				t.Fatalf("Marshal %q, %d: got %v, want nil", tt.host, tt.port, err)
+			}
+			if !bytes.Equal(b, wb) {
+				t.Errorf("SetTargetHost(%q), SetTargetPort(%d), host first %v: got TargetIP %q, and bytes which differ from %q", tt.host, tt.port, hostFirst, i.Target0.TargetIP, tt.combined)
+			}
+		}
+	}
+
+	// A host alone gets the iSCSI port, and a port keeps the host.
+	var tgt IBFTTarget
+	if err := tgt.SetTargetHost("10.0.0.2"); err != nil || tgt.TargetIP != "10.0.0.2:3260" {
+		t.Errorf("SetTargetHost(%q): got %q, %v, want %q, nil", "10.0.0.2", tgt.TargetIP, err, "10.0.0.2:3260")
+	}
+	tgt.TargetPort = "3260"
+	if tgt.SetTargetPort(861); tgt.TargetIP != "10.0.0.2:861" || tgt.TargetPort != "861" {
+		t.Errorf("SetTargetPort(861): got TargetIP %q, TargetPort %q, want %q, %q", tgt.TargetIP, tgt.TargetPort, "10.0.0.2:861", "861")
+	}
+	for _, h := range []string{"", "target.example.com", "10.0.0.2:3260", "300.1.1.1"} {
+		if err := tgt.SetTargetHost(h); err == nil {
+			t.Errorf("SetTargetHost(%q): got nil, want error", h)
+		}
+	}
+	if tgt.TargetIP != "10.0.0.2:861" {
+		t.Errorf("TargetIP after failed SetTargetHost: got %q, want %q", tgt.TargetIP, "10.0.0.2:861")
+	}
+}
+
+func TestIBFTSetTargetCHAPSecret(t *testing.T) {
+	defer func(o bool) { HeapNUL[HeapName] = o }(HeapNUL[HeapName])
+	HeapNUL[HeapName] = false
+	b, err := ioutil.ReadFile("testdata/ibft.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := &IBFT{}
+	if err := i.Unmarshal(b); err != nil {
+		t.Fatalf("Unmarshal: got %v, want nil", err)
+	}
+	old := i.Clone()
+	const secret = "a-longer-rotated-secret"
+	if err := i.SetTargetCHAPSecret(0, secret); err != nil {
+		t.Fatalf("SetTargetCHAPSecret(0, %q): got %v, want nil", secret, err)
+	}
+	if i.Len() != 0 {
+		t.Errorf("Len after SetTargetCHAPSecret: got %d, want 0, with the old table dropped", i.Len())
+	}
+	n, err := Marshal(i)
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+	if got, want := len(n), len(b)+len(secret)-len(old.Target0.CHAPSecret); got != want {
+		t.Errorf("Marshal: got %d bytes, want %d", got, want)
+	}
+	r, err := NewIBFTReader(n)
+	if err != nil {
+		t.Fatalf("NewIBFTReader: got %v, want nil", err)
+	}
+	got, err := r.IBFT()
+	if err != nil {
+		t.Fatalf("IBFT: got %v, want nil", err)
+	}
+	if len(r.Warnings) != 0 {
+		t.Errorf("IBFT: got warnings %q, want none", r.Warnings)
+	}
+	if got.Target0.CHAPSecret != secret {
+		t.Errorf("Target0.CHAPSecret: got %q, want %q", got.Target0.CHAPSecret, secret)
+	}
+	// Everything after it in the heap moved, but reads back the same.
+	old.Target0.CHAPSecret = secret
+	for _, f := range ibftStructs {
+		g, w := reflect.ValueOf(got).Elem().FieldByName(f).Interface(), reflect.ValueOf(old).Elem().FieldByName(f).Interface()
+		if !reflect.DeepEqual(g, w) {
+			t.Errorf("%s: got %+v, want %+v", f, g, w)
+		}
+	}
+
+	for _, x := range []int{-1, 2} {
+		if err := i.SetTargetCHAPSecret(x, secret); err == nil {
+			t.Errorf("SetTargetCHAPSecret(%d, %q): got nil, want error", x, secret)
+		}
+	}
+}
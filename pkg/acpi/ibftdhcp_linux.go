@@ -0,0 +1,55 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package acpi
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/u-root/u-root/pkg/dhclient"
+)
+
+// NICFromLease returns an IBFTNIC for the interface a DHCP lease is
+// on, as NICFromInterface does, with the IPAddress, SubNet, Gateway,
+// DNS servers, HostName and DHCP server from the lease, and an Origin
+// of DHCP, so that a boot which got its address from DHCP can pass it
+// on in an IBFT. Only DHCPv4 leases are supported: a DHCPv6 lease has
+// no gateway, and identifies its server by DUID, not address. The
+// lease must be on a link with a MAC, which is how the OS finds the NIC.
+func NICFromLease(l dhclient.Lease) (IBFTNIC, error) {
+	p, ok := l.(*dhclient.Packet4)
+	if !ok {
+		return IBFTNIC{}, fmt.Errorf("NICFromLease: %T is not a DHCPv4 lease", l)
+	}
+	a := p.Lease()
+	if a == nil || a.IP == nil || a.IP.IsUnspecified() {
+		return IBFTNIC{}, fmt.Errorf("NICFromLease: %v has no address", l)
+	}
+	link := p.Link()
+	if link == nil || len(link.Attrs().HardwareAddr) == 0 {
+		return IBFTNIC{}, fmt.Errorf("NICFromLease: %v is not on a link with a MAC address", l)
+	}
+	la := link.Attrs()
+	iface := net.Interface{Index: la.Index, Name: la.Name, HardwareAddr: la.HardwareAddr}
+	var gw net.IP
+	if r := p.P.Router(); len(r) > 0 {
+		gw = r[0]
+	}
+	n := NICFromInterface(iface, *a, gw)
+	n.Origin = ibftOriginDHCP
+	if d := p.P.DNS(); len(d) > 0 {
+		n.PrimaryDNS = ipaddr(d[0].String())
+		if len(d) > 1 {
+			n.SecondaryDNS = ipaddr(d[1].String())
+		}
+	}
+	if s := p.P.ServerIdentifier(); s != nil {
+		n.DHCP = ipaddr(s.String())
+	}
+	n.HostName = sheap(p.P.HostName())
+	return n, nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package acpi
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/u-root/u-root/pkg/dhclient"
+	"github.com/vishvananda/netlink"
+)
+
+func TestNICFromLease(t *testing.T) {
+	hw := net.HardwareAddr{0x00, 0x0c, 0x29, 0x12, 0xa4, 0x2e}
+	p, err := dhcpv4.New(
+		dhcpv4.WithYourIP(net.IPv4(10, 0, 2, 15)),
+		dhcpv4.WithNetmask(net.CIDRMask(24, 32)),
+		dhcpv4.WithRouter(net.IPv4(10, 0, 2, 2)),
+		dhcpv4.WithDNS(net.IPv4(10, 0, 2, 3), net.IPv4(8, 8, 8, 8)),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.IPv4(10, 0, 2, 4))),
+		dhcpv4.WithOption(dhcpv4.OptHostName("client")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Index: 2, Name: "eth0", HardwareAddr: hw}}
+	n, err := NICFromLease(dhclient.NewPacket4(link, p))
+	if err != nil {
+		t.Fatalf("NICFromLease: got %v, want nil", err)
+	}
+	want := IBFTNIC{
+		Valid:        "1",
+		Boot:         "0",
+		Global:       "1",
+		Index:        "0",
+		IPAddress:    "10.0.2.15",
+		SubNet:       "24",
+		Origin:       "3",
+		Gateway:      "10.0.2.2",
+		PrimaryDNS:   "10.0.2.3",
+		SecondaryDNS: "8.8.8.8",
+		DHCP:         "10.0.2.4",
+		MACAddress:   "00:0c:29:12:a4:2e",
+		HostName:     "client",
+	}
+	if n != want {
+		t.Errorf("NICFromLease: got %+v, want %+v", n, want)
+	}
+
+	// With no address, there is nothing to put in an IBFT.
+	p, err = dhcpv4.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := NICFromLease(dhclient.NewPacket4(link, p)); err == nil {
+		t.Errorf("NICFromLease with no address: got %+v, want error", n)
+	}
+	// Nor is there without a MAC, to say which NIC it is.
+	p, err = dhcpv4.New(dhcpv4.WithYourIP(net.IPv4(10, 0, 2, 15)), dhcpv4.WithNetmask(net.CIDRMask(24, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := NICFromLease(dhclient.NewPacket4(nil, p)); err == nil {
+		t.Errorf("NICFromLease with no link: got %+v, want error", n)
+	}
+	nomac := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Index: 3, Name: "tun0"}}
+	if n, err := NICFromLease(dhclient.NewPacket4(nomac, p)); err == nil {
+		t.Errorf("NICFromLease on a link with no MAC: got %+v, want error", n)
+	}
+	if n, err := NICFromLease(&dhclient.Packet6{}); err == nil {
+		t.Errorf("NICFromLease with a DHCPv6 lease: got %+v, want error", n)
+	}
+}
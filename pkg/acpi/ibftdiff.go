@@ -0,0 +1,121 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acpi
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+// FieldDiff is a run of bytes which differ between two marshaled IBFTs,
+// all in one field. A and B are the bytes from each table; one of them
+// is short, or empty, if its table is.
+type FieldDiff struct {
+	// Name is the field, e.g. NIC0.HostNameOffset, or the heap
+	// entry, e.g. NIC0.HostName. It is "" for bytes in no field.
+	Name   string
+	Offset int
+	A, B   []byte
+}
+
+// ibftRegion is a named range of bytes in a marshaled IBFT.
+type ibftRegion struct {
+	name     string
+	off, end int
+}
+
+// ibftRegions returns the fields of the marshaled IBFT b, using the
+// structure pointers in its control structure, and the heap entries
+// they point to. It is forgiving of broken tables: what it can not
+// find, it leaves out.
+func ibftRegions(b []byte) []ibftRegion {
+	u16 := func(o int) int {
+		if o < 0 || o+2 > len(b) {
+			return 0
+		}
+		return int(binary.LittleEndian.Uint16(b[o:]))
+	}
+	var rs, heap []ibftRegion
+	add := func(prefix string, base int, v interface{}) {
+		fds := FieldDescriptions(v)
+		for i, fd := range fds {
+			rs = append(rs, ibftRegion{name: prefix + fd.Name, off: base + fd.Offset, end: base + fd.Offset + fd.Size})
+			// Heap entries are a Length, then an Offset.
+			if i+1 == len(fds) || !strings.HasSuffix(fd.Name, "Length") || fd.Desc != "Heap Entry Length" {
+				continue
+			}
+			if l, o := u16(base+fd.Offset), u16(base+fds[i+1].Offset); l > 0 && o > 0 {
+				heap = append(heap, ibftRegion{name: prefix + strings.TrimSuffix(fd.Name, "Length"), off: o, end: o + l})
+			}
+		}
+	}
+	add("Header.", 0, acpiIBFTHeader{})
+	c := int(ibftHeaderLen)
+	add("Control.", c, acpiIBFTControl{})
+	for i, s := range []struct {
+		n string
+		v interface{}
+	}{
+		{"Initiator", acpiIBFTInitiator{}},
+		{"NIC0", acpiIBFTNIC{}},
+		{"Target0", acpiIBFTTarget{}},
+		{"NIC1", acpiIBFTNIC{}},
+		{"Target1", acpiIBFTTarget{}},
+	} {
+		if o := u16(c + 8 + 2*i); o != 0 {
+			add(s.n+".", o, s.v)
+		}
+	}
+	// The heap entries come last, so that fields win if a
+	// broken heap entry overlaps them.
+	return append(rs, heap...)
+}
+
+// regionName returns the name of the first region containing o.
+func regionName(rs []ibftRegion, o int) string {
+	for _, r := range rs {
+		if o >= r.off && o < r.end {
+			return r.name
+		}
+	}
+	return ""
+}
+
+// DiffBytes compares two marshaled IBFTs, e.g. one from firmware and
+// one we made, and returns the runs of bytes which differ, labeled with
+// the field they are in. The fields are found from the pointers in a,
+// or in b for the bytes past the end of a.
+func DiffBytes(a, b []byte) []FieldDiff {
+	ra, rb := ibftRegions(a), ibftRegions(b)
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+	var (
+		diffs []FieldDiff
+		last  = -1
+	)
+	for o := 0; o < n; o++ {
+		if o < len(a) && o < len(b) && a[o] == b[o] {
+			continue
+		}
+		name := regionName(rb, o)
+		if o < len(a) {
+			name = regionName(ra, o)
+		}
+		if last != o-1 || len(diffs) == 0 || diffs[len(diffs)-1].Name != name {
+			diffs = append(diffs, FieldDiff{Name: name, Offset: o})
+		}
+		d := &diffs[len(diffs)-1]
+		if o < len(a) {
+			d.A = append(d.A, a[o])
+		}
+		if o < len(b) {
+			d.B = append(d.B, b[o])
+		}
+		last = o
+	}
+	return diffs
+}
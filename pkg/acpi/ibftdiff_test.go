@@ -0,0 +1,42 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acpi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffBytes(t *testing.T) {
+	a, err := Marshal(testIBFT())
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+	i := testIBFT()
+	i.Initiator.Name = "myinitar"
+	i.Target0.TargetIP = "1.2.3.4:3260"
+	b, err := Marshal(i)
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+	b = append(b, 0xff, 0xfe)
+
+	var got []string
+	for _, d := range DiffBytes(a, b) {
+		got = append(got, d.Name)
+	}
+	want := []string{"Header.Checksum", "Target0.TargetIPSocket", "Initiator.InitiatorName", ""}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffBytes names: got %q, want %q", got, want)
+	}
+
+	d := DiffBytes(a, b)
+	if l := d[len(d)-1]; l.Offset != len(a) || len(l.A) != 0 || !reflect.DeepEqual(l.B, []byte{0xff, 0xfe}) {
+		t.Errorf("DiffBytes past the end of a: got %+v, want %#x at %d", l, []byte{0xff, 0xfe}, len(a))
+	}
+	if d := DiffBytes(a, a); d != nil {
+		t.Errorf("DiffBytes of a table with itself: got %v, want nil", d)
+	}
+}
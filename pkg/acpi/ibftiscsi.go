@@ -0,0 +1,196 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acpi
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// ISCSITarget is what an iSCSI initiator needs to log in to a target
+// from an IBFT: where it is, what it is called, which LUN to use and,
+// if CHAP is used, the credentials.
+type ISCSITarget struct {
+	IP   net.IP
+	Port int
+	IQN  string
+	LUN  uint64
+	// Boot is true if firmware booted from this target.
+	Boot bool
+	// CHAPName and CHAPSecret are set for CHAP and mutual CHAP;
+	// ReverseCHAPName and ReverseCHAPSecret only for mutual CHAP.
+	CHAPName          string
+	CHAPSecret        string
+	ReverseCHAPName   string
+	ReverseCHAPSecret string
+}
+
+// CHAP types, from the target ChapType field.
+const (
+	ibftCHAP       u8 = "1"
+	ibftMutualCHAP u8 = "2"
+)
+
+// URI returns the target as an iSCSI URI as described in RFC 4173,
+// iscsi:<server>:<protocol>:<port>:<LUN>:<targetname>, with the
+// protocol left empty, meaning TCP. The LUN is in hex.
+func (t ISCSITarget) URI() string {
+	s := t.IP.String()
+	if t.IP.To4() == nil {
+		s = "[" + s + "]"
+	}
+	return fmt.Sprintf("iscsi:%s::%d:%s:%s", s, t.Port, strconv.FormatUint(t.LUN, 16), t.IQN)
+}
+
+// ISCSITargets returns the valid targets of the IBFT, in order.
+// Targets which can not be parsed, which Marshal would reject too, are
+// skipped, with a Debug message.
+func (ibft *IBFT) ISCSITargets() []ISCSITarget {
+	var ts []ISCSITarget
+	for x, tgt := range []*IBFTTarget{&ibft.Target0, &ibft.Target1} {
+		if tgt.Valid != "1" {
+			continue
+		}
+		t, err := tgt.iscsiTarget()
+		if err != nil {
+			Debug("IBFT Target%d: %v", x, err)
+			continue
+		}
+		ts = append(ts, t)
+	}
+	return ts
+}
+
+// iscsiTarget converts an IBFTTarget to an ISCSITarget.
+func (tgt *IBFTTarget) iscsiTarget() (ISCSITarget, error) {
+	a, err := tgt.socket()
+	if err != nil {
+		return ISCSITarget{}, err
+	}
+	h, p, err := net.SplitHostPort(string(a))
+	if err != nil {
+		return ISCSITarget{}, fmt.Errorf("TargetIP %q: %v", a, err)
+	}
+	ip := net.ParseIP(h)
+	if ip == nil {
+		return ISCSITarget{}, fmt.Errorf("TargetIP %q: %q is not an IP address", a, h)
+	}
+	port, err := strconv.ParseUint(p, 10, 16)
+	if err != nil {
+		return ISCSITarget{}, fmt.Errorf("TargetIP %q: port: %v", a, err)
+	}
+	lun, err := parseLUN(tgt.BootLUN)
+	if err != nil {
+		return ISCSITarget{}, err
+	}
+	t := ISCSITarget{
+		IP:   ip,
+		Port: int(port),
+		IQN:  string(tgt.TargetName),
+		LUN:  lun,
+		Boot: tgt.Boot == "1",
+	}
+	switch tgt.ChapType {
+	case ibftMutualCHAP:
+		t.ReverseCHAPName, t.ReverseCHAPSecret = string(tgt.ReverseCHAPName), string(tgt.ReverseCHAPSecret)
+		fallthrough
+	case ibftCHAP:
+		t.CHAPName, t.CHAPSecret = string(tgt.CHAPName), string(tgt.CHAPSecret)
+	}
+	return t, nil
+}
+
+// CHAPCred is the CHAP credentials for a target, e.g. for the
+// node.session.auth settings of open-iscsi. The secrets are byte
+// slices, so that they can be zeroed, with Zero, when done with.
+type CHAPCred struct {
+	// TargetIndex is 0 for Target0, 1 for Target1.
+	TargetIndex int
+	Username    string
+	Secret      []byte
+	// ReverseUsername and ReverseSecret are only set for mutual CHAP.
+	ReverseUsername string
+	ReverseSecret   []byte
+}
+
+// Zero zeroes the secrets.
+func (c *CHAPCred) Zero() {
+	for _, s := range [][]byte{c.Secret, c.ReverseSecret} {
+		for i := range s {
+			s[i] = 0
+		}
+	}
+}
+
+// CHAPCredentials returns the CHAP credentials of the valid targets
+// which use CHAP, as the ChapType says, in order. The secrets are
+// copies, so zeroing them leaves the IBFT alone.
+func (ibft *IBFT) CHAPCredentials() []CHAPCred {
+	var cs []CHAPCred
+	for x, tgt := range []*IBFTTarget{&ibft.Target0, &ibft.Target1} {
+		if tgt.Valid != "1" {
+			continue
+		}
+		c := CHAPCred{TargetIndex: x}
+		switch tgt.ChapType {
+		case ibftMutualCHAP:
+			c.ReverseUsername, c.ReverseSecret = string(tgt.ReverseCHAPName), []byte(tgt.ReverseCHAPSecret)
+			fallthrough
+		case ibftCHAP:
+			c.Username, c.Secret = string(tgt.CHAPName), []byte(tgt.CHAPSecret)
+		default:
+			continue
+		}
+		cs = append(cs, c)
+	}
+	return cs
+}
+
+// iscsistartGroup is the target portal group tag IscsistartArgs passes
+// with -g. The IBFT does not have one; 1 is what most targets use, and
+// what dracut passes.
+const iscsistartGroup = "1"
+
+// IscsistartArgs returns the arguments for iscsistart, from open-iscsi,
+// to log in to Target0 or Target1, as targetIndex says: the initiator
+// name, target name, portal group, address and port, and, if the target
+// uses CHAP, the credentials. The target must be valid.
+// The CHAP secrets are in the arguments, where anyone who can list
+// processes can see them, as with any iscsistart command line.
+func (ibft *IBFT) IscsistartArgs(targetIndex int) ([]string, error) {
+	tgts := []*IBFTTarget{&ibft.Target0, &ibft.Target1}
+	if targetIndex < 0 || targetIndex >= len(tgts) {
+		return nil, fmt.Errorf("IscsistartArgs: target index %d must be 0 or 1", targetIndex)
+	}
+	tgt := tgts[targetIndex]
+	if tgt.Valid != "1" {
+		return nil, fmt.Errorf("IscsistartArgs: Target%d is not valid", targetIndex)
+	}
+	if ibft.Initiator.Name == "" {
+		return nil, fmt.Errorf("IscsistartArgs: there is no initiator name")
+	}
+	t, err := tgt.iscsiTarget()
+	if err != nil {
+		return nil, fmt.Errorf("IscsistartArgs: Target%d: %v", targetIndex, err)
+	}
+	if t.IQN == "" {
+		return nil, fmt.Errorf("IscsistartArgs: Target%d has no TargetName", targetIndex)
+	}
+	args := []string{
+		"-i", string(ibft.Initiator.Name),
+		"-t", t.IQN,
+		"-g", iscsistartGroup,
+		"-a", t.IP.String(),
+		"-p", strconv.Itoa(t.Port),
+	}
+	if t.CHAPName != "" || t.CHAPSecret != "" {
+		args = append(args, "-u", t.CHAPName, "-w", t.CHAPSecret)
+	}
+	if t.ReverseCHAPName != "" || t.ReverseCHAPSecret != "" {
+		args = append(args, "-U", t.ReverseCHAPName, "-W", t.ReverseCHAPSecret)
+	}
+	return args, nil
+}
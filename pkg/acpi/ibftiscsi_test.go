@@ -0,0 +1,137 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acpi
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestISCSITargets(t *testing.T) {
+	i := testIBFT()
+	want := []ISCSITarget{
+		{
+			IP:   net.ParseIP("1.2.3.4"),
+			Port: 88,
+			IQN:  "target",
+			LUN:  1234,
+			Boot: true,
+		},
+		{
+			IP:                net.ParseIP("4.4.4.4"),
+			Port:              99,
+			IQN:               "bullseye",
+			LUN:               4444,
+			Boot:              true,
+			CHAPName:          "bozo",
+			CHAPSecret:        "bee",
+			ReverseCHAPName:   "barg",
+			ReverseCHAPSecret: "arg",
+		},
+	}
+	got := i.ISCSITargets()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ISCSITargets: got %+v, want %+v", got, want)
+	}
+
+	// Invalid and unparseable targets are skipped.
+	i.Target0.Valid = "0"
+	i.Target1.TargetIP = "bullseye"
+	if got := i.ISCSITargets(); len(got) != 0 {
+		t.Errorf("ISCSITargets with no usable targets: got %+v, want none", got)
+	}
+}
+
+func TestISCSITargetURI(t *testing.T) {
+	var tests = []struct {
+		t    ISCSITarget
+		want string
+	}{
+		{ISCSITarget{IP: net.ParseIP("1.2.3.4"), Port: 3260, LUN: 0, IQN: "iqn.2009-06.com.example:target0"}, "iscsi:1.2.3.4::3260:0:iqn.2009-06.com.example:target0"},
+		{ISCSITarget{IP: net.ParseIP("fe80::1"), Port: 3260, LUN: 26, IQN: "iqn.2009-06.com.example:target1"}, "iscsi:[fe80::1]::3260:1a:iqn.2009-06.com.example:target1"},
+	}
+	for _, tt := range tests {
+		if got := tt.t.URI(); got != tt.want {
+			t.Errorf("URI(): got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestCHAPCredentials(t *testing.T) {
+	i := testIBFT()
+	i.Target0.ChapType = "1"
+	want := []CHAPCred{
+		{TargetIndex: 0, Username: "clown", Secret: []byte("noun")},
+		{TargetIndex: 1, Username: "bozo", Secret: []byte("bee"), ReverseUsername: "barg", ReverseSecret: []byte("arg")},
+	}
+	got := i.CHAPCredentials()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("CHAPCredentials: got %+v, want %+v", got, want)
+	}
+
+	got[1].Zero()
+	if !reflect.DeepEqual(got[1].Secret, []byte{0, 0, 0}) || !reflect.DeepEqual(got[1].ReverseSecret, []byte{0, 0, 0}) {
+		t.Errorf("Zero: got secrets %v and %v, want zeros", got[1].Secret, got[1].ReverseSecret)
+	}
+	if i.Target1.CHAPSecret != "bee" {
+		t.Errorf("Zero changed the IBFT: Target1.CHAPSecret is %q, want %q", i.Target1.CHAPSecret, "bee")
+	}
+
+	// Targets which are not valid, or do not use CHAP, are skipped.
+	i.Target0.ChapType = "0"
+	i.Target1.Valid = "0"
+	if got := i.CHAPCredentials(); len(got) != 0 {
+		t.Errorf("CHAPCredentials with no CHAP targets: got %+v, want none", got)
+	}
+}
+
+func TestIscsistartArgs(t *testing.T) {
+	i := validIBFT()
+	var tests = []struct {
+		x    int
+		want []string
+	}{
+		{0, []string{"-i", "iqn.2009-06.com.example:initiator", "-t", "target", "-g", "1", "-a", "1.2.3.4", "-p", "88"}},
+		{1, []string{"-i", "iqn.2009-06.com.example:initiator", "-t", "bullseye", "-g", "1", "-a", "4.4.4.4", "-p", "99",
+			"-u", "bozo", "-w", "bee", "-U", "barg", "-W", "arg"}},
+	}
+	for _, tt := range tests {
+		got, err := i.IscsistartArgs(tt.x)
+		if err != nil {
+			t.Errorf("IscsistartArgs(%d): got %v, want nil", tt.x, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("IscsistartArgs(%d): got %q, want %q", tt.x, got, tt.want)
+		}
+	}
+
+	// One way CHAP has no -U or -W.
+	i.Target1.ChapType = ibftCHAP
+	want := tests[1].want[:len(tests[1].want)-4]
+	if got, err := i.IscsistartArgs(1); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("IscsistartArgs(1) with CHAP: got (%q, %v), want (%q, nil)", got, err, want)
+	}
+
+	for _, tt := range []struct {
+		n string
+		x int
+		f func(*IBFT)
+	}{
+		{"index 2", 2, func(*IBFT) {}},
+		{"index -1", -1, func(*IBFT) {}},
+		{"invalid target", 0, func(i *IBFT) { i.Target0.Valid = "0" }},
+		{"no initiator name", 0, func(i *IBFT) { i.Initiator.Name = "" }},
+		{"no target name", 0, func(i *IBFT) { i.Target0.TargetName = "" }},
+		{"bad address", 0, func(i *IBFT) { i.Target0.TargetIP = "target" }},
+	} {
+		i := validIBFT()
+		tt.f(i)
+		if got, err := i.IscsistartArgs(tt.x); err == nil {
+			t.Errorf("%s: IscsistartArgs(%d): got %q, want error", tt.n, tt.x, got)
+		}
+	}
+}
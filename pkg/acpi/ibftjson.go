@@ -0,0 +1,358 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acpi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// IBFTSchemaVersion is the version of the IBFT JSON format written by
+// MarshalJSON. People keep IBFT configs in files for a long time, so the
+// version is recorded in the JSON as "schemaVersion". Older versions are
+// upgraded on read; newer ones are rejected, since we can not know what
+// they mean.
+const IBFTSchemaVersion = 1
+
+// ibftMigrations upgrade the JSON for an IBFT by one version.
+// ibftMigrations[n] upgrades version n to version n+1.
+var ibftMigrations = []func(map[string]json.RawMessage) error{
+	// Version 0 is the original, unversioned, format.
+	// It has the same layout as version 1.
+	func(map[string]json.RawMessage) error { return nil },
+}
+
+// IBFTOmitUnset makes MarshalJSON leave out the fields of an IBFT which
+// are not set, i.e. are empty strings or zero, instead of writing them
+// as "" or 0. JSON written this way only has the fields which were set,
+// so it can be unmarshaled on top of another IBFT, a base config, to
+// override just those fields, as Merge does.
+// The fields are then in alphabetical order.
+var IBFTOmitUnset bool
+
+// IBFTZeroUnsetIPs makes MarshalJSON write IP address fields which are
+// not set, i.e. are "", as "::", for firmware, or people, who tell a
+// field which is absent from one which is explicitly zero. By default,
+// they are written as "", or, for a NIC's DHCP, left out.
+// In the table itself there is no such difference: an IP address field
+// is always 16 bytes, and one which is not set is 16 zero bytes, which
+// is also what "::" marshals to, and what Unmarshal reads back as "::".
+var IBFTZeroUnsetIPs bool
+
+// zeroIP is the IP address an unset one is written as in JSON when
+// IBFTZeroUnsetIPs is set.
+const zeroIP ipaddr = "::"
+
+// MarshalJSON implements json.Marshaler. An IP address is written as a
+// string, and, if it is not set and IBFTZeroUnsetIPs is, as zeroIP.
+func (a ipaddr) MarshalJSON() ([]byte, error) {
+	if a == "" && IBFTZeroUnsetIPs {
+		a = zeroIP
+	}
+	return json.Marshal(string(a))
+}
+
+// String returns the MAC with its hex digits in the case HexUpper
+// asks for. The mac itself is not changed.
+func (m mac) String() string {
+	return hexCase(string(m))
+}
+
+// MarshalJSON implements json.Marshaler. A MAC is written as String
+// writes it.
+func (m mac) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// String returns the PCI BDF with its hex digits in the case HexUpper
+// asks for. The bdf itself is not changed.
+func (b bdf) String() string {
+	return hexCase(string(b))
+}
+
+// MarshalJSON implements json.Marshaler. A PCI BDF is written as
+// String writes it.
+func (b bdf) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b.String())
+}
+
+// MarshalJSON implements json.Marshaler. It marshals the IBFT as is,
+// with the addition of a schemaVersion.
+func (ibft IBFT) MarshalJSON() ([]byte, error) {
+	type plain IBFT
+	b, err := json.Marshal(&struct {
+		SchemaVersion int `json:"schemaVersion"`
+		plain
+	}{
+		SchemaVersion: IBFTSchemaVersion,
+		plain:         plain(ibft),
+	})
+	if err != nil || !IBFTOmitUnset {
+		return b, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	omitUnset(m)
+	return json.Marshal(m)
+}
+
+// omitUnset removes the members of m, and of the objects in it, which
+// are "" or 0, and the objects which are then empty.
+func omitUnset(m map[string]interface{}) {
+	for k, v := range m {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			omitUnset(v)
+			if len(v) == 0 {
+				delete(m, k)
+			}
+		case string:
+			if v == "" {
+				delete(m, k)
+			}
+		case float64:
+			if v == 0 {
+				delete(m, k)
+			}
+		}
+	}
+}
+
+// Merge sets each field of the IBFT which is set in o, i.e. is not an
+// empty string or zero, to its value in o. Fields which are not set in
+// o are left alone. It is for overriding a base config.
+func (ibft *IBFT) Merge(o *IBFT) {
+	merge(reflect.ValueOf(ibft).Elem(), reflect.ValueOf(o).Elem())
+}
+
+// merge sets the exported fields of struct d which are set in s.
+func merge(d, s reflect.Value) {
+	for i := 0; i < d.NumField(); i++ {
+		if d.Type().Field(i).PkgPath != "" {
+			continue
+		}
+		df, sf := d.Field(i), s.Field(i)
+		switch sf.Kind() {
+		case reflect.Struct:
+			merge(df, sf)
+		case reflect.String:
+			if sf.String() != "" {
+				df.Set(sf)
+			}
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if sf.Uint() != 0 {
+				df.Set(sf)
+			}
+		}
+	}
+}
+
+// UnmarshalJSON implements json.Unmarshaler. It upgrades JSON written
+// with an older schemaVersion, and returns an error for JSON written with
+// a newer one.
+func (ibft *IBFT) UnmarshalJSON(b []byte) error {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	var v int
+	if s, ok := m["schemaVersion"]; ok {
+		if err := json.Unmarshal(s, &v); err != nil {
+			return fmt.Errorf("IBFT schemaVersion %s: %v", s, err)
+		}
+	}
+	if v < 0 || v > IBFTSchemaVersion {
+		return fmt.Errorf("IBFT schemaVersion %d is not supported: want at most %d", v, IBFTSchemaVersion)
+	}
+	for ; v < IBFTSchemaVersion; v++ {
+		if err := ibftMigrations[v](m); err != nil {
+			return fmt.Errorf("upgrading IBFT schemaVersion %d: %v", v, err)
+		}
+	}
+	delete(m, "schemaVersion")
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	type plain IBFT
+	return json.Unmarshal(b, (*plain)(ibft))
+}
+
+// rawSheap is the JSON for a heap entry which is not a string, from
+// RawSheap. The bytes are base64 encoded.
+type rawSheap struct {
+	Raw []byte `json:"raw"`
+}
+
+// MarshalJSON implements json.Marshaler. A heap entry is written as a
+// string, unless it is not UTF-8, which JSON strings can not hold; then
+// it is written as a rawSheap.
+func (s sheap) MarshalJSON() ([]byte, error) {
+	if utf8.ValidString(string(s)) {
+		return json.Marshal(string(s))
+	}
+	return json.Marshal(rawSheap{Raw: []byte(s)})
+}
+
+// UnmarshalJSON implements json.Unmarshaler. It reads a heap entry
+// written by MarshalJSON, as a string or a rawSheap.
+func (s *sheap) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '{' {
+		var r rawSheap
+		if err := json.Unmarshal(b, &r); err != nil {
+			return err
+		}
+		*s = RawSheap(r.Raw)
+		return nil
+	}
+	return json.Unmarshal(b, (*string)(s))
+}
+
+// MarshalJSON implements json.Marshaler. It marshals the NIC as is,
+// with the addition of OriginText, the Origin with its name, e.g.
+// "3 = DHCP", for people reading the JSON. It is ignored when reading.
+// If IBFTZeroUnsetIPs is set, an unset DHCP is written as zeroIP, not
+// left out.
+func (n IBFTNIC) MarshalJSON() ([]byte, error) {
+	type plain IBFTNIC
+	var t string
+	if o, err := strconv.ParseUint(string(n.Origin), 0, 8); err == nil {
+		t = IBFTOrigin(o).String()
+	}
+	if n.DHCP == "" && IBFTZeroUnsetIPs {
+		n.DHCP = zeroIP
+	}
+	return json.Marshal(&struct {
+		plain
+		OriginText string `json:",omitempty"`
+	}{
+		plain:      plain(n),
+		OriginText: t,
+	})
+}
+
+// String implements fmt.Stringer. It dumps the NIC as text, one
+// "Field: value" line per field, with the Origin given with its name as
+// in the JSON OriginText, e.g. "Origin: 3 = DHCP".
+func (n IBFTNIC) String() string {
+	var b strings.Builder
+	v := reflect.ValueOf(n)
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.Tag.Get("ibft") == "-" {
+			continue
+		}
+		s := fmt.Sprint(v.Field(i).Interface())
+		if f.Name == "Origin" {
+			if o, err := strconv.ParseUint(s, 0, 8); err == nil {
+				s = IBFTOrigin(o).String()
+			}
+		}
+		fmt.Fprintf(&b, "%s: %s\n", f.Name, s)
+	}
+	return b.String()
+}
+
+// CanonicalJSON returns the IBFT as JSON in a canonical form, e.g. for
+// keeping configs in git, where diffs should only show real changes.
+// Two IBFTs which differ only in how their values are written have the
+// same canonical JSON. The keys are sorted; unset fields are left out,
+// as with IBFTOmitUnset; and each value is written one way: IP
+// addresses as net.IP writes them, with all zeros unset, as they are
+// in the table; MACs in lower case with colons; a PCIBDF in lower case
+// hex; numbers, and LUNs, in decimal, with 0 unset, since "" marshals
+// to 0; and a target's TargetPort in its TargetIP. Values which do not
+// parse, e.g. a host name for an IP address, are left alone.
+func (ibft *IBFT) CanonicalJSON() ([]byte, error) {
+	c := ibft.Clone()
+	for _, t := range []*IBFTTarget{&c.Target0, &c.Target1} {
+		if t.TargetPort == "" {
+			continue
+		}
+		s, err := t.socket()
+		if err != nil {
+			return nil, err
+		}
+		t.TargetIP, t.TargetPort = s, ""
+	}
+	canonical(reflect.ValueOf(c).Elem())
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	omitUnset(m)
+	return json.Marshal(m)
+}
+
+// canonicalNumber returns n in format f, or, if it is 0, "", which
+// marshals to 0, i.e. is unset.
+func canonicalNumber(n uint64, f string) string {
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf(f, n)
+}
+
+// canonical sets each field of struct v, and of the structs in it, to
+// its canonical text, as described for CanonicalJSON.
+func canonical(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).PkgPath != "" {
+			continue
+		}
+		f := v.Field(i)
+		if f.Kind() == reflect.Struct {
+			canonical(f)
+			continue
+		}
+		if f.Kind() != reflect.String || f.String() == "" {
+			continue
+		}
+		s := f.String()
+		switch f.Interface().(type) {
+		case ipaddr:
+			if ip := net.ParseIP(s); ip.IsUnspecified() {
+				s = ""
+			} else if ip != nil {
+				s = ip.String()
+			}
+		case sockaddr:
+			h, p, err := net.SplitHostPort(s)
+			if ip := net.ParseIP(h); err == nil && ip != nil {
+				s = net.JoinHostPort(ip.String(), p)
+			}
+		case mac:
+			if hw, err := net.ParseMAC(s); err == nil {
+				s = hw.String()
+			}
+		case bdf:
+			if n, err := strconv.ParseUint(s, 0, 16); err == nil {
+				s = canonicalNumber(n, "%#x")
+			}
+		case u8, u16, u64:
+			if n, err := strconv.ParseUint(s, 0, 64); err == nil {
+				s = canonicalNumber(n, "%d")
+			}
+		case lun:
+			if n, err := parseLUN(lun(s)); err == nil {
+				if s = canonicalNumber(n, "%d"); s != "" {
+					s = string(formatLUN(n))
+				}
+			}
+		}
+		f.SetString(s)
+	}
+}
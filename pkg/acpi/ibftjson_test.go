@@ -0,0 +1,316 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acpi
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestIBFTJSONSchemaVersion(t *testing.T) {
+	i := &IBFT{
+		Multi: "1",
+		Initiator: IBFTInitiator{
+			Valid: "1",
+			Name:  "iqn.2019-01.org.u-root:init",
+		},
+		Target0: IBFTTarget{
+			Valid:    "1",
+			TargetIP: "1.2.3.4:3260",
+		},
+	}
+	b, err := json.Marshal(i)
+	if err != nil {
+		t.Fatalf("json.Marshal: got %v, want nil", err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatalf("json.Unmarshal to map: got %v, want nil", err)
+	}
+	if v, ok := m["schemaVersion"]; !ok || v != float64(IBFTSchemaVersion) {
+		t.Fatalf("schemaVersion: got %v, want %d", v, IBFTSchemaVersion)
+	}
+	j := &IBFT{}
+	if err := json.Unmarshal(b, j); err != nil {
+		t.Fatalf("json.Unmarshal: got %v, want nil", err)
+	}
+	if !reflect.DeepEqual(i, j) {
+		t.Fatalf("Round trip: got %v, want %v", j, i)
+	}
+}
+
+func TestIBFTJSONMigrateV0(t *testing.T) {
+	// This is what the JSON looked like before schemaVersion.
+	v0 := `{"Multi":"1","Initiator":{"Valid":"1","Name":"iqn.2019-01.org.u-root:init"},"Target0":{"Valid":"1","TargetIP":"1.2.3.4:3260"}}`
+	want := &IBFT{
+		Multi: "1",
+		Initiator: IBFTInitiator{
+			Valid: "1",
+			Name:  "iqn.2019-01.org.u-root:init",
+		},
+		Target0: IBFTTarget{
+			Valid:    "1",
+			TargetIP: "1.2.3.4:3260",
+		},
+	}
+	got := &IBFT{}
+	if err := json.Unmarshal([]byte(v0), got); err != nil {
+		t.Fatalf("json.Unmarshal of version 0: got %v, want nil", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Migrate version 0: got %v, want %v", got, want)
+	}
+	b, err := json.Marshal(got)
+	if err != nil {
+		t.Fatalf("json.Marshal: got %v, want nil", err)
+	}
+	if !strings.Contains(string(b), `"schemaVersion":1`) {
+		t.Fatalf("Migrated JSON %s: does not contain schemaVersion 1", b)
+	}
+}
+
+func TestIBFTJSONFutureVersion(t *testing.T) {
+	for _, s := range []string{`{"schemaVersion":2}`, `{"schemaVersion":-1}`, `{"schemaVersion":"one"}`} {
+		if err := json.Unmarshal([]byte(s), &IBFT{}); err == nil {
+			t.Errorf("json.Unmarshal(%s): got nil, want error", s)
+		}
+	}
+}
+
+func TestIBFTOmitUnset(t *testing.T) {
+	defer func(o bool) { IBFTOmitUnset = o }(IBFTOmitUnset)
+	IBFTOmitUnset = true
+	o := &IBFT{
+		Initiator: IBFTInitiator{Name: "iqn.2019-01.org.u-root:override"},
+		Target0:   IBFTTarget{TargetIP: "5.6.7.8:3260", CHAPSecret: "secret"},
+	}
+	b, err := json.Marshal(o)
+	if err != nil {
+		t.Fatalf("json.Marshal: got %v, want nil", err)
+	}
+	for _, s := range []string{`"Multi"`, `"Target1"`, `"NIC0"`, `"Valid"`} {
+		if strings.Contains(string(b), s) {
+			t.Errorf("JSON %s: contains %s, which is not set", b, s)
+		}
+	}
+
+	base := testIBFT()
+	want := testIBFT()
+	want.Merge(o)
+	if want.Initiator.Name != o.Initiator.Name || want.Target0.TargetIP != o.Target0.TargetIP || want.Target0.CHAPSecret != o.Target0.CHAPSecret {
+		t.Errorf("Merge: got %v, want the fields set in %v", want, o)
+	}
+	if want.Target1 != base.Target1 || want.NIC0 != base.NIC0 || want.Multi != base.Multi {
+		t.Errorf("Merge: changed fields not set in the override")
+	}
+	got := testIBFT()
+	if err := json.Unmarshal(b, got); err != nil {
+		t.Fatalf("json.Unmarshal onto base: got %v, want nil", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("json.Unmarshal onto base: got %v, want %v", got, want)
+	}
+}
+
+func TestRawSheap(t *testing.T) {
+	raw := []byte{'t', 'g', 't', 0, 0xff, 0xfe}
+	i := testIBFT()
+	i.Target0.TargetName = RawSheap(raw)
+	b, err := json.Marshal(i)
+	if err != nil {
+		t.Fatalf("json.Marshal: got %v, want nil", err)
+	}
+	if !strings.Contains(string(b), `"TargetName":{"raw":"dGd0AP/+"}`) {
+		t.Errorf("JSON %s: does not have TargetName as raw base64", b)
+	}
+	if !strings.Contains(string(b), `"TargetName":"bullseye"`) {
+		t.Errorf("JSON %s: does not have a UTF-8 TargetName as a string", b)
+	}
+	j := &IBFT{}
+	if err := json.Unmarshal(b, j); err != nil {
+		t.Fatalf("json.Unmarshal: got %v, want nil", err)
+	}
+	if !reflect.DeepEqual(i, j) {
+		t.Errorf("Round trip: got %v, want %v", j, i)
+	}
+
+	// The bytes go into the heap exactly.
+	m, err := Marshal(i)
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+	if !bytes.Contains(m, raw) {
+		t.Errorf("Marshal: %q is not in the heap", raw)
+	}
+}
+
+func TestIBFTOrigin(t *testing.T) {
+	for _, tt := range []struct {
+		o    IBFTOrigin
+		want string
+	}{
+		{OriginOther, "0 = Other"},
+		{OriginManual, "1 = Manual"},
+		{OriginWellKnown, "2 = WellKnown"},
+		{OriginDHCP, "3 = DHCP"},
+		{OriginRouterAdvertisement, "4 = RouterAdvertisement"},
+		{OriginUnchanged, "16 = Unchanged"},
+		{IBFTOrigin(5), "5 = unknown"},
+	} {
+		if got := tt.o.String(); got != tt.want {
+			t.Errorf("IBFTOrigin(%d).String(): got %q, want %q", uint8(tt.o), got, tt.want)
+		}
+		n := IBFTNIC{Valid: "1", Origin: u8(strconv.Itoa(int(tt.o)))}
+		if got, want := n.String(), "\nOrigin: "+tt.want+"\n"; !strings.Contains(got, want) {
+			t.Errorf("IBFTNIC{Origin: %d}.String(): got %q, want it to have %q", uint8(tt.o), got, want)
+		}
+	}
+	n := IBFTNIC{Origin: "x3", MACAddress: "00:0c:29:12:a4:2e"}
+	if got, want := n.String(), "\nOrigin: x3\n"; !strings.Contains(got, want) {
+		t.Errorf("IBFTNIC{Origin: x3}.String(): got %q, want it to have %q", got, want)
+	}
+	if got, want := n.String(), "\nMACAddress: 00:0c:29:12:a4:2e\n"; !strings.Contains(got, want) {
+		t.Errorf("IBFTNIC.String(): got %q, want it to have %q", got, want)
+	}
+	if got := n.String(); strings.Contains(got, "ReservedFlags") {
+		t.Errorf("IBFTNIC.String(): got %q, want no ReservedFlags", got)
+	}
+
+	i := testIBFT()
+	i.NIC0.Origin = "3"
+	b, err := json.Marshal(i)
+	if err != nil {
+		t.Fatalf("json.Marshal: got %v, want nil", err)
+	}
+	if !strings.Contains(string(b), `"OriginText":"3 = DHCP"`) {
+		t.Errorf("JSON %s: does not have OriginText for NIC0", b)
+	}
+	j := &IBFT{}
+	if err := json.Unmarshal(b, j); err != nil {
+		t.Fatalf("json.Unmarshal: got %v, want nil", err)
+	}
+	if !reflect.DeepEqual(i, j) {
+		t.Errorf("Round trip: got %v, want %v", j, i)
+	}
+}
+
+func TestIBFTZeroUnsetIPs(t *testing.T) {
+	defer func(z bool) { IBFTZeroUnsetIPs = z }(IBFTZeroUnsetIPs)
+	i := testIBFT()
+	i.NIC1.Gateway, i.NIC1.DHCP = "", ""
+	z := testIBFT()
+	z.NIC1.Gateway, z.NIC1.DHCP = zeroIP, zeroIP
+
+	// The table has 16 zero bytes either way.
+	b, err := Marshal(i)
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+	zb, err := Marshal(z)
+	if err != nil {
+		t.Fatalf("Marshal with %q: got %v, want nil", zeroIP, err)
+	}
+	if !bytes.Equal(b, zb) {
+		t.Errorf("Marshal: unset IPs and %q IPs differ:\n%#x\n%#x", zeroIP, b, zb)
+	}
+
+	for _, tt := range []struct {
+		zero          bool
+		gateway, dhcp interface{}
+	}{
+		{false, "", nil},
+		{true, string(zeroIP), string(zeroIP)},
+	} {
+		IBFTZeroUnsetIPs = tt.zero
+		j, err := json.Marshal(i)
+		if err != nil {
+			t.Fatalf("IBFTZeroUnsetIPs %v: json.Marshal: got %v, want nil", tt.zero, err)
+		}
+		var m struct{ NIC1 map[string]interface{} }
+		if err := json.Unmarshal(j, &m); err != nil {
+			t.Fatalf("IBFTZeroUnsetIPs %v: json.Unmarshal: got %v, want nil", tt.zero, err)
+		}
+		if g := m.NIC1["Gateway"]; g != tt.gateway {
+			t.Errorf("IBFTZeroUnsetIPs %v: Gateway: got %v, want %v", tt.zero, g, tt.gateway)
+		}
+		if d := m.NIC1["DHCP"]; d != tt.dhcp {
+			t.Errorf("IBFTZeroUnsetIPs %v: DHCP: got %v, want %v", tt.zero, d, tt.dhcp)
+		}
+
+		// Either way, the JSON makes the same table.
+		r := &IBFT{}
+		if err := json.Unmarshal(j, r); err != nil {
+			t.Fatalf("IBFTZeroUnsetIPs %v: json.Unmarshal IBFT: got %v, want nil", tt.zero, err)
+		}
+		rb, err := Marshal(r)
+		if err != nil {
+			t.Fatalf("IBFTZeroUnsetIPs %v: Marshal: got %v, want nil", tt.zero, err)
+		}
+		if !bytes.Equal(rb, b) {
+			t.Errorf("IBFTZeroUnsetIPs %v: round trip: got %#x, want %#x", tt.zero, rb, b)
+		}
+	}
+}
+
+func TestIBFTCanonicalJSON(t *testing.T) {
+	a := testIBFT()
+	// b is a written differently.
+	b := testIBFT()
+	b.Initiator.SNSServer = "::ffff:1.2.3.4"
+	b.NIC0.IPAddress = "::ffff:5.5.5.5"
+	b.NIC0.VLAN = "0x0a"
+	b.NIC0.MACAddress = "00-0C-29-12-A4-2E"
+	b.NIC0.PCIBDF = "24"
+	b.NIC0.SubNet = "0"
+	b.NIC1.PCIBDF = "0X08"
+	b.NIC1.Origin = "00"
+	b.Target0.TargetIP, b.Target0.TargetPort = "1.2.3.4", "88"
+	b.Target0.BootLUN = "0x4d2"
+	b.Target1.BootLUN = "lun:5c11000000000000"
+	b.Target1.Association = "0"
+	b.NIC1.SecondaryDNS, a.NIC1.SecondaryDNS = "0.0.0.0", ""
+
+	ca, err := a.CanonicalJSON()
+	if err != nil {
+		t.Fatalf("CanonicalJSON: got %v, want nil", err)
+	}
+	cb, err := b.CanonicalJSON()
+	if err != nil {
+		t.Fatalf("CanonicalJSON: got %v, want nil", err)
+	}
+	if !bytes.Equal(ca, cb) {
+		t.Errorf("CanonicalJSON of the same IBFT written two ways: got\n%s\nand\n%s\nwant them the same", ca, cb)
+	}
+	for _, s := range []string{`"MACAddress":"00:0c:29:12:a4:2e"`, `"PCIBDF":"0x18"`, `"TargetIP":"1.2.3.4:88"`, `"BootLUN":"4444"`} {
+		if !strings.Contains(string(ca), s) {
+			t.Errorf("CanonicalJSON: got %s, want it to contain %s", ca, s)
+		}
+	}
+	for _, s := range []string{`"SecondaryDNS":""`, `"SubNet"`, `"TargetPort"`, " ", "\n"} {
+		if strings.Contains(string(ca), s) {
+			t.Errorf("CanonicalJSON: got %s, want it not to contain %q", ca, s)
+		}
+	}
+	// The canonical JSON is a canonical form of itself.
+	r := &IBFT{}
+	if err := json.Unmarshal(ca, r); err != nil {
+		t.Fatalf("Unmarshal: got %v, want nil", err)
+	}
+	cr, err := r.CanonicalJSON()
+	if err != nil {
+		t.Fatalf("CanonicalJSON: got %v, want nil", err)
+	}
+	if !bytes.Equal(cr, ca) {
+		t.Errorf("CanonicalJSON of canonical JSON: got %s, want %s", cr, ca)
+	}
+	// It does not change the IBFT.
+	if b.NIC0.MACAddress != "00-0C-29-12-A4-2E" || b.Target0.TargetPort != "88" {
+		t.Errorf("CanonicalJSON changed the IBFT: %v", b)
+	}
+}
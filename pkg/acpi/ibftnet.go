@@ -0,0 +1,64 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acpi
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// SubNetFromMask returns the prefix length of a mask, as IBFTNIC.SubNet
+// wants it. It returns an error for masks which are not contiguous,
+// e.g. 255.0.255.0, since they have no prefix length, and for masks
+// which are not IPv4 or IPv6 length.
+func SubNetFromMask(m net.IPMask) (u8, error) {
+	if len(m) != net.IPv4len && len(m) != net.IPv6len {
+		return "", fmt.Errorf("mask %v is %d bytes, want %d or %d", m, len(m), net.IPv4len, net.IPv6len)
+	}
+	ones, bits := m.Size()
+	if bits == 0 {
+		return "", fmt.Errorf("mask %v is not a contiguous mask", m)
+	}
+	return u8(strconv.Itoa(ones)), nil
+}
+
+// NICFromInterface returns an IBFTNIC for a network interface, with
+// the MAC from the interface, the IPAddress and SubNet from addr, and
+// the Gateway from gw. The NIC is marked Valid, and Global unless addr
+// is link local. Everything else is left for the caller to fill in.
+// A mask which is not contiguous results in a SubNet of 0; use
+// SubNetFromMask first if that is a possibility.
+func NICFromInterface(iface net.Interface, addr net.IPNet, gw net.IP) IBFTNIC {
+	ones, _ := addr.Mask.Size()
+	n := IBFTNIC{
+		Valid:      "1",
+		Boot:       "0",
+		Global:     boolFlag(!addr.IP.IsLinkLocalUnicast()),
+		Index:      "0",
+		IPAddress:  ipaddr(addr.IP.String()),
+		SubNet:     u8(strconv.Itoa(ones)),
+		MACAddress: mac(iface.HardwareAddr.String()),
+	}
+	if gw != nil {
+		n.Gateway = ipaddr(gw.String())
+	}
+	return n
+}
+
+// SetCIDR sets the IPAddress and SubNet of the NIC from an address
+// in CIDR form, e.g. 192.168.1.10/24 or fd00::10/64. The address is
+// kept as is, not masked to the network. The prefix must be no longer
+// than the address, i.e. 32 for IPv4 and 128 for IPv6. On error, the
+// NIC is not changed.
+func (n *IBFTNIC) SetCIDR(s string) error {
+	ip, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		return fmt.Errorf("NIC address %q: %v", s, err)
+	}
+	ones, _ := ipnet.Mask.Size()
+	n.IPAddress, n.SubNet = ipaddr(ip.String()), u8(strconv.Itoa(ones))
+	return nil
+}
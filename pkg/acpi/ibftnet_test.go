@@ -0,0 +1,109 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acpi
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestNICFromInterface(t *testing.T) {
+	hw, err := net.ParseMAC("00:0c:29:12:a4:2e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		n    string
+		addr net.IPNet
+		gw   net.IP
+		want IBFTNIC
+	}{
+		{
+			n:    "IPv4",
+			addr: net.IPNet{IP: net.IPv4(192, 168, 1, 10), Mask: net.CIDRMask(24, 32)},
+			gw:   net.IPv4(192, 168, 1, 1),
+			want: IBFTNIC{Valid: "1", Boot: "0", Global: "1", Index: "0", IPAddress: "192.168.1.10", SubNet: "24", Gateway: "192.168.1.1", MACAddress: "00:0c:29:12:a4:2e"},
+		},
+		{
+			n:    "IPv6 link local, no gateway",
+			addr: net.IPNet{IP: net.ParseIP("fe80::20c:29ff:fe12:a42e"), Mask: net.CIDRMask(64, 128)},
+			want: IBFTNIC{Valid: "1", Boot: "0", Global: "0", Index: "0", IPAddress: "fe80::20c:29ff:fe12:a42e", SubNet: "64", MACAddress: "00:0c:29:12:a4:2e"},
+		},
+	}
+	for _, tt := range tests {
+		got := NICFromInterface(net.Interface{Name: "eth0", HardwareAddr: hw}, tt.addr, tt.gw)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.n, got, tt.want)
+		}
+		i := testIBFT()
+		i.NIC0 = got
+		if _, err := Marshal(i); err != nil {
+			t.Errorf("%s: Marshal: got %v, want nil", tt.n, err)
+		}
+	}
+}
+
+func TestSubNetFromMask(t *testing.T) {
+	var tests = []struct {
+		m    net.IPMask
+		want u8
+		ok   bool
+	}{
+		{net.CIDRMask(24, 32), "24", true},
+		{net.CIDRMask(0, 32), "0", true},
+		{net.CIDRMask(32, 32), "32", true},
+		{net.IPv4Mask(255, 255, 252, 0), "22", true},
+		{net.CIDRMask(64, 128), "64", true},
+		{net.IPv4Mask(255, 0, 255, 0), "", false},
+		{net.IPMask{}, "", false},
+		{net.IPMask{0xff, 0xff, 0xff}, "", false},
+	}
+	for _, tt := range tests {
+		got, err := SubNetFromMask(tt.m)
+		if (err == nil) != tt.ok {
+			t.Errorf("SubNetFromMask(%v): got err %v, want ok %v", tt.m, err, tt.ok)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("SubNetFromMask(%v): got %q, want %q", tt.m, got, tt.want)
+		}
+	}
+}
+
+func TestNICSetCIDR(t *testing.T) {
+	var tests = []struct {
+		s      string
+		ip     ipaddr
+		subnet u8
+		ok     bool
+	}{
+		{"192.168.1.10/24", "192.168.1.10", "24", true},
+		{"10.0.0.1/32", "10.0.0.1", "32", true},
+		{"0.0.0.0/0", "0.0.0.0", "0", true},
+		{"fd00::10/64", "fd00::10", "64", true},
+		{"FE80::1/128", "fe80::1", "128", true},
+		{"192.168.1.10/33", "", "", false},
+		{"fd00::10/129", "", "", false},
+		{"192.168.1.10", "", "", false},
+		{"192.168.1.10/", "", "", false},
+		{"192.168.1/24", "", "", false},
+		{"", "", "", false},
+	}
+	for _, tt := range tests {
+		n := IBFTNIC{IPAddress: "1.2.3.4", SubNet: "8"}
+		err := n.SetCIDR(tt.s)
+		if (err == nil) != tt.ok {
+			t.Errorf("SetCIDR(%q): got err %v, want ok %v", tt.s, err, tt.ok)
+			continue
+		}
+		if !tt.ok {
+			tt.ip, tt.subnet = "1.2.3.4", "8"
+		}
+		if n.IPAddress != tt.ip || n.SubNet != tt.subnet {
+			t.Errorf("SetCIDR(%q): got %q/%q, want %q/%q", tt.s, n.IPAddress, n.SubNet, tt.ip, tt.subnet)
+		}
+	}
+}
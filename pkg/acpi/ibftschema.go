@@ -0,0 +1,300 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acpi
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// schemaIPv4 and schemaIPv6 are the patterns for IP addresses in
+// IBFTJSONSchema, as JSON strings, i.e. with backslashes doubled. They
+// check the form of an address, not, e.g., the number of IPv6 groups.
+// Marshal resolves host names too, but the schema only takes addresses.
+const (
+	schemaIPv4 = `(25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])(\\.(25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])){3}`
+	schemaIPv6 = `([0-9a-fA-F]{0,4}(:[0-9a-fA-F]{0,4}){2,7}|[0-9a-fA-F]{0,4}(:[0-9a-fA-F]{0,4}){1,6}:` + schemaIPv4 + `)`
+)
+
+// IBFTJSONSchema is a JSON Schema, draft-07, for the JSON of an IBFT,
+// as MarshalJSON writes it, for config editors and tooling. It checks
+// the form of each field, e.g. that a MACAddress is a MAC, but not how
+// the fields fit together, or numbers' ranges; that is what Validate
+// is for. Fields which are not set can be left out, as IBFTOmitUnset
+// does, or be "". The schemaVersion maximum is IBFTSchemaVersion.
+// ValidateJSON checks JSON against it, without any other tools.
+var IBFTJSONSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "title": "IBFT",
+  "type": "object",
+  "additionalProperties": false,
+  "properties": {
+    "schemaVersion": {"type": "integer", "minimum": 0, "maximum": ` + strconv.Itoa(IBFTSchemaVersion) + `},
+    "Sig": {"type": "string"},
+    "Length": {"type": "integer", "minimum": 0},
+    "Revision": {"type": "integer", "minimum": 0, "maximum": 255},
+    "CheckSum": {"type": "integer", "minimum": 0, "maximum": 255},
+    "OEMID": {"type": "string"},
+    "OEMTableID": {"type": "string"},
+    "OEMRevision": {"type": "integer", "minimum": 0},
+    "CreatorID": {"type": "integer", "minimum": 0},
+    "CreatorRevision": {"type": "integer", "minimum": 0},
+    "Multi": {"$ref": "#/definitions/flag"},
+    "Initiator": {"$ref": "#/definitions/initiator"},
+    "NIC0": {"$ref": "#/definitions/nic"},
+    "Target0": {"$ref": "#/definitions/target"},
+    "NIC1": {"$ref": "#/definitions/nic"},
+    "Target1": {"$ref": "#/definitions/target"}
+  },
+  "definitions": {
+    "flag": {"description": "0 or 1", "type": "string", "enum": ["", "0", "1"]},
+    "number": {"description": "a number, e.g. 3 or 0x3", "type": "string", "pattern": "^([0-9]+|0[xX][0-9a-fA-F]+|0[bB][01]+|0[oO][0-7]+)?$"},
+    "lun": {"description": "a number, or 16 hex digits", "type": "string", "pattern": "^([0-9]+|0[xX][0-9a-fA-F]+|(lun:)?[0-9a-fA-F]{16})?$"},
+    "ipaddr": {"description": "an IP address, e.g. 10.0.0.1 or fe80::1", "type": "string", "pattern": "^(` + schemaIPv4 + `|` + schemaIPv6 + `)?$"},
+    "sockaddr": {"description": "an IP address and port, e.g. 10.0.0.1:3260 or [fe80::1]:3260", "type": "string", "pattern": "^(` + schemaIPv4 + `|` + schemaIPv6 + `|(` + schemaIPv4 + `|\\[` + schemaIPv6 + `\\]):[0-9]{1,5})?$"},
+    "mac": {"description": "a MAC address, e.g. 00:0c:29:12:a4:2e", "type": "string", "pattern": "^(([0-9a-fA-F]{2}[:-]){5}[0-9a-fA-F]{2}|([0-9a-fA-F]{4}\\.){2}[0-9a-fA-F]{4})?$"},
+    "sheap": {
+      "description": "a string, or an object with the base64 of the raw bytes in raw",
+      "anyOf": [
+        {"type": "string"},
+        {"type": "object", "additionalProperties": false, "properties": {"raw": {"type": "string"}}}
+      ]
+    },
+    "initiator": {
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "Valid": {"$ref": "#/definitions/flag"},
+        "Boot": {"$ref": "#/definitions/flag"},
+        "SNSServer": {"$ref": "#/definitions/ipaddr"},
+        "SLPServer": {"$ref": "#/definitions/ipaddr"},
+        "PrimaryRadiusServer": {"$ref": "#/definitions/ipaddr"},
+        "SecondaryRadiusServer": {"$ref": "#/definitions/ipaddr"},
+        "Name": {"$ref": "#/definitions/sheap"}
+      }
+    },
+    "nic": {
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "Valid": {"$ref": "#/definitions/flag"},
+        "Boot": {"$ref": "#/definitions/flag"},
+        "Global": {"$ref": "#/definitions/flag"},
+        "Index": {"$ref": "#/definitions/flag"},
+        "IPAddress": {"$ref": "#/definitions/ipaddr"},
+        "SubNet": {"$ref": "#/definitions/number"},
+        "Origin": {"$ref": "#/definitions/number"},
+        "OriginText": {"type": "string"},
+        "Gateway": {"$ref": "#/definitions/ipaddr"},
+        "PrimaryDNS": {"$ref": "#/definitions/ipaddr"},
+        "SecondaryDNS": {"$ref": "#/definitions/ipaddr"},
+        "DHCP": {"$ref": "#/definitions/ipaddr"},
+        "VLAN": {"$ref": "#/definitions/number"},
+        "MACAddress": {"$ref": "#/definitions/mac"},
+        "PCIBDF": {"$ref": "#/definitions/number"},
+        "HostName": {"$ref": "#/definitions/sheap"}
+      }
+    },
+    "target": {
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "Valid": {"$ref": "#/definitions/flag"},
+        "Boot": {"$ref": "#/definitions/flag"},
+        "CHAP": {"$ref": "#/definitions/flag"},
+        "RCHAP": {"$ref": "#/definitions/flag"},
+        "Index": {"$ref": "#/definitions/flag"},
+        "TargetIP": {"$ref": "#/definitions/sockaddr"},
+        "TargetPort": {"$ref": "#/definitions/number"},
+        "BootLUN": {"$ref": "#/definitions/lun"},
+        "ChapType": {"$ref": "#/definitions/number"},
+        "Association": {"$ref": "#/definitions/number"},
+        "TargetName": {"$ref": "#/definitions/sheap"},
+        "CHAPName": {"$ref": "#/definitions/sheap"},
+        "CHAPSecret": {"$ref": "#/definitions/sheap"},
+        "ReverseCHAPName": {"$ref": "#/definitions/sheap"},
+        "ReverseCHAPSecret": {"$ref": "#/definitions/sheap"}
+      }
+    }
+  }
+}
+`
+
+// jsonSchema is the part of JSON Schema IBFTJSONSchema uses.
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref"`
+	Description          string                 `json:"description"`
+	Type                 string                 `json:"type"`
+	Enum                 []interface{}          `json:"enum"`
+	Pattern              string                 `json:"pattern"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	AnyOf                []*jsonSchema          `json:"anyOf"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+	Definitions          map[string]*jsonSchema `json:"definitions"`
+}
+
+// JSONSchemaError is a problem ValidateJSON found with a value: where
+// it is, e.g. NIC0.MACAddress, or IBFT for the whole config, and what
+// is wrong with it.
+type JSONSchemaError struct {
+	Path    string
+	Problem string
+}
+
+func (e *JSONSchemaError) Error() string {
+	return e.Path + ": " + e.Problem
+}
+
+// ValidateJSON checks the JSON for an IBFT against IBFTJSONSchema,
+// before it is unmarshaled, for errors which say which field is wrong.
+// It returns the first problem, a *JSONSchemaError, or an error if b
+// is not JSON. It returns nil if there are no problems.
+func ValidateJSON(b []byte) error {
+	errs := ValidateJSONAll(b)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs[0]
+}
+
+// ValidateJSONAll checks the JSON for an IBFT as ValidateJSON does,
+// and returns all the problems it finds, with the fields of each
+// object in alphabetical order.
+func ValidateJSONAll(b []byte) []error {
+	var root jsonSchema
+	if err := json.Unmarshal([]byte(IBFTJSONSchema), &root); err != nil {
+		return []error{fmt.Errorf("IBFTJSONSchema: %v", err)}
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return []error{err}
+	}
+	var errs []error
+	root.check(&root, "IBFT", v, &errs)
+	return errs
+}
+
+// jsonType returns the JSON Schema type of v, as encoding/json
+// unmarshals it into an interface{}.
+func jsonType(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
+
+// check checks v, at path, against s, a schema in root, and adds the
+// problems it finds to errs.
+func (s *jsonSchema) check(root *jsonSchema, path string, v interface{}, errs *[]error) {
+	problem := func(f string, args ...interface{}) {
+		*errs = append(*errs, &JSONSchemaError{Path: path, Problem: fmt.Sprintf(f, args...)})
+	}
+	if s.Ref != "" {
+		d, ok := root.Definitions[strings.TrimPrefix(s.Ref, "#/definitions/")]
+		if !ok {
+			problem("the schema has no %s", s.Ref)
+			return
+		}
+		s = d
+	}
+	// want is what v should be, for messages.
+	want := s.Description
+	switch {
+	case want != "":
+	case s.Type == "":
+		want = "allowed here"
+	case strings.IndexByte("aeiou", s.Type[0]) >= 0:
+		want = "an " + s.Type
+	default:
+		want = "a " + s.Type
+	}
+	if len(s.AnyOf) > 0 {
+		for _, a := range s.AnyOf {
+			var e []error
+			if a.check(root, path, v, &e); len(e) == 0 {
+				return
+			}
+		}
+		problem("%s is not %s", jsonText(v), want)
+		return
+	}
+	if t := jsonType(v); s.Type != "" && t != s.Type && !(s.Type == "number" && t == "integer") {
+		problem("%s is not %s", jsonText(v), want)
+		return
+	}
+	if len(s.Enum) > 0 {
+		var ok bool
+		for _, e := range s.Enum {
+			ok = ok || e == v
+		}
+		if !ok {
+			problem("%s is not %s", jsonText(v), want)
+		}
+	}
+	if f, ok := v.(float64); ok {
+		if (s.Minimum != nil && f < *s.Minimum) || (s.Maximum != nil && f > *s.Maximum) {
+			problem("%s is out of range", jsonText(v))
+		}
+	}
+	if str, ok := v.(string); ok && s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			problem("the schema pattern %q: %v", s.Pattern, err)
+		} else if !re.MatchString(str) {
+			problem("%s is not %s", jsonText(v), want)
+		}
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return
+	}
+	// Go randomizes map order; check the members in a fixed order.
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		p := k
+		if path != "IBFT" {
+			p = path + "." + k
+		}
+		ps, ok := s.Properties[k]
+		if !ok {
+			if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				*errs = append(*errs, &JSONSchemaError{Path: p, Problem: "is not a field of " + path})
+			}
+			continue
+		}
+		ps.check(root, p, m[k], errs)
+	}
+}
+
+// jsonText returns v as JSON, for messages.
+func jsonText(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
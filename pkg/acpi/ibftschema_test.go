@@ -0,0 +1,101 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acpi
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestIBFTJSONSchema(t *testing.T) {
+	var s jsonSchema
+	if err := json.Unmarshal([]byte(IBFTJSONSchema), &s); err != nil {
+		t.Fatalf("IBFTJSONSchema: got %v, want nil", err)
+	}
+	if m := s.Properties["schemaVersion"].Maximum; m == nil || int(*m) != IBFTSchemaVersion {
+		t.Errorf("IBFTJSONSchema schemaVersion maximum: got %v, want %d", m, IBFTSchemaVersion)
+	}
+}
+
+func TestValidateJSON(t *testing.T) {
+	defer func(o bool) { IBFTOmitUnset = o }(IBFTOmitUnset)
+	for _, omit := range []bool{false, true} {
+		IBFTOmitUnset = omit
+		i := testIBFT()
+		i.Target1.CHAPSecret = RawSheap([]byte{0xff, 0xfe})
+		// Marshal resolves the SLPServer, localhost, but the schema
+		// only takes IP addresses.
+		i.Initiator.SLPServer = "127.0.0.1"
+		b, err := json.Marshal(i)
+		if err != nil {
+			t.Fatalf("json.Marshal: got %v, want nil", err)
+		}
+		if errs := ValidateJSONAll(b); len(errs) != 0 {
+			t.Errorf("ValidateJSONAll, IBFTOmitUnset %v: got %v, want none", omit, errs)
+		}
+	}
+
+	// One NIC and target: the other fields are "", and are written.
+	sparse := &IBFT{
+		Initiator: IBFTInitiator{Valid: "1", Name: "iqn.2009-06.com.example:initiator"},
+		NIC0:      IBFTNIC{Valid: "1", IPAddress: "10.0.0.2", SubNet: "24", MACAddress: "00:0c:29:12:a4:2e"},
+		Target0:   IBFTTarget{Valid: "1", TargetIP: "10.0.0.1:3260", TargetName: "iqn.2009-06.com.example:target"},
+	}
+	b, err := json.Marshal(sparse)
+	if err != nil {
+		t.Fatalf("json.Marshal: got %v, want nil", err)
+	}
+	if errs := ValidateJSONAll(b); len(errs) != 0 {
+		t.Errorf("ValidateJSONAll of a one NIC IBFT: got %v, want none", errs)
+	}
+
+	var tests = []struct {
+		n    string
+		j    string
+		want []string
+	}{
+		{"Empty", `{}`, nil},
+		{"Partial", `{"NIC0": {"IPAddress": "10.0.0.2", "SubNet": "24"}}`, nil},
+		{"Bad MAC", `{"NIC0": {"MACAddress": "00:0c:29:12:a4"}}`,
+			[]string{`NIC0.MACAddress: "00:0c:29:12:a4" is not a MAC address, e.g. 00:0c:29:12:a4:2e`}},
+		{"Bad flag", `{"Target1": {"Valid": "yes", "Boot": 1}}`,
+			[]string{`Target1.Boot: 1 is not 0 or 1`, `Target1.Valid: "yes" is not 0 or 1`}},
+		{"Bad number", `{"NIC1": {"VLAN": "12a"}, "Target0": {"BootLUN": "lun1"}}`,
+			[]string{`NIC1.VLAN: "12a" is not a number, e.g. 3 or 0x3`, `Target0.BootLUN: "lun1" is not a number, or 16 hex digits`}},
+		{"Bad IP", `{"NIC0": {"IPAddress": "banana", "Gateway": "10.0.0.256", "DHCP": "fe80::1"}, "Target0": {"TargetIP": "banana:3260"}, "Target1": {"TargetIP": "[::ffff:10.0.0.1]:3260"}}`,
+			[]string{`NIC0.Gateway: "10.0.0.256" is not an IP address, e.g. 10.0.0.1 or fe80::1`, `NIC0.IPAddress: "banana" is not an IP address, e.g. 10.0.0.1 or fe80::1`,
+				`Target0.TargetIP: "banana:3260" is not an IP address and port, e.g. 10.0.0.1:3260 or [fe80::1]:3260`}},
+		{"Unknown field", `{"NIC2": {}, "Initiator": {"Nmae": "iqn.x"}}`,
+			[]string{`Initiator.Nmae: is not a field of Initiator`, `NIC2: is not a field of IBFT`}},
+		{"Bad heap entry", `{"Initiator": {"Name": 7}, "Target0": {"CHAPSecret": {"raw": "/w==", "x": 1}}}`,
+			[]string{`Initiator.Name: 7 is not a string, or an object with the base64 of the raw bytes in raw`,
+				`Target0.CHAPSecret: {"raw":"/w==","x":1} is not a string, or an object with the base64 of the raw bytes in raw`}},
+		{"Bad schemaVersion", `{"schemaVersion": 2}`, []string{`schemaVersion: 2 is out of range`}},
+		{"Bad header", `{"Length": "574", "Revision": 1.5}`,
+			[]string{`Length: "574" is not an integer`, `Revision: 1.5 is not an integer`}},
+		{"Not an object", `[]`, []string{`IBFT: [] is not an object`}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, err := range ValidateJSONAll([]byte(tt.j)) {
+			if _, ok := err.(*JSONSchemaError); !ok {
+				t.Errorf("%s: %v: got %T, want *JSONSchemaError", tt.n, err, err)
+			}
+			got = append(got, err.Error())
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s: got %q, want %q", tt.n, got, tt.want)
+		}
+		err := ValidateJSON([]byte(tt.j))
+		if (err == nil) != (len(tt.want) == 0) || (err != nil && err.Error() != tt.want[0]) {
+			t.Errorf("ValidateJSON, %s: got %v, want %q", tt.n, err, tt.want)
+		}
+	}
+
+	if err := ValidateJSON([]byte(`{"NIC0":`)); err == nil {
+		t.Errorf("ValidateJSON of bad JSON: got nil, want error")
+	}
+}
@@ -0,0 +1,702 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acpi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// Unmarshaling an IBFT is the reverse of marshaling it: we read the
+// control structure, follow its pointers to each structure, and
+// convert the binary fields back into the JSON friendly strings.
+// Everything is found via pointers, which are offsets from the start
+// of the table, be they to structures or heap entries.
+
+var (
+	_ = Marshaler(&IBFT{})
+	_ = Unmarshaler(&IBFT{})
+)
+
+func init() {
+	addUnMarshaler("IBFT", unmarshalIBFT)
+}
+
+func unmarshalIBFT(t Tabler) (Tabler, error) {
+	ibft := &IBFT{}
+	if err := ibft.Unmarshal(t.AllData()); err != nil {
+		return nil, err
+	}
+	return ibft, nil
+}
+
+// AsIBFT returns t as an IBFT, unmarshaling it if it is not one
+// already, e.g. if it is a Raw table from RawTables.
+func AsIBFT(t Tabler) (*IBFT, error) {
+	if ibft, ok := t.(*IBFT); ok {
+		return ibft, nil
+	}
+	ibft := &IBFT{}
+	if err := ibft.Unmarshal(t.AllData()); err != nil {
+		return nil, err
+	}
+	return ibft, nil
+}
+
+// MaxTableSize is the largest IBFT we will read. The spec does not
+// set a limit, but the 16 bit heap offsets imply one, and real tables
+// are a few KiB. This keeps a corrupt Length from making us read, or
+// allocate, an absurd amount of memory.
+var MaxTableSize uint32 = 64 * 1024
+
+// IBFTReader reads a binary IBFT, e.g. one created by firmware.
+type IBFTReader struct {
+	// StrictVersion makes a structure Version other than 1 an error.
+	// By default, such structures are read anyway, with a warning:
+	// firmware occasionally bumps the Version, and failing to boot
+	// because of it would be a shame.
+	StrictVersion bool
+	// SignatureVariants allows the signatures in
+	// IBFTSignatureVariants, as well as IBFT, with a warning.
+	SignatureVariants bool
+	// HeapPastLength allows heap entries past the header Length, with
+	// a warning, as long as they are in the slice given to
+	// NewIBFTReader. Some producers set Length to the end of the
+	// structures, leaving out the heap; by default, reading such a
+	// table fails at the first heap entry.
+	HeapPastLength bool
+	// Warnings are problems found while reading the table which
+	// did not stop us from reading it.
+	Warnings []string
+
+	data []byte
+	// all is the whole slice given to NewIBFTReader, which can be
+	// longer than data.
+	all []byte
+	// heapRefs are the heap entries read so far, for checkHeap.
+	heapRefs []heapRef
+	// read is the structures read so far, so that a pointer to one
+	// of them again, or into one, is caught.
+	read []readStruct
+}
+
+// readStruct is a structure IBFTReader has read: its name, e.g. NIC0,
+// and its offset and length.
+type readStruct struct {
+	name string
+	o, l int
+}
+
+// heapRef is where a heap entry was found: the field, e.g.
+// Initiator.Name, and the offset and length in its pointer.
+type heapRef struct {
+	name string
+	o, l int
+}
+
+// IBFTSignatureVariants are signatures other than IBFT which firmware
+// has been known to use. The spec itself says iBFT; some early firmware
+// used BIFT.
+var IBFTSignatureVariants = []string{"iBFT", "BIFT"}
+
+// NewIBFTReader returns an IBFTReader for a byte slice. The slice
+// must hold at least the number of bytes in the header Length.
+// The options are applied to the IBFTReader before the header is
+// checked, e.g. to set SignatureVariants.
+func NewIBFTReader(b []byte, opt ...func(*IBFTReader)) (*IBFTReader, error) {
+	r := &IBFTReader{}
+	for _, o := range opt {
+		o(r)
+	}
+	if len(b) < int(ibftHeaderLen) {
+		return nil, fmt.Errorf("IBFT is %d bytes, must be at least %d", len(b), ibftHeaderLen)
+	}
+	if err := r.signature(string(b[:4])); err != nil {
+		return nil, err
+	}
+	l := binary.LittleEndian.Uint32(b[LengthOffset:])
+	if l > MaxTableSize {
+		return nil, fmt.Errorf("IBFT Length is %d, which is more than MaxTableSize (%d)", l, MaxTableSize)
+	}
+	if l < uint32(ibftHeaderLen) || l > uint32(len(b)) {
+		return nil, fmt.Errorf("IBFT Length is %d, must be between %d and %d", l, ibftHeaderLen, len(b))
+	}
+	r.data, r.all = b[:l], b
+	// Linux does not check the checksum, so tables with a bad one
+	// are out there, and boot. We read them, but say so.
+	if c := gencsum(r.data); c != 0 {
+		r.warn("checksum byte %#02x is off by %#02x", r.data[CSUMOffset], c)
+	}
+	return r, nil
+}
+
+// signature checks the table signature.
+func (r *IBFTReader) signature(s string) error {
+	if s == "IBFT" {
+		return nil
+	}
+	if r.SignatureVariants {
+		for _, v := range IBFTSignatureVariants {
+			if s == v {
+				r.warn("IBFT signature is %q, a known variant of %q", s, "IBFT")
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("IBFT signature is %q, want %q", s, "IBFT")
+}
+
+// warn records a warning.
+func (r *IBFTReader) warn(f string, v ...interface{}) {
+	s := fmt.Sprintf(f, v...)
+	Debug("IBFT warning: %s", s)
+	r.Warnings = append(r.Warnings, s)
+}
+
+// version checks the Version of the structure at offset o.
+func (r *IBFTReader) version(o int) error {
+	v := r.u8(o + 1)
+	if v == ibftVersion {
+		return nil
+	}
+	if r.StrictVersion {
+		return fmt.Errorf("IBFT %v structure at %#x: Version is %d, want %d", StructureID(r.u8(o)), o, v, ibftVersion)
+	}
+	r.warn("IBFT %v structure at %#x: Version is %d, want %d; reading it anyway", StructureID(r.u8(o)), o, v, ibftVersion)
+	return nil
+}
+
+// u8 returns the uint8 at offset o.
+func (r *IBFTReader) u8(o int) uint8 {
+	return r.data[o]
+}
+
+// u16 returns the uint16 at offset o.
+func (r *IBFTReader) u16(o int) uint16 {
+	return binary.LittleEndian.Uint16(r.data[o:])
+}
+
+// bytes returns n bytes at offset o, or an error if
+// they are not all in the table.
+func (r *IBFTReader) bytes(o, n int) ([]byte, error) {
+	if o < 0 || n < 0 || o+n > len(r.data) {
+		return nil, fmt.Errorf("[%d:%d] is outside the %d byte IBFT", o, o+n, len(r.data))
+	}
+	return r.data[o : o+n], nil
+}
+
+// heap returns the n byte heap entry at offset o. With HeapPastLength,
+// an entry past the end of the table is read from the rest of the
+// slice, with a warning.
+func (r *IBFTReader) heap(o, n int) ([]byte, error) {
+	b, err := r.bytes(o, n)
+	if err == nil || !r.HeapPastLength || o < 0 || n < 0 || o+n > len(r.all) {
+		return b, err
+	}
+	r.warn("heap entry [%d:%d] is past the %d byte Length; reading it anyway", o, o+n, len(r.data))
+	return r.all[o : o+n], nil
+}
+
+// bit returns the flag for bit n of f.
+func bit(f uint8, n uint) flag {
+	if f&(1<<n) != 0 {
+		return "1"
+	}
+	return "0"
+}
+
+// IBFT unmarshals the table into an IBFT.
+func (r *IBFTReader) IBFT() (*IBFT, error) {
+	raw, err := NewRaw(r.data)
+	if err != nil {
+		return nil, err
+	}
+	ibft := &IBFT{Generic: Generic{Header: *GetHeader(raw), data: r.data}}
+	copy(ibft.Reserved[:], r.data[24:ibftHeaderLen])
+
+	c := int(ibftHeaderLen)
+	if _, err := r.bytes(c, int(ibftControlLen)); err != nil {
+		return nil, fmt.Errorf("IBFT control structure: %v", err)
+	}
+	if id := StructureID(r.u8(c)); id != ibftControl {
+		return nil, fmt.Errorf("IBFT control structure ID is %d (%v), want %d (%v)", id, id, ibftControl, ibftControl)
+	}
+	if err := r.version(c); err != nil {
+		return nil, err
+	}
+	r.read, r.heapRefs = []readStruct{{"Control", c, r.controlEnd() - c}}, nil
+	ibft.Multi = bit(r.u8(c+5), 0)
+
+	f, _, err := r.structure(ibftInitiator, 0, &ibft.Initiator)
+	if err != nil {
+		return nil, err
+	}
+	ibft.Initiator.Valid, ibft.Initiator.Boot = bit(f, 0), bit(f, 1)
+	ibft.Initiator.ReservedFlags = f &^ 0x03
+
+	for x, nic := range []*IBFTNIC{&ibft.NIC0, &ibft.NIC1} {
+		f, i, err := r.structure(ibftNIC, x, nic)
+		if err != nil {
+			return nil, err
+		}
+		nic.Valid, nic.Boot, nic.Global = bit(f, 0), bit(f, 1), bit(f, 2)
+		nic.ReservedFlags = f &^ 0x07
+		nic.Index = flag(strconv.Itoa(int(i)))
+		// Marshal sets Index from the slot, so a mismatch
+		// is a firmware bug, not something we did.
+		if f != 0 && int(i) != x {
+			r.warn("NIC%d: Index is %d, want %d", x, i, x)
+		}
+	}
+
+	for x, tgt := range []*IBFTTarget{&ibft.Target0, &ibft.Target1} {
+		f, i, err := r.structure(ibftTarget, x, tgt)
+		if err != nil {
+			return nil, err
+		}
+		tgt.Valid, tgt.Boot, tgt.CHAP, tgt.RCHAP = bit(f, 0), bit(f, 1), bit(f, 2), bit(f, 3)
+		tgt.ReservedFlags = f &^ 0x0f
+		tgt.Index = flag(strconv.Itoa(int(i)))
+	}
+	r.checkHeap()
+	return ibft, nil
+}
+
+// checkHeap checks the length of each heap entry against where it
+// seems to end, and warns if they disagree, which means the length, or
+// the heap, is corrupt. An entry ends at or before the next one, with
+// nothing but NULs between them; the last must end in the table, but
+// what follows it is not checked, as firmware sometimes leaves entries
+// no structure points to there. A name also ends at its first NUL;
+// secrets can contain NULs, so they do not.
+func (r *IBFTReader) checkHeap() {
+	starts := []int{len(r.data)}
+	for _, h := range r.heapRefs {
+		starts = append(starts, h.o)
+	}
+	sort.Ints(starts)
+	for _, h := range r.heapRefs {
+		end := h.o + h.l
+		if end > len(r.data) {
+			continue
+		}
+		if heapKind(h.name) == HeapName {
+			if n := bytes.IndexByte(r.data[h.o:end], 0); n >= 0 {
+				r.warn("%s: Length is %d, but it has a NUL at %d", h.name, h.l, n)
+				continue
+			}
+		}
+		next := starts[sort.SearchInts(starts, h.o+1)]
+		switch {
+		case end > next:
+			r.warn("%s: Length is %d, which runs %d bytes into the heap entry at %#x", h.name, h.l, end-next, next)
+		case next < len(r.data) && len(bytes.Trim(r.data[end:next], "\x00")) != 0:
+			r.warn("%s: Length is %d, but it is followed by %q, before the heap entry at %#x", h.name, h.l, r.data[end:next], next)
+		}
+	}
+}
+
+// pointer returns the offset of a structure, given its ID and index,
+// and its fixed length. The offset is 0 if the structure is not present.
+// We only support the structures the control structure has pointers for.
+func (r *IBFTReader) pointer(id StructureID, index int) (int, uint16, error) {
+	c := int(ibftHeaderLen)
+	if _, err := r.bytes(c, int(ibftControlLen)); err != nil {
+		return 0, 0, fmt.Errorf("IBFT control structure: %v", err)
+	}
+	switch {
+	case id == ibftControl && index == 0:
+		return c, ibftControlLen, nil
+	case id == ibftInitiator && index == 0:
+		return r.slot(c + 8), ibftInitiatorLen, nil
+	case id == ibftNIC && index == 0:
+		return r.slot(c + 10), ibftNICLen, nil
+	case id == ibftTarget && index == 0:
+		return r.slot(c + 12), ibftTargetLen, nil
+	case id == ibftNIC && index == 1:
+		return r.slot(c + 14), ibftNICLen, nil
+	case id == ibftTarget && index == 1:
+		return r.slot(c + 16), ibftTargetLen, nil
+	}
+	return 0, 0, fmt.Errorf("IBFT has no structure %d (%v) with index %d", id, id, index)
+}
+
+// slot returns the pointer at offset s in the control structure, or 0
+// if s is past the end of it. A control structure from MarshalStrict,
+// or firmware which follows the spec to the letter, only has pointers
+// up to the last structure present. We do not trust its Length (see
+// length), but no structure can start inside the control structure,
+// so it ends at the first structure.
+func (r *IBFTReader) slot(s int) int {
+	end := len(r.data)
+	for p := int(ibftHeaderLen) + 8; p < s && p+2 <= end; p += 2 {
+		if x := int(r.u16(p)); x != 0 && x < end {
+			end = x
+		}
+	}
+	if s+2 > end {
+		return 0
+	}
+	return int(r.u16(s))
+}
+
+// controlEnd returns where the control structure ends: at the first
+// structure, as for slot, or after the last pointer in the spec.
+func (r *IBFTReader) controlEnd() int {
+	c := int(ibftHeaderLen)
+	end := c + int(ibftControlLen)
+	for p := c + 8; p < end; p += 2 {
+		if x := int(r.u16(p)); x >= c+8 && x < end {
+			end = x
+		}
+	}
+	return end
+}
+
+// SecretRange is the location of a secret in a table.
+type SecretRange struct {
+	Offset, Len int
+}
+
+// SecretRanges returns where the CHAP and reverse CHAP secrets of each
+// target are in the table, so that they can be wiped in place, without
+// marshaling a new table. Empty secrets, and heap entries which are not
+// in the table, are left out.
+func (r *IBFTReader) SecretRanges() []SecretRange {
+	var s []SecretRange
+	for index := 0; index < 2; index++ {
+		o, _, err := r.pointer(ibftTarget, index)
+		if err != nil || o == 0 {
+			continue
+		}
+		// CHAPSecret and ReverseCHAPSecret heap entries.
+		for _, h := range []int{o + 42, o + 50} {
+			if _, err := r.bytes(h, 4); err != nil {
+				continue
+			}
+			e := SecretRange{Offset: int(r.u16(h + 2)), Len: int(r.u16(h))}
+			if e.Len == 0 {
+				continue
+			}
+			if _, err := r.bytes(e.Offset, e.Len); err != nil {
+				Debug("IBFT target %d secret at %#x: %v", index, h, err)
+				continue
+			}
+			s = append(s, e)
+		}
+	}
+	return s
+}
+
+// ValidTargetCount returns how many of the targets are valid, from
+// the Valid flag in their structure headers, without reading anything
+// else, e.g. for a quick check at boot. A target which is not present,
+// or not in the table, is not valid.
+func (r *IBFTReader) ValidTargetCount() int {
+	var n int
+	for index := 0; index < 2; index++ {
+		o, _, err := r.pointer(ibftTarget, index)
+		if err != nil || o == 0 {
+			continue
+		}
+		if _, err := r.bytes(o, ibftStructHeaderLen); err != nil {
+			continue
+		}
+		if StructureID(r.u8(o)) == ibftTarget && r.u8(o+5)&1 != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// HeapEntry is a heap entry, and the structure field which points to it.
+type HeapEntry struct {
+	// Structure is the structure, e.g. NIC0, and Field the field,
+	// e.g. HostName, named as in IBFT.
+	Structure, Field string
+	// Offset and Len are from the field's pointer.
+	Offset, Len int
+	// Value is the entry, or as much of it as is in the table.
+	Value string
+}
+
+// ibftHeapFields are the heap pointers in each structure, by offset.
+var ibftHeapFields = map[StructureID][]struct {
+	o    int
+	name string
+}{
+	ibftInitiator: {{70, "Name"}},
+	ibftNIC:       {{98, "HostName"}},
+	ibftTarget:    {{34, "TargetName"}, {38, "CHAPName"}, {42, "CHAPSecret"}, {46, "ReverseCHAPName"}, {50, "ReverseCHAPSecret"}},
+}
+
+// HeapEntries returns the heap entries of the table, sorted by Offset,
+// for debugging heap layout. Unlike IBFT, it does not stop at a bad
+// pointer: an entry which is partly, or wholly, outside the table is
+// returned, with what there is of it in Value, so that a pointer which
+// is off by a few bytes can be spotted. Empty entries are left out.
+func (r *IBFTReader) HeapEntries() []HeapEntry {
+	var e []HeapEntry
+	for _, s := range []struct {
+		id    StructureID
+		index int
+	}{{ibftInitiator, 0}, {ibftNIC, 0}, {ibftTarget, 0}, {ibftNIC, 1}, {ibftTarget, 1}} {
+		o, l, err := r.pointer(s.id, s.index)
+		if err != nil || o == 0 {
+			continue
+		}
+		if _, err := r.bytes(o, int(l)); err != nil {
+			continue
+		}
+		for _, f := range ibftHeapFields[s.id] {
+			h := HeapEntry{Structure: structureName(s.id, s.index), Field: f.name, Offset: int(r.u16(o + f.o + 2)), Len: int(r.u16(o + f.o))}
+			if h.Len == 0 {
+				continue
+			}
+			if h.Offset < len(r.data) {
+				end := h.Offset + h.Len
+				if end > len(r.data) {
+					end = len(r.data)
+				}
+				h.Value = string(r.data[h.Offset:end])
+			}
+			e = append(e, h)
+		}
+	}
+	sort.SliceStable(e, func(i, j int) bool { return e[i].Offset < e[j].Offset })
+	return e
+}
+
+// RawStructure returns a copy of the bytes of a structure, given its ID
+// and index, e.g. ibftNIC and 0 for NIC0. The structure is found via
+// the control structure, and is its fixed length, e.g. 102 for a NIC.
+func (r *IBFTReader) RawStructure(id StructureID, index int) ([]byte, error) {
+	o, l, err := r.pointer(id, index)
+	if err != nil {
+		return nil, err
+	}
+	if o == 0 {
+		return nil, fmt.Errorf("IBFT %v structure %d is not present", id, index)
+	}
+	b, err := r.bytes(o, int(l))
+	if err != nil {
+		return nil, fmt.Errorf("IBFT %v structure %d at %#x: %v", id, index, o, err)
+	}
+	return append([]byte{}, b...), nil
+}
+
+// structure unmarshals a structure, given its ID and index, into the struct
+// pointed to by i. It returns the flags and index from the structure header.
+// A pointer of 0 means there is no structure, which is not an error.
+// A pointer to a structure which was read already, including the
+// control structure, is an error, as is one to a structure which
+// overlaps one read already: either the pointer is a duplicate, and
+// the pointers loop, or the structures share bytes. The Extensions
+// pointer is not followed, so it cannot be part of a loop.
+func (r *IBFTReader) structure(id StructureID, index int, i interface{}) (uint8, uint8, error) {
+	o, l, err := r.pointer(id, index)
+	// A zero pointer means the structure is absent, e.g. NIC1 and
+	// Target1 in an IBFT with one path. Offset 0 is the header, so
+	// it must not be read; the structure is left empty, with no
+	// flags, i.e. not valid.
+	if err != nil || o == 0 {
+		return 0, 0, err
+	}
+	name := structureName(id, index)
+	for _, p := range r.read {
+		if o == p.o {
+			return 0, 0, fmt.Errorf("IBFT %s structure at %#x: it was read as %s already; the structure pointer is a duplicate", name, o, p.name)
+		}
+		if o < p.o+p.l && p.o < o+int(l) {
+			return 0, 0, fmt.Errorf("IBFT %s structure at %#x-%#x: it overlaps %s, at %#x-%#x; the structure pointers overlap", name, o, o+int(l), p.name, p.o, p.o+p.l)
+		}
+	}
+	r.read = append(r.read, readStruct{name, o, int(l)})
+	if _, err := r.bytes(o, int(l)); err != nil {
+		return 0, 0, fmt.Errorf("IBFT %v structure at %#x: %v", id, o, err)
+	}
+	if got := StructureID(r.u8(o)); got != id {
+		return 0, 0, fmt.Errorf("IBFT structure at %#x: ID is %d (%v), want %d (%v)", o, got, got, id, id)
+	}
+	Debug("IBFT %v structure at %#x, version %d, length %d", id, o, r.u8(o+1), r.u16(o+2))
+	if err := r.version(o); err != nil {
+		return 0, 0, err
+	}
+	r.length(o, l)
+	if err := r.fields(o+ibftStructHeaderLen, name+".", i); err != nil {
+		return 0, 0, fmt.Errorf("IBFT %v structure at %#x: %v", id, o, err)
+	}
+	return r.u8(o + 5), r.u8(o + 4), nil
+}
+
+// structureName returns the name of the IBFT field for a structure,
+// e.g. NIC0, or Initiator, of which there is only one.
+func structureName(id StructureID, index int) string {
+	if id == ibftInitiator {
+		return id.String()
+	}
+	return id.String() + strconv.Itoa(index)
+}
+
+// ibftStructHeaderLen is the length of the standard structure header:
+// ID, Version, Length, Index and Flags.
+const ibftStructHeaderLen = 6
+
+// length checks the Length of the structure at offset o against l, its
+// length in the spec. The spec says Length includes the standard
+// header, but some firmware leaves the header out. We only read the
+// fields the spec defines, so either way we can carry on; but when the
+// Length matches neither convention, we check it against the space
+// before the next structure, and warn that we are guessing.
+func (r *IBFTReader) length(o int, l uint16) {
+	d, id := r.u16(o+2), StructureID(r.u8(o))
+	space := r.next(o) - o
+	switch {
+	case d == l:
+	case d+ibftStructHeaderLen == l:
+		r.warn("IBFT %v structure at %#x: Length %d does not include the %d byte header", id, o, d, ibftStructHeaderLen)
+	case int(d) == space:
+		Debug("IBFT %v structure at %#x: Length %d is longer than %d, and includes the header", id, o, d, l)
+	case int(d)+ibftStructHeaderLen == space:
+		r.warn("IBFT %v structure at %#x: Length %d is longer than %d, and does not include the %d byte header", id, o, d, l, ibftStructHeaderLen)
+	default:
+		r.warn("IBFT %v structure at %#x: Length %d is not %d, with or without the header, and there are %d bytes before the next structure; guessing it is %d", id, o, d, l, space, l)
+	}
+}
+
+// next returns the offset of the first structure after offset o, or
+// the end of the table if there is none. Structures are found via the
+// control structure pointers.
+func (r *IBFTReader) next(o int) int {
+	n := len(r.data)
+	c := int(ibftHeaderLen)
+	for p := c + 8; p <= c+16; p += 2 {
+		if x := r.slot(p); x > o && x < n {
+			n = x
+		}
+	}
+	return n
+}
+
+// fields unmarshals the fields of the struct pointed to by i,
+// starting at offset o. It is the inverse of HeapTable.Marshal.
+// prefix is the name of the struct, and a dot, for checkHeap.
+func (r *IBFTReader) fields(o int, prefix string, i interface{}) error {
+	nt := reflect.TypeOf(i).Elem()
+	nv := reflect.ValueOf(i).Elem()
+	for i := 0; i < nt.NumField(); i++ {
+		f := nt.Field(i)
+		fv := nv.Field(i)
+		if f.Tag.Get("ibft") == "-" {
+			continue
+		}
+		var s string
+		switch fv.Interface().(type) {
+		case flag:
+			continue
+		case ipaddr:
+			b, err := r.bytes(o, 16)
+			if err != nil {
+				return err
+			}
+			s = net.IP(b).String()
+			o += 16
+		case sockaddr:
+			b, err := r.bytes(o, 18)
+			if err != nil {
+				return err
+			}
+			s = net.JoinHostPort(net.IP(b[:16]).String(), strconv.Itoa(int(r.u16(o+16))))
+			o += 18
+		case mac:
+			b, err := r.bytes(o, 6)
+			if err != nil {
+				return err
+			}
+			s = net.HardwareAddr(b).String()
+			o += 6
+		case bdf:
+			s = fmt.Sprintf("%#x", r.u16(o))
+			o += 2
+		case u8:
+			s = strconv.FormatUint(uint64(r.u8(o)), 10)
+			o++
+		case u16:
+			s = strconv.FormatUint(uint64(r.u16(o)), 10)
+			o += 2
+		case u64:
+			s = strconv.FormatUint(binary.LittleEndian.Uint64(r.data[o:]), 10)
+			o += 8
+		case lun:
+			s = string(formatLUN(binary.LittleEndian.Uint64(r.data[o:])))
+			o += 8
+		case sheap:
+			l, p := r.u16(o), r.u16(o+2)
+			if l != 0 {
+				b, err := r.heap(int(p), int(l))
+				if err != nil {
+					return fmt.Errorf("%s: %v", f.Name, err)
+				}
+				s = string(b)
+				r.heapRefs = append(r.heapRefs, heapRef{name: prefix + f.Name, o: int(p), l: int(l)})
+			}
+			o += 4
+		default:
+			return fmt.Errorf("Don't know what to do with %s (%T)", f.Name, fv.Interface())
+		}
+		Debug("IBFT field %s is %q", f.Name, s)
+		fv.SetString(s)
+	}
+	return nil
+}
+
+// Unmarshal unmarshals a binary IBFT into ibft. The header,
+// including the reserved block, is kept, so that marshaling
+// ibft again produces the same table, as long as HeapNUL matches
+// whether the table's heap entries are followed by a NUL.
+func (ibft *IBFT) Unmarshal(b []byte) error {
+	r, err := NewIBFTReader(b)
+	if err != nil {
+		return err
+	}
+	i, err := r.IBFT()
+	if err != nil {
+		return err
+	}
+	*ibft = *i
+	return nil
+}
+
+// UnmarshalFrom reads an IBFT at base from r, e.g. /dev/mem, and
+// unmarshals it. It reads the header to learn the Length, then reads
+// exactly that much, so r can be far larger than the table.
+func UnmarshalFrom(r io.ReaderAt, base int64) (*IBFT, error) {
+	h := make([]byte, ibftHeaderLen)
+	if _, err := r.ReadAt(h, base); err != nil {
+		return nil, fmt.Errorf("reading IBFT header at %#x: %v", base, err)
+	}
+	l := binary.LittleEndian.Uint32(h[LengthOffset:])
+	if l > MaxTableSize {
+		return nil, fmt.Errorf("IBFT at %#x: Length is %d, which is more than MaxTableSize (%d)", base, l, MaxTableSize)
+	}
+	if l < uint32(ibftHeaderLen) {
+		return nil, fmt.Errorf("IBFT at %#x: Length is %d, must be at least %d", base, l, ibftHeaderLen)
+	}
+	b := make([]byte, l)
+	if _, err := r.ReadAt(b, base); err != nil {
+		return nil, fmt.Errorf("reading %d byte IBFT at %#x: %v", l, base, err)
+	}
+	ibft := &IBFT{}
+	if err := ibft.Unmarshal(b); err != nil {
+		return nil, err
+	}
+	return ibft, nil
+}
@@ -0,0 +1,924 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acpi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testdata/ibft.bin is a two path IBFT with OEM IDs and a reserved
+// block which differ from the ones we use by default, as a table from
+// firmware would.
+func readTestIBFT(t *testing.T) []byte {
+	b, err := ioutil.ReadFile("testdata/ibft.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestIBFTUnmarshal(t *testing.T) {
+	defer func(d func(string, ...interface{})) { Debug = d }(Debug)
+	Debug = t.Logf
+	i := &IBFT{}
+	if err := i.Unmarshal(readTestIBFT(t)); err != nil {
+		t.Fatalf("Unmarshal: got %v, want nil", err)
+	}
+	var tests = []struct {
+		n    string
+		got  string
+		want string
+	}{
+		{"OEMID", string(i.Header.OEMID), "FWVEND"},
+		{"OEMTableID", string(i.Header.OEMTableID), "HBA 2000"},
+		{"Multi", string(i.Multi), "1"},
+		{"Initiator.Name", string(i.Initiator.Name), "iqn.2009-06.com.example:initiator"},
+		{"Initiator.SNSServer", string(i.Initiator.SNSServer), "1.2.3.4"},
+		{"NIC0.MACAddress", string(i.NIC0.MACAddress), "00:0c:29:12:a4:2e"},
+		{"NIC0.PCIBDF", string(i.NIC0.PCIBDF), "0x18"},
+		{"NIC0.VLAN", string(i.NIC0.VLAN), "10"},
+		{"NIC1.Index", string(i.NIC1.Index), "1"},
+		{"NIC1.Global", string(i.NIC1.Global), "0"},
+		{"NIC1.HostName", string(i.NIC1.HostName), "otherhost"},
+		{"Target0.TargetIP", string(i.Target0.TargetIP), "1.2.3.4:88"},
+		{"Target0.BootLUN", string(i.Target0.BootLUN), "1234"},
+		{"Target0.TargetName", string(i.Target0.TargetName), "iqn.2009-06.com.example:target0"},
+		{"Target1.RCHAP", string(i.Target1.RCHAP), "1"},
+		{"Target1.ChapType", string(i.Target1.ChapType), "2"},
+		{"Target1.ReverseCHAPSecret", string(i.Target1.ReverseCHAPSecret), "arg"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.n, tt.got, tt.want)
+		}
+	}
+}
+
+func TestIBFTRoundTrip(t *testing.T) {
+	// testdata/ibft.bin, like some firmware, does not follow names
+	// with a NUL.
+	defer func(n bool) { HeapNUL[HeapName] = n }(HeapNUL[HeapName])
+	HeapNUL[HeapName] = false
+	b := readTestIBFT(t)
+	i := &IBFT{}
+	if err := i.Unmarshal(b); err != nil {
+		t.Fatalf("Unmarshal: got %v, want nil", err)
+	}
+	if !bytes.Equal(i.Reserved[:], b[24:48]) {
+		t.Fatalf("Reserved: got %q, want %q", i.Reserved, b[24:48])
+	}
+	o, err := Marshal(i)
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+	if !bytes.Equal(o, b) {
+		for n := range b {
+			if n < len(o) && o[n] != b[n] {
+				t.Logf("%d: got %#02x, want %#02x", n, o[n], b[n])
+			}
+		}
+		t.Fatalf("Round trip: got %d bytes, want the %d bytes we started with", len(o), len(b))
+	}
+}
+
+func TestIBFTUnmarshalBad(t *testing.T) {
+	b := readTestIBFT(t)
+	var tests = []struct {
+		n string
+		f func([]byte) []byte
+	}{
+		{"Short", func(b []byte) []byte { return b[:20] }},
+		{"Signature", func(b []byte) []byte { copy(b, "ABCD"); return b }},
+		{"Length", func(b []byte) []byte { b[LengthOffset+1] = 0xff; return b }},
+		{"Control ID", func(b []byte) []byte { b[ibftHeaderLen] = byte(ibftTarget); return b }},
+		{"Structure ID", func(b []byte) []byte { b[ibftHeaderLen+ibftControlLen] = byte(ibftNIC); return b }},
+		{"Heap pointer", func(b []byte) []byte { b[ibftHeaderLen+ibftControlLen+73] = 0xff; return b }},
+	}
+	for _, tt := range tests {
+		if err := (&IBFT{}).Unmarshal(tt.f(append([]byte{}, b...))); err == nil {
+			t.Errorf("%s: got nil, want error", tt.n)
+		}
+	}
+}
+
+func TestStructureID(t *testing.T) {
+	for _, tt := range []struct {
+		id   StructureID
+		want string
+	}{{ibftControl, "Control"}, {ibftNIC, "NIC"}, {ibftExtensions, "Extensions"}, {7, "unknown"}} {
+		if got := tt.id.String(); got != tt.want {
+			t.Errorf("StructureID(%d).String(): got %q, want %q", tt.id, got, tt.want)
+		}
+	}
+
+	// A vendor-specific ID where the initiator should be.
+	b := readTestIBFT(t)
+	b[ibftHeaderLen+ibftControlLen] = 7
+	want := "ID is 7 (unknown), want 2 (Initiator)"
+	if err := (&IBFT{}).Unmarshal(b); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Unmarshal with initiator ID 7: got %v, want an error containing %q", err, want)
+	}
+}
+
+// fixChecksum fixes the checksum of b, a table changed by a test,
+// so the reader does not warn about it.
+func fixChecksum(t *testing.T, b []byte) {
+	if _, _, err := FixChecksum(b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIBFTBadChecksum(t *testing.T) {
+	b := readTestIBFT(t)
+	b[CSUMOffset]++
+	r, err := NewIBFTReader(b)
+	if err != nil {
+		t.Fatalf("NewIBFTReader: got %v, want nil", err)
+	}
+	if len(r.Warnings) != 1 || !strings.Contains(r.Warnings[0], "checksum") {
+		t.Errorf("Warnings: got %q, want one about the checksum", r.Warnings)
+	}
+	old, fixed, err := FixChecksum(b)
+	if err != nil {
+		t.Fatalf("FixChecksum: got %v, want nil", err)
+	}
+	if old != fixed+1 || b[CSUMOffset] != fixed {
+		t.Errorf("FixChecksum: got old %#02x, new %#02x, want old one more than new", old, fixed)
+	}
+	if err := VerifyChecksum(b); err != nil {
+		t.Errorf("VerifyChecksum after FixChecksum: got %v, want nil", err)
+	}
+	if _, _, err := FixChecksum(b[:len(b)-1]); err == nil {
+		t.Errorf("FixChecksum of a short table: got nil, want error")
+	}
+}
+
+func TestIBFTStrictVersion(t *testing.T) {
+	b := readTestIBFT(t)
+	// Bump the version of the first target.
+	b[binary.LittleEndian.Uint16(b[ibftHeaderLen+12:])+1] = 2
+	fixChecksum(t, b)
+
+	r, err := NewIBFTReader(b)
+	if err != nil {
+		t.Fatalf("NewIBFTReader: got %v, want nil", err)
+	}
+	i, err := r.IBFT()
+	if err != nil {
+		t.Fatalf("Reading version 2 target: got %v, want nil", err)
+	}
+	if len(r.Warnings) != 1 || !strings.Contains(r.Warnings[0], "Version is 2") {
+		t.Errorf("Warnings: got %q, want one about Version 2", r.Warnings)
+	}
+	if i.Target0.TargetName != "iqn.2009-06.com.example:target0" {
+		t.Errorf("Target0.TargetName: got %q, want %q", i.Target0.TargetName, "iqn.2009-06.com.example:target0")
+	}
+
+	r, err = NewIBFTReader(b)
+	if err != nil {
+		t.Fatalf("NewIBFTReader: got %v, want nil", err)
+	}
+	r.StrictVersion = true
+	if _, err := r.IBFT(); err == nil {
+		t.Errorf("Reading version 2 target with StrictVersion: got nil, want error")
+	}
+}
+
+func TestIBFTNICIndexMismatch(t *testing.T) {
+	b := readTestIBFT(t)
+	// Give NIC1 Index 0.
+	b[binary.LittleEndian.Uint16(b[ibftHeaderLen+14:])+4] = 0
+	fixChecksum(t, b)
+
+	r, err := NewIBFTReader(b)
+	if err != nil {
+		t.Fatalf("NewIBFTReader: got %v, want nil", err)
+	}
+	i, err := r.IBFT()
+	if err != nil {
+		t.Fatalf("Reading NIC1 with Index 0: got %v, want nil", err)
+	}
+	if len(r.Warnings) != 1 || r.Warnings[0] != "NIC1: Index is 0, want 1" {
+		t.Errorf("Warnings: got %q, want one about NIC1 Index", r.Warnings)
+	}
+	if i.NIC1.Index != "0" {
+		t.Errorf("NIC1.Index: got %q, want %q", i.NIC1.Index, "0")
+	}
+}
+
+func TestIBFTLengthConventions(t *testing.T) {
+	b := readTestIBFT(t)
+	// testdata/ibft-noheader.bin is testdata/ibft.bin with
+	// structure Lengths which do not include the header.
+	nb, err := ioutil.ReadFile("testdata/ibft-noheader.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		n    string
+		b    []byte
+		want []string
+	}{
+		{"Including header", b, nil},
+		{"Excluding header", nb, []string{"does not include", "does not include", "does not include", "does not include", "does not include"}},
+		{"Neither", func() []byte {
+			b := append([]byte{}, b...)
+			binary.LittleEndian.PutUint16(b[binary.LittleEndian.Uint16(b[ibftHeaderLen+10:])+2:], 999)
+			fixChecksum(t, b)
+			return b
+		}(), []string{"guessing it is 102"}},
+	}
+	want := &IBFT{}
+	if err := want.Unmarshal(b); err != nil {
+		t.Fatalf("Unmarshal: got %v, want nil", err)
+	}
+	for _, tt := range tests {
+		r, err := NewIBFTReader(tt.b)
+		if err != nil {
+			t.Fatalf("%s: NewIBFTReader: got %v, want nil", tt.n, err)
+		}
+		i, err := r.IBFT()
+		if err != nil {
+			t.Fatalf("%s: got %v, want nil", tt.n, err)
+		}
+		if len(r.Warnings) != len(tt.want) {
+			t.Errorf("%s: got warnings %q, want %d", tt.n, r.Warnings, len(tt.want))
+			continue
+		}
+		for x := range tt.want {
+			if !strings.Contains(r.Warnings[x], tt.want[x]) {
+				t.Errorf("%s: warning %d is %q, want it to contain %q", tt.n, x, r.Warnings[x], tt.want[x])
+			}
+		}
+		if i.NIC1 != want.NIC1 || i.Target1 != want.Target1 {
+			t.Errorf("%s: got NIC1 %v, Target1 %v, want %v, %v", tt.n, i.NIC1, i.Target1, want.NIC1, want.Target1)
+		}
+	}
+}
+
+func TestIBFTSignatureVariants(t *testing.T) {
+	variants := func(r *IBFTReader) { r.SignatureVariants = true }
+	var tests = []struct {
+		sig     string
+		opt     []func(*IBFTReader)
+		ok      bool
+		warning bool
+	}{
+		{"IBFT", nil, true, false},
+		{"IBFT", []func(*IBFTReader){variants}, true, false},
+		{"iBFT", nil, false, false},
+		{"iBFT", []func(*IBFTReader){variants}, true, true},
+		{"BIFT", []func(*IBFTReader){variants}, true, true},
+		{"ibft", []func(*IBFTReader){variants}, false, false},
+	}
+	for _, tt := range tests {
+		b := readTestIBFT(t)
+		copy(b, tt.sig)
+		fixChecksum(t, b)
+		r, err := NewIBFTReader(b, tt.opt...)
+		if (err == nil) != tt.ok {
+			t.Errorf("NewIBFTReader with signature %q and %d options: got %v, want ok %v", tt.sig, len(tt.opt), err, tt.ok)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got := len(r.Warnings) == 1 && strings.Contains(r.Warnings[0], "known variant"); got != tt.warning {
+			t.Errorf("NewIBFTReader with signature %q: got warnings %q, want a variant warning %v", tt.sig, r.Warnings, tt.warning)
+		}
+		i, err := r.IBFT()
+		if err != nil {
+			t.Fatalf("Reading IBFT with signature %q: got %v, want nil", tt.sig, err)
+		}
+		if string(i.Header.Sig) != tt.sig {
+			t.Errorf("Header.Sig: got %q, want %q", i.Header.Sig, tt.sig)
+		}
+	}
+}
+
+func TestIBFTMaxTableSize(t *testing.T) {
+	b := readTestIBFT(t)
+	binary.LittleEndian.PutUint32(b[LengthOffset:], 0xfffffff0)
+	if _, err := NewIBFTReader(b); err == nil || !strings.Contains(err.Error(), "MaxTableSize") {
+		t.Errorf("NewIBFTReader with Length %#x: got %v, want MaxTableSize error", uint32(0xfffffff0), err)
+	}
+
+	defer func(m uint32) { MaxTableSize = m }(MaxTableSize)
+	b = readTestIBFT(t)
+	MaxTableSize = uint32(len(b)) - 1
+	if _, err := NewIBFTReader(b); err == nil {
+		t.Errorf("NewIBFTReader with MaxTableSize %d and Length %d: got nil, want error", MaxTableSize, len(b))
+	}
+	MaxTableSize = uint32(len(b))
+	if _, err := NewIBFTReader(b); err != nil {
+		t.Errorf("NewIBFTReader with MaxTableSize %d and Length %d: got %v, want nil", MaxTableSize, len(b), err)
+	}
+}
+
+func TestIBFTRawStructure(t *testing.T) {
+	b := readTestIBFT(t)
+	r, err := NewIBFTReader(b)
+	if err != nil {
+		t.Fatalf("NewIBFTReader: got %v, want nil", err)
+	}
+	var tests = []struct {
+		id    StructureID
+		index int
+		p     int
+		l     int
+	}{
+		{ibftControl, 0, int(ibftHeaderLen), int(ibftControlLen)},
+		{ibftInitiator, 0, int(binary.LittleEndian.Uint16(b[ibftHeaderLen+8:])), int(ibftInitiatorLen)},
+		{ibftNIC, 0, int(binary.LittleEndian.Uint16(b[ibftHeaderLen+10:])), int(ibftNICLen)},
+		{ibftTarget, 0, int(binary.LittleEndian.Uint16(b[ibftHeaderLen+12:])), int(ibftTargetLen)},
+		{ibftNIC, 1, int(binary.LittleEndian.Uint16(b[ibftHeaderLen+14:])), int(ibftNICLen)},
+		{ibftTarget, 1, int(binary.LittleEndian.Uint16(b[ibftHeaderLen+16:])), int(ibftTargetLen)},
+	}
+	for _, tt := range tests {
+		s, err := r.RawStructure(tt.id, tt.index)
+		if err != nil {
+			t.Errorf("RawStructure(%d, %d): got %v, want nil", tt.id, tt.index, err)
+			continue
+		}
+		if len(s) != tt.l || StructureID(s[0]) != tt.id || !bytes.Equal(s, b[tt.p:tt.p+tt.l]) {
+			t.Errorf("RawStructure(%d, %d): got %#x, want %#x", tt.id, tt.index, s, b[tt.p:tt.p+tt.l])
+		}
+	}
+	for _, tt := range []struct {
+		id    StructureID
+		index int
+	}{{ibftNIC, 2}, {ibftInitiator, 1}, {ibftExtensions, 0}, {42, 0}} {
+		if _, err := r.RawStructure(tt.id, tt.index); err == nil {
+			t.Errorf("RawStructure(%d, %d): got nil, want error", tt.id, tt.index)
+		}
+	}
+
+	// Remove Target1.
+	binary.LittleEndian.PutUint16(b[ibftHeaderLen+16:], 0)
+	if r, err = NewIBFTReader(b); err != nil {
+		t.Fatalf("NewIBFTReader: got %v, want nil", err)
+	}
+	if _, err := r.RawStructure(ibftTarget, 1); err == nil {
+		t.Errorf("RawStructure(%d, %d) with a 0 pointer: got nil, want error", ibftTarget, 1)
+	}
+}
+
+func TestIBFTSecretRanges(t *testing.T) {
+	b := readTestIBFT(t)
+	r, err := NewIBFTReader(b)
+	if err != nil {
+		t.Fatalf("NewIBFTReader: got %v, want nil", err)
+	}
+	var got []string
+	for _, s := range r.SecretRanges() {
+		got = append(got, string(b[s.Offset:s.Offset+s.Len]))
+	}
+	want := []string{"noun", "adverb", "bee", "arg"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("SecretRanges: got %q, want %q", got, want)
+	}
+
+	// Wiping the ranges removes the secrets and nothing else.
+	for _, s := range r.SecretRanges() {
+		copy(b[s.Offset:s.Offset+s.Len], bytes.Repeat([]byte{'x'}, s.Len))
+	}
+	i := &IBFT{}
+	if err := i.Unmarshal(b); err != nil {
+		t.Fatalf("Unmarshal of wiped table: got %v, want nil", err)
+	}
+	if i.Target0.CHAPSecret != "xxxx" || i.Target1.ReverseCHAPSecret != "xxx" {
+		t.Errorf("Wiped secrets: got %q and %q, want %q and %q", i.Target0.CHAPSecret, i.Target1.ReverseCHAPSecret, "xxxx", "xxx")
+	}
+	if i.Target0.CHAPName != "clown" || i.Target1.ReverseCHAPName != "barg" {
+		t.Errorf("CHAP names after wiping: got %q and %q, want %q and %q", i.Target0.CHAPName, i.Target1.ReverseCHAPName, "clown", "barg")
+	}
+}
+
+func TestIBFTClone(t *testing.T) {
+	b := readTestIBFT(t)
+	i := &IBFT{}
+	if err := i.Unmarshal(b); err != nil {
+		t.Fatalf("Unmarshal: got %v, want nil", err)
+	}
+	c := i.Clone()
+	if !reflect.DeepEqual(c, i) {
+		t.Fatalf("Clone: got %v, want %v", c, i)
+	}
+	c.Target0.TargetName = "iqn.2009-06.com.example:target9"
+	c.NIC1.HostName = "clone"
+	c.Reserved[0]++
+	c.AllData()[0]++
+	if i.Target0.TargetName != "iqn.2009-06.com.example:target0" || i.NIC1.HostName != "otherhost" {
+		t.Errorf("Original after changing the clone: got TargetName %q and HostName %q, want %q and %q", i.Target0.TargetName, i.NIC1.HostName, "iqn.2009-06.com.example:target0", "otherhost")
+	}
+	if !bytes.Equal(i.Reserved[:], b[24:48]) || !bytes.Equal(i.AllData(), b) {
+		t.Errorf("Original after changing the clone's reserved block and data: changed")
+	}
+}
+
+func TestUnmarshalFrom(t *testing.T) {
+	b := readTestIBFT(t)
+	const base = 0x9a000
+	mem := make([]byte, base+len(b)+4096)
+	copy(mem[base:], b)
+	// Make sure we stop at Length.
+	for i := base + len(b); i < len(mem); i++ {
+		mem[i] = 0xff
+	}
+	r := bytes.NewReader(mem)
+	i, err := UnmarshalFrom(r, base)
+	if err != nil {
+		t.Fatalf("UnmarshalFrom: got %v, want nil", err)
+	}
+	if len(i.AllData()) != len(b) {
+		t.Errorf("UnmarshalFrom: read %d bytes, want %d", len(i.AllData()), len(b))
+	}
+	if i.Target1.TargetName != "bullseye" {
+		t.Errorf("Target1.TargetName: got %q, want %q", i.Target1.TargetName, "bullseye")
+	}
+
+	for _, a := range []int64{0, int64(len(mem)) - 10, int64(len(mem)) + 10} {
+		if _, err := UnmarshalFrom(r, a); err == nil {
+			t.Errorf("UnmarshalFrom(%#x): got nil, want error", a)
+		}
+	}
+	// A table which runs off the end.
+	if _, err := UnmarshalFrom(bytes.NewReader(b[:len(b)-1]), 0); err == nil {
+		t.Errorf("UnmarshalFrom a short table: got nil, want error")
+	}
+}
+
+func TestFindTableAsIBFT(t *testing.T) {
+	ssdt, err := NewRaw(genssdt([]byte{1, 2, 3, 4}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := NewRaw(readTestIBFT(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := []Tabler{ssdt, raw}
+
+	if _, ok := FindTable(tables, "FACP"); ok {
+		t.Errorf("FindTable FACP: got true, want false")
+	}
+	s, ok := FindTable(tables, "SSDT")
+	if !ok || s != ssdt {
+		t.Errorf("FindTable SSDT: got (%v, %v), want (%v, true)", s, ok, ssdt)
+	}
+	if _, err := AsIBFT(s); err == nil {
+		t.Errorf("AsIBFT of an SSDT: got nil, want error")
+	}
+
+	r, ok := FindTable(tables, "IBFT")
+	if !ok {
+		t.Fatalf("FindTable IBFT: got false, want true")
+	}
+	ibft, err := AsIBFT(r)
+	if err != nil {
+		t.Fatalf("AsIBFT: got %v, want nil", err)
+	}
+	if ibft.Target0.TargetName != "iqn.2009-06.com.example:target0" {
+		t.Errorf("AsIBFT Target0.TargetName: got %q, want %q", ibft.Target0.TargetName, "iqn.2009-06.com.example:target0")
+	}
+	if i, err := AsIBFT(ibft); i != ibft || err != nil {
+		t.Errorf("AsIBFT of an IBFT: got (%p, %v), want (%p, nil)", i, err, ibft)
+	}
+}
+
+func TestIBFTSinglePath(t *testing.T) {
+	b := readTestIBFT(t)
+	// Zero the NIC1 and Target1 pointers, as firmware does when
+	// there is only one path.
+	binary.LittleEndian.PutUint16(b[ibftHeaderLen+14:], 0)
+	binary.LittleEndian.PutUint16(b[ibftHeaderLen+16:], 0)
+	fixChecksum(t, b)
+
+	r, err := NewIBFTReader(b)
+	if err != nil {
+		t.Fatalf("NewIBFTReader: got %v, want nil", err)
+	}
+	i, err := r.IBFT()
+	if err != nil {
+		t.Fatalf("Reading a single path IBFT: got %v, want nil", err)
+	}
+	if len(r.Warnings) != 0 {
+		t.Errorf("Warnings: got %q, want none", r.Warnings)
+	}
+	if i.NIC1.Valid != "0" || i.NIC1.IPAddress != "" || i.NIC1.HostName != "" {
+		t.Errorf("NIC1: got %v, want it not valid, and empty", i.NIC1)
+	}
+	if i.Target1.Valid != "0" || i.Target1.TargetIP != "" || i.Target1.TargetName != "" {
+		t.Errorf("Target1: got %v, want it not valid, and empty", i.Target1)
+	}
+	if i.Target0.TargetName != "iqn.2009-06.com.example:target0" {
+		t.Errorf("Target0.TargetName: got %q, want %q", i.Target0.TargetName, "iqn.2009-06.com.example:target0")
+	}
+}
+
+func TestMarshalForceLength(t *testing.T) {
+	want, err := Marshal(testIBFT())
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+	n := uint32(len(want))
+	b, err := MarshalForceLength(testIBFT(), n)
+	if err != nil {
+		t.Fatalf("MarshalForceLength(%d): got %v, want nil", n, err)
+	}
+	if !bytes.Equal(b, want) {
+		t.Errorf("MarshalForceLength with the real length: got %#x, want %#x", b, want)
+	}
+
+	for _, l := range []uint32{n - 10, n + 10} {
+		b, err := MarshalForceLength(testIBFT(), l)
+		if err != nil {
+			t.Fatalf("MarshalForceLength(%d): got %v, want nil", l, err)
+		}
+		if got := binary.LittleEndian.Uint32(b[LengthOffset:]); got != l || len(b) != int(n) {
+			t.Errorf("MarshalForceLength(%d): got Length %d and %d bytes, want %d and %d", l, got, len(b), l, n)
+		}
+		if err := VerifyChecksum(b); err == nil {
+			t.Errorf("VerifyChecksum with Length %d: got nil, want error", l)
+		}
+		s := l
+		if s > n {
+			s = n
+		}
+		if c := gencsum(b[:s]); c != 0 {
+			t.Errorf("MarshalForceLength(%d): first %d bytes are off by %#x, want them to sum to zero", l, s, c)
+		}
+		if err := (&IBFT{}).Unmarshal(b); err == nil {
+			t.Errorf("Unmarshal with Length %d of %d: got nil, want error", l, n)
+		}
+	}
+}
+
+func TestIBFTReserved(t *testing.T) {
+	i := testIBFT()
+	copy(i.Reserved[:], "OEM data\x00\x01\x02\xff")
+	b, err := Marshal(i)
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+	if !bytes.Equal(b[24:48], i.Reserved[:]) {
+		t.Errorf("Marshal: Reserved block is %q, want %q", b[24:48], i.Reserved)
+	}
+	r := &IBFT{}
+	if err := r.Unmarshal(b); err != nil {
+		t.Fatalf("Unmarshal: got %v, want nil", err)
+	}
+	if r.Reserved != i.Reserved {
+		t.Errorf("Unmarshal: Reserved is %q, want %q", r.Reserved, i.Reserved)
+	}
+	rb, err := Marshal(r)
+	if err != nil {
+		t.Fatalf("Marshal again: got %v, want nil", err)
+	}
+	if !bytes.Equal(rb, b) {
+		t.Errorf("Marshal again: got %#x, want %#x", rb, b)
+	}
+
+	j, err := json.Marshal(r)
+	if err != nil {
+		t.Fatalf("json.Marshal: got %v, want nil", err)
+	}
+	if strings.Contains(string(j), "Reserved") {
+		t.Errorf("json.Marshal: got %s, want no Reserved", j)
+	}
+}
+
+func TestIBFTHeapPastLength(t *testing.T) {
+	// testdata/ibft-shortlength.bin is testdata/ibft.bin with a Length
+	// of 452, the end of the structures, which leaves out the heap.
+	b, err := ioutil.ReadFile("testdata/ibft-shortlength.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&IBFT{}).Unmarshal(b); err == nil || !strings.Contains(err.Error(), "outside the 452 byte IBFT") {
+		t.Errorf("Unmarshal: got %v, want an error about the 452 byte IBFT", err)
+	}
+
+	r, err := NewIBFTReader(b, func(r *IBFTReader) { r.HeapPastLength = true })
+	if err != nil {
+		t.Fatalf("NewIBFTReader: got %v, want nil", err)
+	}
+	i, err := r.IBFT()
+	if err != nil {
+		t.Fatalf("Reading with HeapPastLength: got %v, want nil", err)
+	}
+	if len(r.Warnings) == 0 {
+		t.Errorf("Warnings: got none, want some about the heap")
+	}
+	for _, w := range r.Warnings {
+		if !strings.Contains(w, "past the 452 byte Length") {
+			t.Errorf("Warning: got %q, want one about the heap", w)
+		}
+	}
+	want := &IBFT{}
+	if err := want.Unmarshal(readTestIBFT(t)); err != nil {
+		t.Fatalf("Unmarshal: got %v, want nil", err)
+	}
+	if !reflect.DeepEqual(i.Initiator, want.Initiator) || !reflect.DeepEqual(i.Target1, want.Target1) {
+		t.Errorf("Reading with HeapPastLength: got %v, want %v", i, want)
+	}
+
+	// An entry past the end of the slice is still an error.
+	r, err = NewIBFTReader(b[:500], func(r *IBFTReader) { r.HeapPastLength = true })
+	if err != nil {
+		t.Fatalf("NewIBFTReader: got %v, want nil", err)
+	}
+	if _, err := r.IBFT(); err == nil {
+		t.Errorf("Reading a truncated heap with HeapPastLength: got nil, want error")
+	}
+}
+
+func TestIBFTReservedFlags(t *testing.T) {
+	defer func(n bool) { HeapNUL[HeapName] = n }(HeapNUL[HeapName])
+	HeapNUL[HeapName] = false
+	b := readTestIBFT(t)
+	// Set reserved flags bits in the initiator, NIC0 and Target0, as
+	// some firmware does.
+	var tests = []struct {
+		p    uint16
+		bits uint8
+	}{
+		{ibftHeaderLen + 8, 0x80},
+		{ibftHeaderLen + 10, 0x28},
+		{ibftHeaderLen + 12, 0x50},
+	}
+	for _, tt := range tests {
+		b[binary.LittleEndian.Uint16(b[tt.p:])+5] |= tt.bits
+	}
+	fixChecksum(t, b)
+
+	i := &IBFT{}
+	if err := i.Unmarshal(b); err != nil {
+		t.Fatalf("Unmarshal: got %v, want nil", err)
+	}
+	for n, f := range map[string][2]uint8{
+		"Initiator": {i.Initiator.ReservedFlags, 0x80},
+		"NIC0":      {i.NIC0.ReservedFlags, 0x28},
+		"Target0":   {i.Target0.ReservedFlags, 0x50},
+		"NIC1":      {i.NIC1.ReservedFlags, 0},
+	} {
+		if f[0] != f[1] {
+			t.Errorf("%s.ReservedFlags: got %#x, want %#x", n, f[0], f[1])
+		}
+	}
+	if i.Target0.Valid != "1" || i.Target0.Boot != "1" {
+		t.Errorf("Target0 Valid and Boot: got %s and %s, want 1 and 1", i.Target0.Valid, i.Target0.Boot)
+	}
+	o, err := Marshal(i)
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+	if !bytes.Equal(o, b) {
+		t.Errorf("Round trip with reserved flags: got %#x, want %#x", o, b)
+	}
+}
+
+func TestIBFTHeapLengths(t *testing.T) {
+	// testdata/ibft-badnamelen.bin is testdata/ibft.bin with an
+	// InitiatorNameLength of 30, not 33.
+	bad, err := ioutil.ReadFile("testdata/ibft-badnamelen.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The initiator Name pointer is at 70 in the initiator.
+	name := func(b []byte) int {
+		return int(binary.LittleEndian.Uint16(b[ibftHeaderLen+8:])) + 70
+	}
+	setLen := func(l uint16) []byte {
+		b := readTestIBFT(t)
+		binary.LittleEndian.PutUint16(b[name(b):], l)
+		fixChecksum(t, b)
+		return b
+	}
+	nul := readTestIBFT(t)
+	nul[binary.LittleEndian.Uint16(nul[name(nul)+2:])+5] = 0
+	fixChecksum(t, nul)
+	good, err := Marshal(testIBFT())
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+	st := testIBFT()
+	st.Target0.CHAPSecret = "a\x00b"
+	secret, err := Marshal(st)
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+	defer func(i bool) { IBFTInternHeap = i }(IBFTInternHeap)
+	IBFTInternHeap = true
+	it := testIBFT()
+	it.Target1.CHAPName = it.Target0.CHAPName
+	interned, err := Marshal(it)
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+
+	var tests = []struct {
+		n    string
+		b    []byte
+		want []string
+	}{
+		{"testdata", readTestIBFT(t), nil},
+		{"Marshaled", good, nil},
+		{"Interned", interned, nil},
+		{"Secret with a NUL", secret, nil},
+		{"Short", bad, []string{`Initiator.Name: Length is 30, but it is followed by "tor", before the heap entry at 0x1e5`}},
+		{"Long", setLen(36), []string{"Initiator.Name: Length is 36, which runs 3 bytes into the heap entry at 0x1e5"}},
+		{"NUL", nul, []string{"Initiator.Name: Length is 33, but it has a NUL at 5"}},
+	}
+	for _, tt := range tests {
+		r, err := NewIBFTReader(tt.b)
+		if err != nil {
+			t.Fatalf("%s: NewIBFTReader: got %v, want nil", tt.n, err)
+		}
+		if _, err := r.IBFT(); err != nil {
+			t.Fatalf("%s: got %v, want nil", tt.n, err)
+		}
+		if !reflect.DeepEqual(r.Warnings, tt.want) {
+			t.Errorf("%s: got warnings %q, want %q", tt.n, r.Warnings, tt.want)
+		}
+	}
+}
+
+func TestIBFTHeapEntries(t *testing.T) {
+	b := readTestIBFT(t)
+	r, err := NewIBFTReader(b)
+	if err != nil {
+		t.Fatalf("NewIBFTReader: got %v, want nil", err)
+	}
+	want := []HeapEntry{
+		{"Initiator", "Name", 452, 33, "iqn.2009-06.com.example:initiator"},
+		{"NIC0", "HostName", 485, 8, "somehost"},
+		{"Target0", "TargetName", 493, 31, "iqn.2009-06.com.example:target0"},
+		{"Target0", "CHAPName", 524, 5, "clown"},
+		{"Target0", "CHAPSecret", 529, 4, "noun"},
+		{"Target0", "ReverseCHAPName", 533, 4, "verb"},
+		{"Target0", "ReverseCHAPSecret", 537, 6, "adverb"},
+		{"NIC1", "HostName", 543, 9, "otherhost"},
+		{"Target1", "TargetName", 552, 8, "bullseye"},
+		{"Target1", "CHAPName", 560, 4, "bozo"},
+		{"Target1", "CHAPSecret", 564, 3, "bee"},
+		{"Target1", "ReverseCHAPName", 567, 4, "barg"},
+		{"Target1", "ReverseCHAPSecret", 571, 3, "arg"},
+	}
+	if got := r.HeapEntries(); !reflect.DeepEqual(got, want) {
+		t.Errorf("HeapEntries: got %+v, want %+v", got, want)
+	}
+
+	// Point NIC1's HostName 2 bytes into Target0's ReverseCHAPSecret,
+	// and make Target1's ReverseCHAPSecret run off the end. IBFT fails,
+	// but HeapEntries still shows both.
+	nic1 := int(binary.LittleEndian.Uint16(b[ibftHeaderLen+14:]))
+	binary.LittleEndian.PutUint16(b[nic1+100:], 539)
+	t1 := int(binary.LittleEndian.Uint16(b[ibftHeaderLen+16:]))
+	binary.LittleEndian.PutUint16(b[t1+50:], 10)
+	fixChecksum(t, b)
+	if r, err = NewIBFTReader(b); err != nil {
+		t.Fatalf("NewIBFTReader: got %v, want nil", err)
+	}
+	if _, err := r.IBFT(); err == nil {
+		t.Errorf("IBFT with Target1.ReverseCHAPSecret past the end: got nil, want error")
+	}
+	got := r.HeapEntries()
+	if len(got) != len(want) {
+		t.Fatalf("HeapEntries: got %d entries, want %d", len(got), len(want))
+	}
+	if e := got[7]; e.Structure != "NIC1" || e.Offset != 539 || e.Value != "verbother" {
+		t.Errorf("HeapEntries: got %+v, want NIC1.HostName, Offset 539, Value %q", e, "verbother")
+	}
+	if e := got[len(got)-1]; e.Field != "ReverseCHAPSecret" || e.Len != 10 || e.Value != "arg" {
+		t.Errorf("HeapEntries: got %+v, want Target1.ReverseCHAPSecret, Len 10, Value %q", e, "arg")
+	}
+}
+
+func TestIBFTPointerLoop(t *testing.T) {
+	// testdata/ibft-loop.bin is testdata/ibft.bin with the NIC1
+	// pointer set to NIC0.
+	b, err := ioutil.ReadFile("testdata/ibft-loop.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "it was read as NIC0 already"
+	if err := (&IBFT{}).Unmarshal(b); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Unmarshal: got %v, want an error containing %q", err, want)
+	}
+
+	// A pointer back to the control structure.
+	b = readTestIBFT(t)
+	binary.LittleEndian.PutUint16(b[ibftHeaderLen+16:], ibftHeaderLen)
+	fixChecksum(t, b)
+	want = "it was read as Control already"
+	if err := (&IBFT{}).Unmarshal(b); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Unmarshal with Target1 pointing to the control structure: got %v, want an error containing %q", err, want)
+	}
+
+	// A pointer into the middle of another structure.
+	b = readTestIBFT(t)
+	nic0 := binary.LittleEndian.Uint16(b[ibftHeaderLen+10:])
+	binary.LittleEndian.PutUint16(b[ibftHeaderLen+14:], nic0+8)
+	fixChecksum(t, b)
+	want = fmt.Sprintf("it overlaps NIC0, at %#x-%#x; the structure pointers overlap", nic0, nic0+ibftNICLen)
+	if err := (&IBFT{}).Unmarshal(b); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Unmarshal with NIC1 pointing into NIC0: got %v, want an error containing %q", err, want)
+	}
+
+	// Reading the same table twice is not a loop.
+	r, err := NewIBFTReader(readTestIBFT(t))
+	if err != nil {
+		t.Fatalf("NewIBFTReader: got %v, want nil", err)
+	}
+	for x := 0; x < 2; x++ {
+		if _, err := r.IBFT(); err != nil {
+			t.Errorf("IBFT, read %d: got %v, want nil", x, err)
+		}
+	}
+}
+
+func TestIBFTReordered(t *testing.T) {
+	// testdata/ibft-reordered.bin is testdata/ibft.bin with each
+	// target before its NIC, rather than after it, and the control
+	// pointers changed to match.
+	b, err := ioutil.ReadFile("testdata/ibft-reordered.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if t0, n0 := binary.LittleEndian.Uint16(b[ibftHeaderLen+12:]), binary.LittleEndian.Uint16(b[ibftHeaderLen+10:]); t0 >= n0 {
+		t.Fatalf("Target0 at %#x, NIC0 at %#x: want Target0 first", t0, n0)
+	}
+	r, err := NewIBFTReader(b)
+	if err != nil {
+		t.Fatalf("NewIBFTReader: got %v, want nil", err)
+	}
+	got, err := r.IBFT()
+	if err != nil {
+		t.Fatalf("IBFT: got %v, want nil", err)
+	}
+	if len(r.Warnings) != 0 {
+		t.Errorf("IBFT: got warnings %q, want none", r.Warnings)
+	}
+	want := &IBFT{}
+	if err := want.Unmarshal(readTestIBFT(t)); err != nil {
+		t.Fatalf("Unmarshal testdata/ibft.bin: got %v, want nil", err)
+	}
+	for _, n := range ibftStructs {
+		g, w := reflect.ValueOf(got).Elem().FieldByName(n).Interface(), reflect.ValueOf(want).Elem().FieldByName(n).Interface()
+		if !reflect.DeepEqual(g, w) {
+			t.Errorf("%s: got %+v, want %+v", n, g, w)
+		}
+	}
+}
+
+func TestValidTargetCount(t *testing.T) {
+	for _, tt := range []struct {
+		v0, v1 flag
+		want   int
+	}{
+		{"0", "0", 0},
+		{"1", "0", 1},
+		{"0", "1", 1},
+		{"1", "1", 2},
+	} {
+		i := testIBFT()
+		i.Target0.Valid, i.Target1.Valid = tt.v0, tt.v1
+		if got := i.ValidTargetCount(); got != tt.want {
+			t.Errorf("IBFT.ValidTargetCount, Valid %s and %s: got %d, want %d", tt.v0, tt.v1, got, tt.want)
+		}
+		b, err := i.MarshalStrict()
+		if err != nil {
+			t.Fatalf("MarshalStrict: got %v, want nil", err)
+		}
+		r, err := NewIBFTReader(b)
+		if err != nil {
+			t.Fatalf("NewIBFTReader: got %v, want nil", err)
+		}
+		if got := r.ValidTargetCount(); got != tt.want {
+			t.Errorf("IBFTReader.ValidTargetCount, Valid %s and %s: got %d, want %d", tt.v0, tt.v1, got, tt.want)
+		}
+	}
+
+	// Reading the table without its heap.
+	b, err := ioutil.ReadFile("testdata/ibft-shortlength.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewIBFTReader(b)
+	if err != nil {
+		t.Fatalf("NewIBFTReader: got %v, want nil", err)
+	}
+	if got := r.ValidTargetCount(); got != 2 {
+		t.Errorf("IBFTReader.ValidTargetCount, testdata/ibft-shortlength.bin: got %d, want 2", got)
+	}
+}
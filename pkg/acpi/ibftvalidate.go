@@ -0,0 +1,695 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acpi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Validate looks for mistakes in an IBFT which Marshal will not catch,
+// since the table is well formed, but which will keep it from working,
+// or at least look very suspicious.
+// Suspicious things are reported as Warnings; they may be just fine.
+
+// Warning is a problem found by Validate which is suspicious,
+// but might not keep the IBFT from working.
+type Warning string
+
+func (w Warning) Error() string {
+	return string(w)
+}
+
+// IsWarning returns true if err is a Warning.
+func IsWarning(err error) bool {
+	_, ok := err.(Warning)
+	return ok
+}
+
+// ErrNoValidTarget is returned by Validate for an IBFT with no valid
+// target. There is nothing to boot from; usually, firmware gave up
+// before it finished iSCSI discovery.
+var ErrNoValidTarget = errors.New("iBFT present but no valid target; firmware may not have completed iSCSI discovery")
+
+// ibftValidators are run, in order, by Validate.
+// Each one returns all the problems it finds.
+var ibftValidators = []func(*IBFT) []error{
+	validateLimits,
+	validateValidTarget,
+	validateInitiatorName,
+	validateNICIndex,
+	validateNICOrigin,
+	validateNames,
+	validateTargetPort,
+	validateHostName,
+	validateSingleLogin,
+	validatePCIBDF,
+	validateNICGlobal,
+	validateAddresses,
+	validateDiscovery,
+	validateRadiusCHAP,
+	validateTargetName,
+	validateMACs,
+	validateNICDNS,
+}
+
+// Validate checks the IBFT for problems. It returns the first problem
+// which is not a Warning; if there are only Warnings, it returns the
+// first Warning. It returns nil if no problems are found.
+func (ibft *IBFT) Validate() error {
+	var warn error
+	for _, v := range ibftValidators {
+		for _, err := range v(ibft) {
+			if !IsWarning(err) {
+				return err
+			}
+			if warn == nil {
+				warn = err
+			}
+		}
+	}
+	return warn
+}
+
+// ValidateAll checks the IBFT for problems, as Validate does, but
+// returns all of them, Warnings included, in the order they are found.
+// It returns nil if no problems are found.
+func (ibft *IBFT) ValidateAll() []error {
+	var errs []error
+	for _, v := range ibftValidators {
+		errs = append(errs, v(ibft)...)
+	}
+	return errs
+}
+
+var (
+	// From RFC 3720, section 3.2.6.3. Names are case insensitive, and
+	// normalized to lower case, so we check the lower case version.
+	iqnName = regexp.MustCompile(`^iqn\.[0-9]{4}-(0[1-9]|1[0-2])\.[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*(:.+)?$`)
+	euiName = regexp.MustCompile(`^eui\.[0-9a-f]{16}$`)
+	naaName = regexp.MustCompile(`^naa\.([0-9a-f]{16}|[0-9a-f]{32})$`)
+)
+
+// maxISCSINameLen is the maximum length of an iSCSI name, in bytes.
+const maxISCSINameLen = 223
+
+// checkISCSIName checks that s is an iSCSI name, in one of
+// the iqn., eui., or naa. forms.
+func checkISCSIName(s string) error {
+	if len(s) > maxISCSINameLen {
+		return fmt.Errorf("%q is %d bytes, more than the %d allowed", s, len(s), maxISCSINameLen)
+	}
+	l := strings.ToLower(s)
+	switch {
+	case strings.HasPrefix(l, "iqn."):
+		if !iqnName.MatchString(l) {
+			return fmt.Errorf("%q is not of the form iqn.yyyy-mm.reversed.domain[:identifier]", s)
+		}
+	case strings.HasPrefix(l, "eui."):
+		if !euiName.MatchString(l) {
+			return fmt.Errorf("%q is not of the form eui. followed by 16 hex digits", s)
+		}
+	case strings.HasPrefix(l, "naa."):
+		if !naaName.MatchString(l) {
+			return fmt.Errorf("%q is not of the form naa. followed by 16 or 32 hex digits", s)
+		}
+	default:
+		return fmt.Errorf("%q does not start with iqn., eui., or naa.", s)
+	}
+	return nil
+}
+
+// validateInitiatorName checks that a valid initiator has an iSCSI name.
+func validateInitiatorName(ibft *IBFT) []error {
+	if ibft.Initiator.Valid != "1" {
+		return nil
+	}
+	if err := checkISCSIName(string(ibft.Initiator.Name)); err != nil {
+		return []error{Warning(fmt.Sprintf("Initiator Name: %v", err))}
+	}
+	return nil
+}
+
+// validateNICIndex checks that the Index of each valid NIC matches its
+// slot, i.e. NIC0 has Index 0 and NIC1 has Index 1. Targets refer to
+// NICs by Index, so a mismatch may connect a target to the wrong NIC.
+func validateNICIndex(ibft *IBFT) []error {
+	var errs []error
+	for x, nic := range []*IBFTNIC{&ibft.NIC0, &ibft.NIC1} {
+		if nic.Valid != "1" {
+			continue
+		}
+		if want := flag(strconv.Itoa(x)); nic.Index != want {
+			errs = append(errs, Warning(fmt.Sprintf("NIC%d: Index is %q, want %q", x, nic.Index, want)))
+		}
+	}
+	return errs
+}
+
+// validateNICOrigin checks that a valid NIC with a DHCP server address
+// has an Origin of DHCP.
+func validateNICOrigin(ibft *IBFT) []error {
+	var errs []error
+	for x, nic := range []*IBFTNIC{&ibft.NIC0, &ibft.NIC1} {
+		if nic.Valid != "1" || nic.IsStatic() {
+			continue
+		}
+		if nic.Origin != ibftOriginDHCP {
+			errs = append(errs, Warning(fmt.Sprintf("NIC%d: DHCP is %s, so Origin should be %s (DHCP), not %q", x, nic.DHCP, ibftOriginDHCP, nic.Origin)))
+		}
+	}
+	return errs
+}
+
+// validateNICGlobal checks that the Global flag of each valid NIC with
+// an IPv6 address agrees with the address: clear for a link local
+// address, in fe80::/10, and set for a global one. IPv4 NICs are
+// not checked; firmware rarely sets the flag for them.
+func validateNICGlobal(ibft *IBFT) []error {
+	var errs []error
+	for x, nic := range []*IBFTNIC{&ibft.NIC0, &ibft.NIC1} {
+		ip := net.ParseIP(string(nic.IPAddress))
+		if nic.Valid != "1" || ip == nil || ip.To4() != nil {
+			continue
+		}
+		switch {
+		case ip.IsLinkLocalUnicast() && nic.IsGlobal():
+			errs = append(errs, Warning(fmt.Sprintf("NIC%d: IPAddress %s is link local, but the Global flag is set", x, ip)))
+		case ip.IsGlobalUnicast() && !nic.IsGlobal():
+			errs = append(errs, Warning(fmt.Sprintf("NIC%d: IPAddress %s is global, but the Global flag is clear", x, ip)))
+		}
+	}
+	return errs
+}
+
+// validateValidTarget checks that at least one target is valid.
+func validateValidTarget(ibft *IBFT) []error {
+	if ibft.ValidTargetCount() == 0 {
+		return []error{ErrNoValidTarget}
+	}
+	return nil
+}
+
+// printable returns the offset of the first byte of s which is not
+// printable ASCII, or -1 if there is none.
+func printable(s string) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] < ' ' || s[i] > '~' {
+			return i
+		}
+	}
+	return -1
+}
+
+// validateNames checks that the names in valid structures are printable
+// ASCII. Anything else usually means a heap offset is wrong. Secrets
+// can be binary, so they are not checked.
+func validateNames(ibft *IBFT) []error {
+	type name struct {
+		n string
+		v flag
+		s sheap
+	}
+	names := []name{{"Initiator Name", ibft.Initiator.Valid, ibft.Initiator.Name}}
+	for x, nic := range []*IBFTNIC{&ibft.NIC0, &ibft.NIC1} {
+		names = append(names, name{fmt.Sprintf("NIC%d HostName", x), nic.Valid, nic.HostName})
+	}
+	for x, tgt := range []*IBFTTarget{&ibft.Target0, &ibft.Target1} {
+		names = append(names,
+			name{fmt.Sprintf("Target%d TargetName", x), tgt.Valid, tgt.TargetName},
+			name{fmt.Sprintf("Target%d CHAPName", x), tgt.Valid, tgt.CHAPName},
+			name{fmt.Sprintf("Target%d ReverseCHAPName", x), tgt.Valid, tgt.ReverseCHAPName})
+	}
+	var errs []error
+	for _, n := range names {
+		if n.v != "1" {
+			continue
+		}
+		if i := printable(string(n.s)); i >= 0 {
+			errs = append(errs, Warning(fmt.Sprintf("%s: %q has a byte which is not printable ASCII at offset %d", n.n, n.s, i)))
+		}
+	}
+	return errs
+}
+
+// validateTargetPort checks that TargetIP and TargetPort, if both have
+// a port, agree.
+func validateTargetPort(ibft *IBFT) []error {
+	var errs []error
+	for x, tgt := range []*IBFTTarget{&ibft.Target0, &ibft.Target1} {
+		if _, err := tgt.socket(); err != nil {
+			errs = append(errs, fmt.Errorf("Target%d: %v", x, err))
+		}
+	}
+	return errs
+}
+
+// maxHostNameLen is the maximum length of a host name, and
+// maxLabelLen of each of its labels, from RFC 1123.
+const (
+	maxHostNameLen = 253
+	maxLabelLen    = 63
+)
+
+// hostLabel is a host name label, as RFC 1123 has it: letters, digits
+// and hyphens, not starting or ending with a hyphen.
+var hostLabel = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?$`)
+
+// checkHostName checks that s is an RFC 1123 host name.
+func checkHostName(s string) error {
+	if len(s) > maxHostNameLen {
+		return fmt.Errorf("%q is %d bytes, more than the %d allowed", s, len(s), maxHostNameLen)
+	}
+	for _, l := range strings.Split(s, ".") {
+		if len(l) > maxLabelLen {
+			return fmt.Errorf("%q has a label of %d bytes, more than the %d allowed", s, len(l), maxLabelLen)
+		}
+		if !hostLabel.MatchString(l) {
+			return fmt.Errorf("%q has label %q, which is not letters, digits and hyphens", s, l)
+		}
+	}
+	return nil
+}
+
+// validateHostName checks that the HostName of each valid NIC, if set,
+// is a host name. Firmware often gets it from DHCP option 12.
+func validateHostName(ibft *IBFT) []error {
+	var errs []error
+	for x, nic := range []*IBFTNIC{&ibft.NIC0, &ibft.NIC1} {
+		if nic.Valid != "1" || nic.HostName == "" {
+			continue
+		}
+		if err := checkHostName(string(nic.HostName)); err != nil {
+			errs = append(errs, Warning(fmt.Sprintf("NIC%d HostName: %v", x, err)))
+		}
+	}
+	return errs
+}
+
+// validateSingleLogin checks that, in Single Login Mode, the valid
+// targets do not have the same TargetName. Logging in to one target
+// twice, e.g. over two NICs, only makes sense in Multi-Login Mode.
+func validateSingleLogin(ibft *IBFT) []error {
+	t0, t1 := &ibft.Target0, &ibft.Target1
+	if ibft.Multi != "1" || t0.Valid != "1" || t1.Valid != "1" || t0.TargetName == "" {
+		return nil
+	}
+	if t0.TargetName != t1.TargetName {
+		return nil
+	}
+	return []error{Warning(fmt.Sprintf("Target0 and Target1: both have TargetName %q, which only makes sense in Multi-Login Mode, but the control flags set Single Login Mode", t0.TargetName))}
+}
+
+// pciBDF returns a PCI bus/device/function in the usual bus:dev.func form.
+func pciBDF(v uint16) string {
+	return fmt.Sprintf("%02x:%02x.%x", v>>8, (v>>3)&0x1f, v&7)
+}
+
+// validatePCIBDF checks that the NICs which are not valid have no
+// PCIBDF, and that the valid boot NICs have one: 0 is a real PCI
+// address, but some firmware takes it to mean not set.
+func validatePCIBDF(ibft *IBFT) []error {
+	var errs []error
+	for x, nic := range []*IBFTNIC{&ibft.NIC0, &ibft.NIC1} {
+		var v uint64
+		if nic.PCIBDF != "" {
+			var err error
+			// Marshal will catch this.
+			if v, err = strconv.ParseUint(string(nic.PCIBDF), 0, 16); err != nil {
+				continue
+			}
+		}
+		switch {
+		case nic.Valid != "1" && v != 0:
+			errs = append(errs, Warning(fmt.Sprintf("NIC%d: PCIBDF is %s (%s), but the NIC is not valid", x, nic.PCIBDF, pciBDF(uint16(v)))))
+		case nic.Valid == "1" && nic.Boot == "1" && v == 0:
+			errs = append(errs, Warning(fmt.Sprintf("NIC%d: PCIBDF is 0 (%s) for a boot NIC; some firmware needs it set", x, pciBDF(0))))
+		}
+	}
+	return errs
+}
+
+// LimitError is returned by Validate for an IBFT which cannot be
+// marshaled because it is over one of the limits the table format
+// imposes. Limit says which.
+type LimitError struct {
+	Limit string
+	Value uint64
+	Max   uint64
+}
+
+func (l *LimitError) Error() string {
+	return fmt.Sprintf("IBFT limit: %s is %d, more than %d", l.Limit, l.Value, l.Max)
+}
+
+// validateLimits checks the IBFT against the limits of the table
+// format: heap entry lengths and offsets are 16 bits, and the table
+// Length is 32 bits. The control structure always has a pointer for
+// each of the five structures; checkControl sees to that.
+func validateLimits(ibft *IBFT) []error {
+	var errs []error
+	nt := reflect.TypeOf(ibft).Elem()
+	nv := reflect.ValueOf(ibft).Elem()
+	_, end, _ := ibftStructOffsets(ibftAllStructs, ibftHeaderLen+ibftControlLen)
+	heap := uint64(end)
+	// The heap grows as HeapTable.Marshal grows it, as MarshalSize
+	// counts it: with the NULs HeapNUL adds, and shared entries once.
+	seen := map[sheap]bool{}
+	for i := 0; i < nt.NumField(); i++ {
+		if nt.Field(i).PkgPath != "" {
+			continue
+		}
+		switch nv.Field(i).Interface().(type) {
+		case IBFTInitiator, IBFTNIC, IBFTTarget:
+		default:
+			continue
+		}
+		st, sv := nt.Field(i).Type, nv.Field(i)
+		for j := 0; j < st.NumField(); j++ {
+			f := st.Field(j)
+			if f.PkgPath != "" {
+				continue
+			}
+			s, ok := sv.Field(j).Interface().(sheap)
+			if !ok || f.Tag.Get("ibft") == "-" || s == "" {
+				continue
+			}
+			name := nt.Field(i).Name + "." + f.Name
+			if len(s) > math.MaxUint16 {
+				errs = append(errs, &LimitError{Limit: name + " length", Value: uint64(len(s)), Max: math.MaxUint16})
+			}
+			if IBFTInternHeap && seen[s] {
+				continue
+			}
+			seen[s] = true
+			if heap > math.MaxUint16 {
+				errs = append(errs, &LimitError{Limit: name + " offset", Value: heap, Max: math.MaxUint16})
+			}
+			heap += uint64(len(s))
+			if HeapNUL[heapKind(f.Name)] {
+				heap++
+			}
+		}
+	}
+	if heap > math.MaxUint32 {
+		errs = append(errs, &LimitError{Limit: "table length", Value: heap, Max: math.MaxUint32})
+	}
+	return errs
+}
+
+// suspectIP returns why ip is an unlikely address for a host, router or
+// server to have, or "" if it is not.
+func suspectIP(ip net.IP) string {
+	switch {
+	case ip.IsUnspecified():
+		return "unspecified"
+	case ip.IsLoopback():
+		return "a loopback address"
+	case ip.IsMulticast():
+		return "a multicast address"
+	case ip.Equal(net.IPv4bcast):
+		return "the broadcast address"
+	}
+	return ""
+}
+
+// reverse4 returns the IPv4 address ip with its bytes reversed, or nil
+// if ip is not IPv4.
+func reverse4(ip net.IP) net.IP {
+	v4 := ip.To4()
+	if v4 == nil {
+		return nil
+	}
+	return net.IPv4(v4[3], v4[2], v4[1], v4[0])
+}
+
+// nicNet returns the subnet of nic, or nil if its IPAddress or SubNet
+// is not set.
+func nicNet(nic *IBFTNIC) *net.IPNet {
+	ip := net.ParseIP(string(nic.IPAddress))
+	p, err := strconv.ParseUint(string(nic.SubNet), 0, 8)
+	if ip == nil || ip.IsUnspecified() || err != nil || p == 0 {
+		return nil
+	}
+	bits := 128
+	if ip.To4() != nil {
+		ip, bits = ip.To4(), 32
+	}
+	m := net.CIDRMask(int(p), bits)
+	if m == nil {
+		return nil
+	}
+	return &net.IPNet{IP: ip.Mask(m), Mask: m}
+}
+
+// validateAddresses looks for addresses in valid structures which are
+// very likely wrong, often because a tool stored them byte swapped:
+// loopback, multicast and broadcast addresses, a Gateway which is off
+// the NIC's subnet but would be on it byte swapped, and a boot NIC with
+// no Gateway whose target is off its subnet. It can not catch every
+// mistake, so what it finds are Warnings.
+func validateAddresses(ibft *IBFT) []error {
+	var errs []error
+	warn := func(f string, args ...interface{}) {
+		errs = append(errs, Warning(fmt.Sprintf(f, args...)))
+	}
+	targets := []*IBFTTarget{&ibft.Target0, &ibft.Target1}
+	for x, nic := range []*IBFTNIC{&ibft.NIC0, &ibft.NIC1} {
+		if nic.Valid != "1" {
+			continue
+		}
+		for _, f := range []struct {
+			n string
+			a ipaddr
+		}{
+			{"IPAddress", nic.IPAddress},
+			{"Gateway", nic.Gateway},
+			{"PrimaryDNS", nic.PrimaryDNS},
+			{"SecondaryDNS", nic.SecondaryDNS},
+			{"DHCP", nic.DHCP},
+		} {
+			ip := net.ParseIP(string(f.a))
+			// Only the IPAddress has to be set.
+			if ip == nil || (ip.IsUnspecified() && f.n != "IPAddress") {
+				continue
+			}
+			if r := suspectIP(ip); r != "" {
+				warn("NIC%d: %s %s is %s", x, f.n, ip, r)
+			}
+		}
+
+		n := nicNet(nic)
+		if n == nil {
+			continue
+		}
+		gw := net.ParseIP(string(nic.Gateway))
+		if gw != nil && !gw.IsUnspecified() {
+			if r := reverse4(gw); !n.Contains(gw) && r != nil && n.Contains(r) {
+				warn("NIC%d: Gateway %s is not on the NIC's subnet %s, but %s is; it may be byte swapped", x, gw, n, r)
+			}
+			continue
+		}
+		if nic.Boot != "1" {
+			continue
+		}
+		for y, tgt := range targets {
+			if tgt.Valid != "1" || tgt.Index != nic.Index {
+				continue
+			}
+			h, _, err := net.SplitHostPort(string(tgt.TargetIP))
+			if err != nil {
+				h = string(tgt.TargetIP)
+			}
+			if ip := net.ParseIP(h); ip != nil && !n.Contains(ip) {
+				warn("NIC%d: boot NIC has no Gateway, but Target%d TargetIP %s is not on its subnet %s", x, y, ip, n)
+			}
+		}
+	}
+	for x, tgt := range targets {
+		if tgt.Valid != "1" {
+			continue
+		}
+		h, _, err := net.SplitHostPort(string(tgt.TargetIP))
+		if err != nil {
+			h = string(tgt.TargetIP)
+		}
+		if ip := net.ParseIP(h); ip != nil {
+			if r := suspectIP(ip); r != "" {
+				warn("Target%d: TargetIP %s is %s", x, ip, r)
+			}
+		}
+	}
+	return errs
+}
+
+// validateDiscovery reports, for a valid initiator with an iSNS or SLP
+// server, whether the server is used: with no valid targets, firmware
+// is expected to find them by discovery; with valid targets, they are
+// configured statically, and the server is redundant. Either may be
+// what was meant, so they are Warnings, to tell which is in effect.
+func validateDiscovery(ibft *IBFT) []error {
+	in := &ibft.Initiator
+	if in.Valid != "1" {
+		return nil
+	}
+	static := ibft.Target0.Valid == "1" || ibft.Target1.Valid == "1"
+	var errs []error
+	for _, s := range []struct {
+		n string
+		a ipaddr
+	}{
+		{"iSNS", in.SNSServer},
+		{"SLP", in.SLPServer},
+	} {
+		ip := net.ParseIP(string(s.a))
+		if ip == nil || ip.IsUnspecified() {
+			continue
+		}
+		if static {
+			errs = append(errs, Warning(fmt.Sprintf("Initiator: %s server %s is set, but the targets are configured statically, so it is not needed", s.n, ip)))
+			continue
+		}
+		errs = append(errs, Warning(fmt.Sprintf("Initiator: %s server %s is set, and there are no targets, so they are expected to be found by discovery", s.n, ip)))
+	}
+	return errs
+}
+
+// validateRadiusCHAP checks the RADIUS flags of each valid target, CHAP
+// and RCHAP, which say the target's CHAP, and reverse CHAP, are checked
+// by a RADIUS server, against its ChapType and the initiator's RADIUS
+// servers. These are the legal combinations:
+//
+//	CHAP  RCHAP  ChapType
+//	0     0      any
+//	1     0      1 (CHAP) or 2 (mutual CHAP)
+//	1     1      2 (mutual CHAP)
+//	0     1      2 (mutual CHAP)
+//
+// i.e. RADIUS CHAP needs CHAP, and RADIUS reverse CHAP needs mutual
+// CHAP, since that is the only kind with a reverse half. A flag for
+// CHAP which is not used is suspicious, so a Warning. Either flag
+// needs a RADIUS server in the initiator, or nothing can do the
+// checking, which is an error.
+func validateRadiusCHAP(ibft *IBFT) []error {
+	in := &ibft.Initiator
+	var radius bool
+	for _, a := range []ipaddr{in.PrimaryRadiusServer, in.SecondaryRadiusServer} {
+		if ip := net.ParseIP(string(a)); ip != nil && !ip.IsUnspecified() {
+			radius = true
+		}
+	}
+	var errs []error
+	for x, tgt := range []*IBFTTarget{&ibft.Target0, &ibft.Target1} {
+		if tgt.Valid != "1" || (tgt.CHAP != "1" && tgt.RCHAP != "1") {
+			continue
+		}
+		if !radius {
+			errs = append(errs, fmt.Errorf("Target%d: the RADIUS CHAP or rCHAP flag is set, but the initiator has no RADIUS server", x))
+		}
+		if tgt.CHAP == "1" && tgt.ChapType != ibftCHAP && tgt.ChapType != ibftMutualCHAP {
+			errs = append(errs, Warning(fmt.Sprintf("Target%d: the RADIUS CHAP flag is set, but ChapType is %q, not %s (CHAP) or %s (mutual CHAP), so there is no CHAP for RADIUS to check", x, tgt.ChapType, ibftCHAP, ibftMutualCHAP)))
+		}
+		if tgt.RCHAP == "1" && tgt.ChapType != ibftMutualCHAP {
+			errs = append(errs, Warning(fmt.Sprintf("Target%d: the RADIUS rCHAP flag is set, but ChapType is %q, not %s (mutual CHAP), so there is no reverse CHAP for RADIUS to check", x, tgt.ChapType, ibftMutualCHAP)))
+		}
+	}
+	return errs
+}
+
+// validateTargetName checks that a valid target which has a BootLUN, or
+// is the boot target, has a TargetName. Without one there is nothing
+// to log in to, so the LUN can not be reached.
+func validateTargetName(ibft *IBFT) []error {
+	var errs []error
+	for x, tgt := range []*IBFTTarget{&ibft.Target0, &ibft.Target1} {
+		if tgt.Valid != "1" || tgt.TargetName != "" {
+			continue
+		}
+		if tgt.Boot == "1" {
+			errs = append(errs, fmt.Errorf("Target%d: the Boot flag is set, but TargetName is empty, so there is no target to log in to", x))
+			continue
+		}
+		if l, err := parseLUN(tgt.BootLUN); err != nil || l != 0 {
+			errs = append(errs, fmt.Errorf("Target%d: BootLUN is %s, but TargetName is empty, so there is no target to log in to", x, tgt.BootLUN))
+		}
+	}
+	return errs
+}
+
+// validateMACs checks that the valid NICs do not have the same MAC,
+// which in a multipath setup is almost certainly a mistake, e.g. a
+// config copied from one NIC to the other, and that a valid boot NIC
+// has a MAC which is not all zeros.
+func validateMACs(ibft *IBFT) []error {
+	var (
+		errs []error
+		macs []net.HardwareAddr
+	)
+	for x, nic := range []*IBFTNIC{&ibft.NIC0, &ibft.NIC1} {
+		hw, err := net.ParseMAC(string(nic.MACAddress))
+		if nic.Valid != "1" || err != nil {
+			continue
+		}
+		if nic.Boot == "1" && bytes.Equal(hw, make(net.HardwareAddr, len(hw))) {
+			errs = append(errs, Warning(fmt.Sprintf("NIC%d: MACAddress %s is all zeros, but it is a boot NIC", x, hw)))
+		}
+		macs = append(macs, hw)
+	}
+	if len(macs) == 2 && bytes.Equal(macs[0], macs[1]) {
+		errs = append(errs, Warning(fmt.Sprintf("NIC0 and NIC1: both have MACAddress %s", macs[0])))
+	}
+	return errs
+}
+
+// validateNICDNS checks the Gateway and DNS servers of each valid NIC
+// make sense together: a NIC with a Gateway, but no DNS server, can
+// reach other networks, but not resolve a target given by host name;
+// and a NIC with a DNS server off its subnet, but no Gateway, can not
+// reach it. Link local NICs have no Gateway, and without a SubNet we
+// can not tell what is off it, so the latter is not checked for them.
+// Either may be intended, so they are Warnings.
+func validateNICDNS(ibft *IBFT) []error {
+	set := func(a ipaddr) net.IP {
+		if ip := net.ParseIP(string(a)); ip != nil && !ip.IsUnspecified() {
+			return ip
+		}
+		return nil
+	}
+	var errs []error
+	for x, nic := range []*IBFTNIC{&ibft.NIC0, &ibft.NIC1} {
+		if nic.Valid != "1" {
+			continue
+		}
+		gw := set(nic.Gateway)
+		var dns []net.IP
+		for _, a := range []ipaddr{nic.PrimaryDNS, nic.SecondaryDNS} {
+			if ip := set(a); ip != nil {
+				dns = append(dns, ip)
+			}
+		}
+		if gw != nil && len(dns) == 0 {
+			errs = append(errs, Warning(fmt.Sprintf("NIC%d: Gateway %s is set, but no DNS server is; set PrimaryDNS if a target is given by host name", x, gw)))
+		}
+		ip := net.ParseIP(string(nic.IPAddress))
+		if gw != nil || len(dns) == 0 || ip == nil || ip.IsLinkLocalUnicast() {
+			continue
+		}
+		n := nicNet(nic)
+		for _, d := range dns {
+			if n == nil || n.Contains(d) {
+				continue
+			}
+			errs = append(errs, Warning(fmt.Sprintf("NIC%d: DNS server %s is not on the NIC's subnet, and there is no Gateway to reach it; set Gateway", x, d)))
+		}
+	}
+	return errs
+}
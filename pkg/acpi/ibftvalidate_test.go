@@ -0,0 +1,621 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acpi
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// validIBFT returns an IBFT which passes Validate.
+func validIBFT() *IBFT {
+	i := testIBFT()
+	i.Initiator.Name = "iqn.2009-06.com.example:initiator"
+	// The targets are static, so there is no discovery.
+	i.Initiator.SNSServer, i.Initiator.SLPServer = "", ""
+	// Target0 does not use CHAP, so RADIUS can not check it.
+	i.Target0.CHAP = "0"
+	i.NIC0.Origin = "3"
+	i.NIC1.Origin = "3"
+	return i
+}
+
+func TestValidateValid(t *testing.T) {
+	if err := validIBFT().Validate(); err != nil {
+		t.Errorf("Validate: got %v, want nil", err)
+	}
+}
+
+func TestISCSIName(t *testing.T) {
+	var tests = []struct {
+		name string
+		ok   bool
+	}{
+		{"iqn.2009-06.com.example:initiator", true},
+		{"iqn.1992-08.com.netapp:sn.84183797", true},
+		{"iqn.2001-04.com.Example.storage:disk2.sys1.xyz", true},
+		{"iqn.2009-06.com.example", true},
+		{"eui.02004567A425678D", true},
+		{"naa.52004567BA64678D", true},
+		{"naa.62004567BA64678D0123456789ABCDEF", true},
+		{"", false},
+		{"myinitor", false},
+		{"2009-06.com.example:initiator", false},
+		{"iqn.09-06.com.example:initiator", false},
+		{"iqn.2009-13.com.example:initiator", false},
+		{"iqn.2009-06.com..example:initiator", false},
+		{"iqn.2009-06.:initiator", false},
+		{"eui.02004567A425678", false},
+		{"eui.02004567A425678G", false},
+		{"naa.52004567BA64678D01", false},
+		{"iqn.2009-06.com.example:" + strings.Repeat("x", 200), false},
+	}
+	for _, tt := range tests {
+		err := checkISCSIName(tt.name)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkISCSIName(%q): got %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidateInitiatorName(t *testing.T) {
+	for _, n := range []sheap{"iqn.2009-06.com.example:initiator", "eui.02004567A425678D"} {
+		i := validIBFT()
+		i.Initiator.Name = n
+		if err := i.Validate(); err != nil {
+			t.Errorf("Validate with initiator %q: got %v, want nil", n, err)
+		}
+	}
+	i := validIBFT()
+	i.Initiator.Name = "2009-06.com.example:initiator"
+	err := i.Validate()
+	if !IsWarning(err) || !strings.Contains(err.Error(), "Initiator Name") {
+		t.Errorf("Validate with initiator %q: got %v, want an Initiator Name Warning", i.Initiator.Name, err)
+	}
+	// It doesn't matter for an initiator that is not valid.
+	i.Initiator.Valid = "0"
+	if err := i.Validate(); err != nil {
+		t.Errorf("Validate with invalid initiator %q: got %v, want nil", i.Initiator.Name, err)
+	}
+}
+
+func TestValidateNICIndex(t *testing.T) {
+	i := validIBFT()
+	i.NIC1.Index = "0"
+	err := i.Validate()
+	if !IsWarning(err) || !strings.Contains(err.Error(), `NIC1: Index is "0", want "1"`) {
+		t.Errorf("Validate with NIC1 Index 0: got %v, want a NIC1 Index Warning", err)
+	}
+	// A NIC which is not valid has no PCIBDF either.
+	i.NIC1.Valid, i.NIC1.PCIBDF = "0", "0"
+	if err := i.Validate(); err != nil {
+		t.Errorf("Validate with invalid NIC1 Index 0: got %v, want nil", err)
+	}
+}
+
+func TestValidateNICOrigin(t *testing.T) {
+	i := validIBFT()
+	i.NIC0.Origin = "1"
+	err := i.Validate()
+	if !IsWarning(err) || !strings.Contains(err.Error(), "NIC0: DHCP is 11.11.11.11") {
+		t.Errorf("Validate with DHCP and Origin 1: got %v, want a NIC0 Origin Warning", err)
+	}
+	// A static NIC can have any Origin.
+	for _, d := range []ipaddr{"", "0.0.0.0", "::"} {
+		i.NIC0.DHCP = d
+		if err := i.Validate(); err != nil {
+			t.Errorf("Validate with DHCP %q and Origin 1: got %v, want nil", d, err)
+		}
+	}
+}
+
+func TestValidateNoValidTarget(t *testing.T) {
+	i := validIBFT()
+	i.Target0.Valid = "0"
+	if err := i.Validate(); err != nil {
+		t.Errorf("Validate with only Target1 valid: got %v, want nil", err)
+	}
+	i.Target1.Valid = "0"
+	// It must win over any Warnings.
+	i.Initiator.Name = "myinitor"
+	if err := i.Validate(); err != ErrNoValidTarget {
+		t.Errorf("Validate with no valid targets: got %v, want %v", err, ErrNoValidTarget)
+	}
+}
+
+func TestValidateNames(t *testing.T) {
+	var tests = []struct {
+		n    string
+		f    func(*IBFT)
+		want string
+	}{
+		{"Valid", func(*IBFT) {}, ""},
+		{"Target name", func(i *IBFT) { i.Target1.TargetName = "bulls\x1beye" }, "Target1 TargetName"},
+		{"CHAP name", func(i *IBFT) { i.Target0.CHAPName = "clown\x00" }, "Target0 CHAPName"},
+		{"Host name", func(i *IBFT) { i.NIC0.HostName = "h\xc3\xa9te" }, "NIC0 HostName"},
+		{"Secret", func(i *IBFT) { i.Target0.CHAPSecret = "\x00\x01\xff" }, ""},
+		{"Invalid target", func(i *IBFT) { i.Target1.Valid = "0"; i.Target1.TargetName = "\x7f" }, ""},
+	}
+	for _, tt := range tests {
+		i := validIBFT()
+		tt.f(i)
+		err := i.Validate()
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%s: got %v, want nil", tt.n, err)
+			}
+			continue
+		}
+		if !IsWarning(err) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want a %s Warning", tt.n, err, tt.want)
+		}
+	}
+}
+
+func TestValidateAll(t *testing.T) {
+	if errs := validIBFT().ValidateAll(); errs != nil {
+		t.Errorf("ValidateAll: got %v, want nil", errs)
+	}
+
+	// Three independent problems: two Warnings, then an error.
+	i := validIBFT()
+	i.Initiator.Name = "myinitor"
+	i.NIC1.Index = "0"
+	i.Target1.TargetIP, i.Target1.TargetPort = "4.4.4.4:99", "3260"
+	want := []string{"Initiator Name", "NIC1: Index", "Target1: TargetIP"}
+	errs := i.ValidateAll()
+	if len(errs) != len(want) {
+		t.Fatalf("ValidateAll: got %v, want %d problems", errs, len(want))
+	}
+	for x, w := range want {
+		if !strings.Contains(errs[x].Error(), w) {
+			t.Errorf("ValidateAll problem %d: got %v, want %s", x, errs[x], w)
+		}
+	}
+	if err := i.Validate(); err == nil || err.Error() != errs[2].Error() {
+		t.Errorf("Validate: got %v, want the error %v", err, errs[2])
+	}
+	// With only Warnings, Validate returns the first.
+	i.Target1.TargetPort = ""
+	if err := i.Validate(); !IsWarning(err) || err != errs[0] {
+		t.Errorf("Validate with only Warnings: got %v, want %v", err, errs[0])
+	}
+}
+
+func TestValidateHostName(t *testing.T) {
+	var tests = []struct {
+		name sheap
+		ok   bool
+	}{
+		{"otherhost", true},
+		{"host-1.example.com", true},
+		{"1host", true},
+		{"", true},
+		{"-host", false},
+		{"host-", false},
+		{"host_1", false},
+		{"host..example.com", false},
+		{sheap(strings.Repeat("a", 64)), false},
+		{sheap(strings.Repeat("a.", 126) + "ab"), false},
+	}
+	for _, tt := range tests {
+		i := validIBFT()
+		i.NIC1.HostName = tt.name
+		err := i.Validate()
+		if tt.ok && err != nil {
+			t.Errorf("Validate with HostName %q: got %v, want nil", tt.name, err)
+		}
+		if !tt.ok && (!IsWarning(err) || !strings.Contains(err.Error(), "NIC1 HostName")) {
+			t.Errorf("Validate with HostName %q: got %v, want a NIC1 HostName Warning", tt.name, err)
+		}
+	}
+}
+
+func TestValidateLimits(t *testing.T) {
+	big := sheap(strings.Repeat("x", math.MaxUint16+1))
+	half := sheap(strings.Repeat("x", math.MaxUint16/2))
+	var tests = []struct {
+		n     string
+		f     func(*IBFT)
+		limit string
+	}{
+		{"ok", func(*IBFT) {}, ""},
+		{"long entry", func(i *IBFT) { i.Target0.CHAPSecret = big }, "Target0.CHAPSecret length"},
+		{"high offset", func(i *IBFT) {
+			i.Target0.CHAPSecret = half
+			i.Target0.ReverseCHAPSecret = half
+		}, "NIC1.HostName offset"},
+		// ReverseCHAPName would be at 65535, but for the NULs after
+		// the names before it.
+		{"high offset with NULs", func(i *IBFT) {
+			_, end, _ := ibftStructOffsets(ibftAllStructs, ibftHeaderLen+ibftControlLen)
+			n := math.MaxUint16 - int(end) - len(i.Initiator.Name) - len(i.NIC0.HostName) - len(i.Target0.TargetName) - len(i.Target0.CHAPName)
+			i.Target0.CHAPSecret = sheap(strings.Repeat("x", n))
+		}, "Target0.ReverseCHAPName offset"},
+	}
+	for _, tt := range tests {
+		i := validIBFT()
+		tt.f(i)
+		err := i.Validate()
+		if tt.limit == "" {
+			if err != nil {
+				t.Errorf("%s: Validate: got %v, want nil", tt.n, err)
+			}
+			continue
+		}
+		l, ok := err.(*LimitError)
+		if !ok {
+			t.Errorf("%s: Validate: got %v, want a LimitError", tt.n, err)
+			continue
+		}
+		if l.Limit != tt.limit {
+			t.Errorf("%s: Limit: got %q, want %q", tt.n, l.Limit, tt.limit)
+		}
+		if _, err := Marshal(i); err == nil {
+			t.Errorf("%s: Marshal: got nil, want error", tt.n)
+		}
+	}
+}
+
+func TestValidateSingleLogin(t *testing.T) {
+	var tests = []struct {
+		n     string
+		multi flag
+		name  sheap
+		valid flag
+		warn  bool
+	}{
+		{"single login, same names", "1", "target", "1", true},
+		{"multi-login, same names", "0", "target", "1", false},
+		{"single login, different names", "1", "other", "1", false},
+		{"single login, same names, Target1 not valid", "1", "target", "0", false},
+	}
+	for _, tt := range tests {
+		i := validIBFT()
+		i.Multi = tt.multi
+		i.Target0.TargetName = "target"
+		i.Target1.TargetName, i.Target1.Valid = tt.name, tt.valid
+		var got error
+		for _, err := range validateSingleLogin(i) {
+			got = err
+		}
+		if !tt.warn {
+			if got != nil {
+				t.Errorf("%s: got %v, want nil", tt.n, got)
+			}
+			continue
+		}
+		if !IsWarning(got) || !strings.Contains(got.Error(), "Target0 and Target1") {
+			t.Errorf("%s: got %v, want a Warning naming Target0 and Target1", tt.n, got)
+		}
+	}
+}
+
+func TestValidatePCIBDF(t *testing.T) {
+	var tests = []struct {
+		n     string
+		valid flag
+		boot  flag
+		bdf   bdf
+		want  string
+	}{
+		{"valid", "1", "1", "0x18", ""},
+		{"not valid, no BDF", "0", "0", "0", ""},
+		{"not valid, with BDF", "0", "0", "0x0319", "NIC1: PCIBDF is 0x0319 (03:03.1), but the NIC is not valid"},
+		{"boot, no BDF", "1", "1", "0", "NIC1: PCIBDF is 0 (00:00.0) for a boot NIC; some firmware needs it set"},
+		{"not boot, no BDF", "1", "0", "", ""},
+	}
+	for _, tt := range tests {
+		i := validIBFT()
+		i.NIC1.Valid, i.NIC1.Boot, i.NIC1.PCIBDF = tt.valid, tt.boot, tt.bdf
+		var got []string
+		for _, err := range validatePCIBDF(i) {
+			if !IsWarning(err) {
+				t.Errorf("%s: got %v, want a Warning", tt.n, err)
+			}
+			got = append(got, err.Error())
+		}
+		switch {
+		case tt.want == "" && len(got) != 0:
+			t.Errorf("%s: got %q, want nothing", tt.n, got)
+		case tt.want != "" && (len(got) != 1 || got[0] != tt.want):
+			t.Errorf("%s: got %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestValidateNICGlobal(t *testing.T) {
+	var tests = []struct {
+		ip     ipaddr
+		global flag
+		want   string
+	}{
+		{"fe80::1", "0", ""},
+		{"fe80::1", "1", "NIC0: IPAddress fe80::1 is link local, but the Global flag is set"},
+		{"2001:db8::1", "1", ""},
+		{"2001:db8::1", "0", "NIC0: IPAddress 2001:db8::1 is global, but the Global flag is clear"},
+		{"5.5.5.5", "0", ""},
+		{"169.254.1.1", "1", ""},
+	}
+	for _, tt := range tests {
+		i := validIBFT()
+		i.NIC0.IPAddress, i.NIC0.Global = tt.ip, tt.global
+		if got := i.NIC0.IsGlobal(); got != (tt.global == "1") {
+			t.Errorf("IsGlobal with Global %q: got %v, want %v", tt.global, got, !got)
+		}
+		var got []string
+		for _, err := range validateNICGlobal(i) {
+			if !IsWarning(err) {
+				t.Errorf("%s, Global %s: got %v, want a Warning", tt.ip, tt.global, err)
+			}
+			got = append(got, err.Error())
+		}
+		switch {
+		case tt.want == "" && len(got) != 0:
+			t.Errorf("%s, Global %s: got %q, want nothing", tt.ip, tt.global, got)
+		case tt.want != "" && (len(got) != 1 || got[0] != tt.want):
+			t.Errorf("%s, Global %s: got %q, want %q", tt.ip, tt.global, got, tt.want)
+		}
+	}
+}
+
+func TestValidateAddresses(t *testing.T) {
+	var tests = []struct {
+		name string
+		f    func(*IBFT)
+		want []string
+	}{
+		{"valid", func(*IBFT) {}, nil},
+		{"on subnet", func(i *IBFT) {
+			i.NIC0.SubNet, i.NIC0.Gateway = "24", "5.5.5.1"
+		}, nil},
+		{"loopback target", func(i *IBFT) {
+			i.Target0.TargetIP = "127.0.0.1:3260"
+		}, []string{"Target0: TargetIP 127.0.0.1 is a loopback address"}},
+		{"multicast gateway", func(i *IBFT) {
+			i.NIC0.Gateway = "224.0.0.1"
+		}, []string{"NIC0: Gateway 224.0.0.1 is a multicast address"}},
+		{"unspecified address", func(i *IBFT) {
+			i.NIC1.IPAddress = "0.0.0.0"
+		}, []string{"NIC1: IPAddress 0.0.0.0 is unspecified"}},
+		{"unset DNS", func(i *IBFT) {
+			i.NIC1.PrimaryDNS, i.NIC1.SecondaryDNS = "", "0.0.0.0"
+		}, nil},
+		{"byte swapped gateway", func(i *IBFT) {
+			i.NIC0.IPAddress, i.NIC0.SubNet, i.NIC0.Gateway = "10.1.2.3", "24", "1.2.1.10"
+		}, []string{"NIC0: Gateway 1.2.1.10 is not on the NIC's subnet 10.1.2.0/24, but 10.1.2.1 is; it may be byte swapped"}},
+		{"no gateway, target off subnet", func(i *IBFT) {
+			i.NIC0.SubNet, i.NIC0.Gateway = "24", "0.0.0.0"
+		}, []string{"NIC0: boot NIC has no Gateway, but Target1 TargetIP 4.4.4.4 is not on its subnet 5.5.5.0/24"}},
+		{"no gateway, target on subnet", func(i *IBFT) {
+			i.NIC0.SubNet, i.NIC0.Gateway, i.Target1.TargetIP = "24", "", "5.5.5.9:3260"
+		}, nil},
+		{"invalid structures", func(i *IBFT) {
+			i.NIC0.Valid, i.NIC0.Gateway = "0", "127.0.0.1"
+			i.Target0.Valid, i.Target0.TargetIP = "0", "255.255.255.255:3260"
+		}, nil},
+	}
+	for _, tt := range tests {
+		i := validIBFT()
+		tt.f(i)
+		var got []string
+		for _, err := range validateAddresses(i) {
+			if !IsWarning(err) {
+				t.Errorf("%s: got %v, want a Warning", tt.name, err)
+			}
+			got = append(got, err.Error())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidateDiscovery(t *testing.T) {
+	var tests = []struct {
+		name     string
+		sns, slp ipaddr
+		targets  bool
+		want     []string
+	}{
+		{"no servers", "", "0.0.0.0", true, nil},
+		{"iSNS, static targets", "10.0.0.1", "", true, []string{
+			"Initiator: iSNS server 10.0.0.1 is set, but the targets are configured statically, so it is not needed"}},
+		{"iSNS, discovery", "10.0.0.1", "", false, []string{
+			"Initiator: iSNS server 10.0.0.1 is set, and there are no targets, so they are expected to be found by discovery"}},
+		{"SLP, static targets", "::", "10.0.0.2", true, []string{
+			"Initiator: SLP server 10.0.0.2 is set, but the targets are configured statically, so it is not needed"}},
+		{"both, discovery", "10.0.0.1", "10.0.0.2", false, []string{
+			"Initiator: iSNS server 10.0.0.1 is set, and there are no targets, so they are expected to be found by discovery",
+			"Initiator: SLP server 10.0.0.2 is set, and there are no targets, so they are expected to be found by discovery"}},
+	}
+	for _, tt := range tests {
+		i := validIBFT()
+		i.Initiator.SNSServer, i.Initiator.SLPServer = tt.sns, tt.slp
+		if !tt.targets {
+			i.Target0.Valid, i.Target1.Valid = "0", "0"
+		}
+		var got []string
+		for _, err := range validateDiscovery(i) {
+			if !IsWarning(err) {
+				t.Errorf("%s: got %v, want a Warning", tt.name, err)
+			}
+			got = append(got, err.Error())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	// Only a valid initiator has servers to use.
+	i := validIBFT()
+	i.Initiator.Valid, i.Initiator.SNSServer = "0", "10.0.0.1"
+	if errs := validateDiscovery(i); errs != nil {
+		t.Errorf("Invalid initiator: got %v, want nil", errs)
+	}
+}
+
+func TestValidateRadiusCHAP(t *testing.T) {
+	const (
+		noRadius = "Target1: the RADIUS CHAP or rCHAP flag is set, but the initiator has no RADIUS server"
+		chap     = `Target1: the RADIUS CHAP flag is set, but ChapType is "%s", not 1 (CHAP) or 2 (mutual CHAP), so there is no CHAP for RADIUS to check`
+		rchap    = `Target1: the RADIUS rCHAP flag is set, but ChapType is "%s", not 2 (mutual CHAP), so there is no reverse CHAP for RADIUS to check`
+	)
+	var tests = []struct {
+		chap, rchap flag
+		chapType    u8
+		radius      bool
+		want        []string
+	}{
+		{"0", "0", "0", false, nil},
+		{"0", "0", "2", false, nil},
+		{"1", "0", "1", true, nil},
+		{"1", "0", "2", true, nil},
+		{"1", "1", "2", true, nil},
+		{"0", "1", "2", true, nil},
+		{"1", "0", "0", true, []string{fmt.Sprintf(chap, "0")}},
+		{"1", "1", "1", true, []string{fmt.Sprintf(rchap, "1")}},
+		{"0", "1", "0", true, []string{fmt.Sprintf(rchap, "0")}},
+		{"1", "1", "0", true, []string{fmt.Sprintf(chap, "0"), fmt.Sprintf(rchap, "0")}},
+		{"1", "0", "1", false, []string{noRadius}},
+		{"0", "1", "2", false, []string{noRadius}},
+	}
+	for _, tt := range tests {
+		i := validIBFT()
+		i.Target1.CHAP, i.Target1.RCHAP, i.Target1.ChapType = tt.chap, tt.rchap, tt.chapType
+		if !tt.radius {
+			i.Initiator.PrimaryRadiusServer, i.Initiator.SecondaryRadiusServer = "", "0.0.0.0"
+		}
+		var got []string
+		for _, err := range validateRadiusCHAP(i) {
+			if w := err.Error() != noRadius; IsWarning(err) != w {
+				t.Errorf("CHAP %s, RCHAP %s, ChapType %s: %v: got Warning %v, want %v", tt.chap, tt.rchap, tt.chapType, err, !w, w)
+			}
+			got = append(got, err.Error())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("CHAP %s, RCHAP %s, ChapType %s, RADIUS %v: got %q, want %q", tt.chap, tt.rchap, tt.chapType, tt.radius, got, tt.want)
+		}
+	}
+	// Invalid targets are not checked.
+	i := validIBFT()
+	i.Target1.Valid, i.Target1.ChapType = "0", "0"
+	if errs := validateRadiusCHAP(i); errs != nil {
+		t.Errorf("Invalid target: got %v, want nil", errs)
+	}
+}
+
+func TestValidateTargetName(t *testing.T) {
+	var tests = []struct {
+		n    string
+		f    func(*IBFT)
+		want []string
+	}{
+		{"Named", func(*IBFT) {}, nil},
+		{"No LUN, not boot", func(i *IBFT) { i.Target1.TargetName, i.Target1.BootLUN, i.Target1.Boot = "", "0", "0" }, nil},
+		{"LUN", func(i *IBFT) { i.Target1.TargetName, i.Target1.BootLUN, i.Target1.Boot = "", "3", "0" },
+			[]string{"Target1: BootLUN is 3, but TargetName is empty, so there is no target to log in to"}},
+		{"Hex LUN", func(i *IBFT) { i.Target0.TargetName, i.Target0.BootLUN, i.Target0.Boot = "", "0001000000000000", "0" },
+			[]string{"Target0: BootLUN is 0001000000000000, but TargetName is empty, so there is no target to log in to"}},
+		{"Boot", func(i *IBFT) { i.Target1.TargetName, i.Target1.BootLUN, i.Target1.Boot = "", "0", "1" },
+			[]string{"Target1: the Boot flag is set, but TargetName is empty, so there is no target to log in to"}},
+		{"Invalid", func(i *IBFT) { i.Target1.TargetName, i.Target1.BootLUN, i.Target1.Valid = "", "3", "0" }, nil},
+	}
+	for _, tt := range tests {
+		i := validIBFT()
+		tt.f(i)
+		var got []string
+		for _, err := range validateTargetName(i) {
+			if IsWarning(err) {
+				t.Errorf("%s: %v: got a Warning, want an error", tt.n, err)
+			}
+			got = append(got, err.Error())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestValidateMACs(t *testing.T) {
+	var tests = []struct {
+		n    string
+		f    func(*IBFT)
+		want []string
+	}{
+		{"Different", func(*IBFT) {}, nil},
+		{"Same", func(i *IBFT) { i.NIC1.MACAddress = i.NIC0.MACAddress },
+			[]string{"NIC0 and NIC1: both have MACAddress 00:0c:29:12:a4:2e"}},
+		{"Same, in another case", func(i *IBFT) { i.NIC1.MACAddress = "00:0C:29:12:A4:2E" },
+			[]string{"NIC0 and NIC1: both have MACAddress 00:0c:29:12:a4:2e"}},
+		{"Same, NIC1 invalid", func(i *IBFT) { i.NIC1.MACAddress, i.NIC1.Valid = i.NIC0.MACAddress, "0" }, nil},
+		{"Zero boot NIC", func(i *IBFT) { i.NIC1.MACAddress = "00:00:00:00:00:00" },
+			[]string{"NIC1: MACAddress 00:00:00:00:00:00 is all zeros, but it is a boot NIC"}},
+		{"Zero NIC, not boot", func(i *IBFT) { i.NIC1.MACAddress, i.NIC1.Boot = "00:00:00:00:00:00", "0" }, nil},
+		{"Both zero", func(i *IBFT) { i.NIC0.MACAddress, i.NIC1.MACAddress = "00:00:00:00:00:00", "00:00:00:00:00:00" },
+			[]string{
+				"NIC0: MACAddress 00:00:00:00:00:00 is all zeros, but it is a boot NIC",
+				"NIC1: MACAddress 00:00:00:00:00:00 is all zeros, but it is a boot NIC",
+				"NIC0 and NIC1: both have MACAddress 00:00:00:00:00:00",
+			}},
+	}
+	for _, tt := range tests {
+		i := validIBFT()
+		tt.f(i)
+		var got []string
+		for _, err := range validateMACs(i) {
+			if !IsWarning(err) {
+				t.Errorf("%s: %v: got an error, want a Warning", tt.n, err)
+			}
+			got = append(got, err.Error())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestValidateNICDNS(t *testing.T) {
+	var tests = []struct {
+		n    string
+		f    func(*IBFT)
+		want []string
+	}{
+		{"Both", func(*IBFT) {}, nil},
+		{"Gateway, no DNS", func(i *IBFT) { i.NIC0.PrimaryDNS, i.NIC0.SecondaryDNS = "", "0.0.0.0" },
+			[]string{"NIC0: Gateway 7.7.7.7 is set, but no DNS server is; set PrimaryDNS if a target is given by host name"}},
+		{"Gateway, SecondaryDNS", func(i *IBFT) { i.NIC0.PrimaryDNS = "" }, nil},
+		{"Gateway, no DNS, not valid", func(i *IBFT) { i.NIC1.PrimaryDNS, i.NIC1.SecondaryDNS, i.NIC1.Valid = "", "", "0" }, nil},
+		{"DNS, no Gateway", func(i *IBFT) { i.NIC1.Gateway, i.NIC1.SubNet = "0.0.0.0", "24" },
+			[]string{
+				"NIC1: DNS server 18.8.8.8 is not on the NIC's subnet, and there is no Gateway to reach it; set Gateway",
+				"NIC1: DNS server 19.9.9.9 is not on the NIC's subnet, and there is no Gateway to reach it; set Gateway",
+			}},
+		{"DNS on the subnet, no Gateway", func(i *IBFT) {
+			i.NIC1.Gateway, i.NIC1.SubNet, i.NIC1.PrimaryDNS, i.NIC1.SecondaryDNS = "", "24", "15.5.5.1", ""
+		}, nil},
+		{"DNS, no Gateway, no SubNet", func(i *IBFT) { i.NIC1.Gateway = "" }, nil},
+		{"DNS, no Gateway, link local", func(i *IBFT) {
+			i.NIC1.Gateway, i.NIC1.SubNet, i.NIC1.IPAddress = "", "16", "169.254.5.5"
+		}, nil},
+		{"Neither", func(i *IBFT) { i.NIC0.Gateway, i.NIC0.PrimaryDNS, i.NIC0.SecondaryDNS = "", "", "" }, nil},
+	}
+	for _, tt := range tests {
+		i := validIBFT()
+		tt.f(i)
+		var got []string
+		for _, err := range validateNICDNS(i) {
+			if !IsWarning(err) {
+				t.Errorf("%s: %v: got an error, want a Warning", tt.n, err)
+			}
+			got = append(got, err.Error())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acpi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// MemTable is a table in memory, e.g. a live IBFT in /dev/mem, which
+// can be patched in place, without marshaling a new table.
+type MemTable struct {
+	r    io.ReaderAt
+	w    io.WriterAt
+	base int64
+}
+
+// NewMemTable returns a MemTable for the table at base, read from r
+// and written to w, which are usually the same thing.
+func NewMemTable(r io.ReaderAt, w io.WriterAt, base int64) *MemTable {
+	return &MemTable{r: r, w: w, base: base}
+}
+
+// Bytes reads the table. It reads the header to learn the Length, then
+// reads exactly that much.
+func (m *MemTable) Bytes() ([]byte, error) {
+	var h [HeaderLength]byte
+	if _, err := m.r.ReadAt(h[:], m.base); err != nil {
+		return nil, fmt.Errorf("reading table header at %#x: %v", m.base, err)
+	}
+	l := binary.LittleEndian.Uint32(h[LengthOffset:])
+	if l > MaxTableSize || l < HeaderLength {
+		return nil, fmt.Errorf("table at %#x: Length is %d, must be between %d and MaxTableSize (%d)", m.base, l, HeaderLength, MaxTableSize)
+	}
+	b := make([]byte, l)
+	if _, err := m.r.ReadAt(b, m.base); err != nil {
+		return nil, fmt.Errorf("reading %d byte table at %#x: %v", l, m.base, err)
+	}
+	return b, nil
+}
+
+// PatchField writes data at offset in the table, e.g. a new target
+// address in an IBFT, and fixes the checksum to match. The data and the
+// checksum are separate writes, so anything reading the table at the
+// same time may see a bad checksum. The Length and the checksum can not
+// be patched.
+func (m *MemTable) PatchField(offset int, data []byte) error {
+	b, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+	end := offset + len(data)
+	switch {
+	case offset < 0 || end > len(b):
+		return fmt.Errorf("PatchField: [%d:%d] is outside the %d byte table", offset, end, len(b))
+	case offset < LengthOffset+4 && end > LengthOffset:
+		return fmt.Errorf("PatchField: [%d:%d] overlaps the Length", offset, end)
+	case offset <= CSUMOffset && end > CSUMOffset:
+		return fmt.Errorf("PatchField: [%d:%d] overlaps the checksum", offset, end)
+	}
+	copy(b[offset:], data)
+	b[CSUMOffset] = 0
+	c := gencsum(b)
+	if _, err := m.w.WriteAt(data, m.base+int64(offset)); err != nil {
+		return fmt.Errorf("PatchField: writing %d bytes at %#x: %v", len(data), m.base+int64(offset), err)
+	}
+	if _, err := m.w.WriteAt([]byte{c}, m.base+CSUMOffset); err != nil {
+		return fmt.Errorf("PatchField: writing checksum at %#x: %v", m.base+CSUMOffset, err)
+	}
+	Debug("Patched %d bytes at %#x; checksum is %#02x", len(data), m.base+int64(offset), c)
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acpi
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+// mem is memory, for MemTable.
+type mem []byte
+
+func (m mem) ReadAt(b []byte, o int64) (int, error) {
+	if o >= int64(len(m)) {
+		return 0, io.EOF
+	}
+	n := copy(b, m[o:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m mem) WriteAt(b []byte, o int64) (int, error) {
+	if o+int64(len(b)) > int64(len(m)) {
+		return 0, io.ErrShortWrite
+	}
+	return copy(m[o:], b), nil
+}
+
+func TestMemTablePatchField(t *testing.T) {
+	b, err := Marshal(testIBFT())
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+	const base = 0x100
+	m := make(mem, 0x1000)
+	for i := range m {
+		m[i] = 0xa5
+	}
+	copy(m[base:], b)
+	mt := NewMemTable(m, m, base)
+
+	// Change the Target0 address, in place.
+	o := int(binary.LittleEndian.Uint16(b[ibftHeaderLen+12:])) + 6
+	if err := mt.PatchField(o, net.ParseIP("10.0.0.1").To16()); err != nil {
+		t.Fatalf("PatchField: got %v, want nil", err)
+	}
+	p, err := mt.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes: got %v, want nil", err)
+	}
+	if err := VerifyChecksum(p); err != nil {
+		t.Errorf("After PatchField: %v", err)
+	}
+	i, err := UnmarshalFrom(m, base)
+	if err != nil {
+		t.Fatalf("UnmarshalFrom: got %v, want nil", err)
+	}
+	if i.Target0.TargetIP != "10.0.0.1:88" {
+		t.Errorf("Target0.TargetIP: got %q, want %q", i.Target0.TargetIP, "10.0.0.1:88")
+	}
+	if m[base-1] != 0xa5 || m[base+len(b)] != 0xa5 {
+		t.Errorf("PatchField wrote outside the table")
+	}
+
+	for _, tt := range []struct {
+		o int
+		n int
+	}{
+		{-1, 1},
+		{len(b) - 1, 2},
+		{LengthOffset, 1},
+		{CSUMOffset - 1, 2},
+	} {
+		if err := mt.PatchField(tt.o, make([]byte, tt.n)); err == nil {
+			t.Errorf("PatchField(%d, %d bytes): got nil, want error", tt.o, tt.n)
+		}
+	}
+}
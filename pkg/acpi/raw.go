@@ -24,7 +24,11 @@ type Raw struct {
 	data []byte
 }
 
-var _ = Tabler(&Raw{})
+var (
+	_ = Tabler(&Raw{})
+	_ = Marshaler(&Raw{})
+	_ = Unmarshaler(&Raw{})
+)
 
 // NewRaw returns a new Raw table given a byte slice.
 func NewRaw(b []byte) (Tabler, error) {
@@ -32,6 +36,9 @@ func NewRaw(b []byte) (Tabler, error) {
 		return nil, fmt.Errorf("NewRaw: byte slice is only %d bytes and must be at least %d bytes", len(b), HeaderLength)
 	}
 	u := binary.LittleEndian.Uint32(b[LengthOffset : LengthOffset+4])
+	if u > uint32(len(b)) {
+		return nil, fmt.Errorf("NewRaw: table length is %d, but byte slice is only %d bytes", u, len(b))
+	}
 	return &Raw{data: b[:u]}, nil
 }
 
@@ -67,6 +74,16 @@ func (r *Raw) Marshal() ([]byte, error) {
 	return r.data, nil
 }
 
+// Unmarshal unmarshals a byte slice into a Raw table.
+func (r *Raw) Unmarshal(b []byte) error {
+	t, err := NewRaw(b)
+	if err != nil {
+		return err
+	}
+	*r = *t.(*Raw)
+	return nil
+}
+
 // AllData returns all the data in a Raw table.
 func (r *Raw) AllData() []byte {
 	return r.data
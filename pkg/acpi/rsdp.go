@@ -24,6 +24,11 @@ const (
 	cSUM2Off    = 32 // Checksum2 offset
 	xSDTLenOff  = 20
 	xSDTAddrOff = 24
+	rSDTAddrOff = 16
+	rSDPRevOff  = 15
+	// rSDPV1Len is the length of an ACPI 1.0 RSDP, which is all
+	// the first checksum covers.
+	rSDPV1Len = 20
 )
 
 var pageMask = uint64(os.Getpagesize() - 1)
@@ -53,7 +58,7 @@ type RSDP struct {
 }
 
 var (
-	defaultRSDP = []byte("RSDP PTR U-ROOT\x02")
+	defaultRSDP = []byte("RSD PTR \x00U-ROOT\x02")
 	_           = Tabler(&RSDP{})
 )
 
@@ -77,6 +82,25 @@ func NewRSDP(addr uintptr, len uint) []byte {
 	return r[:]
 }
 
+// newRSDP returns an RSDP with the given revision, pointing to the
+// RSDT at rsdt and the XSDT at xsdt, either of which can be 0.
+// Revision 0 is an ACPI 1.0 RSDP, which ends before the XSDT fields,
+// so xsdt must be 0; those bytes are left as zeros.
+func newRSDP(rev uint8, rsdt uint32, xsdt uint64) []byte {
+	var r [HeaderLength]byte
+	copy(r[:], defaultRSDP)
+	r[rSDPRevOff] = rev
+	binary.LittleEndian.PutUint32(r[rSDTAddrOff:], rsdt)
+	r[cSUM1Off] = gencsum(r[:rSDPV1Len])
+	if rev == 0 {
+		return r[:]
+	}
+	binary.LittleEndian.PutUint32(r[xSDTLenOff:], HeaderLength)
+	binary.LittleEndian.PutUint64(r[xSDTAddrOff:], xsdt)
+	r[cSUM2Off] = gencsum(r[:])
+	return r[:]
+}
+
 // Len returns the RSDP length
 func (r *RSDP) Len() uint32 {
 	return uint32(len(r.data))
@@ -135,24 +159,43 @@ func (r *RSDP) CreatorRevision() uint32 {
 
 // Base returns a base address or the [RX]SDT.
 // It will preferentially return the XSDT, but if that is
-// 0 it will return the RSDT address.
+// 0, or the RSDP is an ACPI 1.0 one, with no XSDT, it will
+// return the RSDT address.
 func (r *RSDP) Base() int64 {
 	Debug("Base %v data len %d", r, len(r.data))
-	b := int64(binary.LittleEndian.Uint32(r.data[16:20]))
-	if b != 0 {
-		return b
+	if r.revision != 0 {
+		if b := int64(binary.LittleEndian.Uint64(r.data[xSDTAddrOff:])); b != 0 {
+			return b
+		}
 	}
-	return int64(binary.LittleEndian.Uint64(r.data[24:32]))
+	return int64(binary.LittleEndian.Uint32(r.data[rSDTAddrOff:]))
 }
 
 func readRSDP(base int64) (*RSDP, error) {
-	r := &RSDP{}
-	r.base = uint64(base)
-	dat := io.ByteSlice(make([]byte, len(r.data)))
+	dat := io.ByteSlice(make([]byte, HeaderLength))
 	if err := io.Read(base, &dat); err != nil {
 		return nil, err
 	}
-	copy(r.data[:], dat)
+	return rsdpFromBytes(base, dat)
+}
+
+// rsdpFromBytes returns the RSDP at the start of b, which was read
+// from base. An ACPI 1.0 RSDP, Revision 0, is only rSDPV1Len bytes,
+// and has no XSDT; what follows it in b is something else, e.g. the
+// RSDT, and is not kept.
+func rsdpFromBytes(base int64, b []byte) (*RSDP, error) {
+	if len(b) < rSDPV1Len || string(b[:8]) != "RSD PTR " {
+		return nil, fmt.Errorf("no RSDP at %#x", base)
+	}
+	r := &RSDP{base: uint64(base), revision: b[rSDPRevOff]}
+	if r.revision == 0 {
+		copy(r.data[:], b[:rSDPV1Len])
+		return r, nil
+	}
+	if len(b) < xSDTAddrOff+8 {
+		return nil, fmt.Errorf("RSDP at %#x: Revision is %d, but there are only %d bytes", base, r.revision, len(b))
+	}
+	copy(r.data[:], b)
 	return r, nil
 }
 
@@ -5,6 +5,7 @@
 package acpi
 
 import (
+	"encoding/binary"
 	"os"
 	"testing"
 )
@@ -33,3 +34,56 @@ func TestRSDP(t *testing.T) {
 		t.Logf("%d: %v, %d bytes", i, tt.Sig(), tt.Len())
 	}
 }
+
+func TestRSDPRevision0(t *testing.T) {
+	const base = 0xe0000
+	// An ACPI 1.0 table set: a 20 byte RSDP, then an RSDT, and no XSDT.
+	ts := &TableSet{Revision: 1, Tables: []Marshaler{testIBFT()}}
+	b, addrs, err := ts.Assemble(base)
+	if err != nil {
+		t.Fatalf("Assemble: got %v, want nil", err)
+	}
+	// Reading the RSDP reads HeaderLength bytes, so the start of the
+	// RSDT is read with it.
+	r, err := rsdpFromBytes(base, b[:HeaderLength])
+	if err != nil {
+		t.Fatalf("rsdpFromBytes: got %v, want nil", err)
+	}
+	if r.Revision() != 0 {
+		t.Errorf("Revision: got %d, want 0", r.Revision())
+	}
+	if got, want := r.Base(), int64(addrs["RSDT"]); got != want {
+		t.Fatalf("Base: got %#x, want %#x, the RSDT", got, want)
+	}
+	o := r.Base() - base
+	s := &SDT{}
+	if err := s.Unmarshal(b[o : o+int64(binary.LittleEndian.Uint32(b[o+LengthOffset:]))]); err != nil {
+		t.Fatalf("Unmarshal RSDT: got %v, want nil", err)
+	}
+	if s.Sig() != "RSDT" || len(s.Tables) != 1 || s.Tables[0] != int64(addrs["IBFT"]) {
+		t.Errorf("RSDT: got %s with tables %#x, want RSDT with [%#x]", s.Sig(), s.Tables, addrs["IBFT"])
+	}
+
+	// A later RSDP with both uses the XSDT.
+	r, err = rsdpFromBytes(base, newRSDP(2, 0x1000, 0x2000))
+	if err != nil {
+		t.Fatalf("rsdpFromBytes: got %v, want nil", err)
+	}
+	if r.Revision() != 2 || r.Base() != 0x2000 {
+		t.Errorf("Revision 2 RSDP: got Revision %d, Base %#x, want 2, %#x", r.Revision(), r.Base(), 0x2000)
+	}
+	// If it has no XSDT, it uses the RSDT.
+	r, err = rsdpFromBytes(base, newRSDP(2, 0x1000, 0))
+	if err != nil {
+		t.Fatalf("rsdpFromBytes: got %v, want nil", err)
+	}
+	if r.Base() != 0x1000 {
+		t.Errorf("Revision 2 RSDP with no XSDT: got Base %#x, want %#x", r.Base(), 0x1000)
+	}
+
+	for _, b := range [][]byte{b[:rSDPV1Len-1], []byte("RSD PTX \x00U-ROOT\x00\x00\x00\x00\x00"), newRSDP(2, 0x1000, 0x2000)[:rSDPV1Len]} {
+		if _, err := rsdpFromBytes(base, b); err == nil {
+			t.Errorf("rsdpFromBytes(%q): got nil, want error", b)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"math"
 	"os"
 )
 
@@ -22,6 +23,11 @@ type SDT struct {
 	Base   int64
 }
 
+var (
+	_ = Marshaler(&SDT{})
+	_ = Unmarshaler(&SDT{})
+)
+
 func init() {
 	addUnMarshaler("RSDT", unmarshalSDT)
 	addUnMarshaler("XSDT", unmarshalSDT)
@@ -62,6 +68,23 @@ func unmarshalSDT(t Tabler) (Tabler, error) {
 	return s, nil
 }
 
+// Unmarshal unmarshals a byte slice into an SDT. Base is not changed,
+// as the table does not say where it is.
+func (s *SDT) Unmarshal(b []byte) error {
+	t, err := NewRaw(b)
+	if err != nil {
+		return err
+	}
+	n, err := unmarshalSDT(t)
+	if err != nil {
+		return err
+	}
+	base := s.Base
+	*s = *n.(*SDT)
+	s.Base = base
+	return nil
+}
+
 // Marshal marshals an [RX]SDT. If it has tables, it marshals them too.
 // Note that tables are just pointers in this case. Most users will likely
 // remove the tables (s->Tables = nil) and add their own in the call to
@@ -76,9 +99,12 @@ func (s *SDT) Marshal() ([]byte, error) {
 	for _, p := range s.Tables {
 		if x {
 			w(b, p)
-		} else {
-			w(b, uint32(p))
+			continue
 		}
+		if p < 0 || p > math.MaxUint32 {
+			return nil, fmt.Errorf("%s table address %#x does not fit in 32 bits", s.Sig(), p)
+		}
+		w(b, uint32(p))
 	}
 	return b.Bytes(), nil
 }
@@ -185,6 +211,13 @@ func ReadSDT() (*SDT, error) {
 	return s, err
 }
 
+// NewRSDT creates a new RSDT, i.e. an SDT with 32 bit pointers, for
+// guests which do not use the XSDT. The options are applied after
+// the signature is set.
+func NewRSDT(opt ...func(*SDT)) (*SDT, error) {
+	return NewSDT(append([]func(*SDT){func(s *SDT) { s.Header.Sig = "RSDT" }}, opt...)...)
+}
+
 // NewSDT creates a new SDT, defaulting to XSDT.
 func NewSDT(opt ...func(*SDT)) (*SDT, error) {
 	var s = &SDT{
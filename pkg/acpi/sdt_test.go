@@ -13,6 +13,7 @@ import (
 // TestSDT tests basic functions, so that we can verify the marshal/unmarshal
 // is idempotent.
 func TestSDT(t *testing.T) {
+	defer func(d func(string, ...interface{})) { Debug = d }(Debug)
 	Debug = t.Logf
 	if os.Getuid() != 0 {
 		t.Logf("NOT root, skipping")
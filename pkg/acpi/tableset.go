@@ -0,0 +1,318 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acpi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// TableSet is a set of ACPI tables, along with the RSDP and XSDT
+// needed to find them. It is what you need to give a guest, or a
+// kexec'ed kernel, its own ACPI tables, e.g. an IBFT.
+type TableSet struct {
+	// XSDT is the root table. Its Tables are replaced by the
+	// addresses of Tables when the set is assembled. If it is nil,
+	// the XSDT from NewSDT is used.
+	XSDT *SDT
+	// RSDT, if not nil, is assembled as well, and points to the same
+	// Tables with 32 bit pointers, for guests which only read the
+	// RSDT. Its Tables are replaced, as the XSDT's are.
+	RSDT *SDT
+	// Revision is the ACPI revision the set is for: 2, the default,
+	// or 1. An ACPI 1.0 RSDP is the 20 byte first part of a later
+	// one, and only points to an RSDT, so for Revision 1 XSDT must be
+	// nil, and if RSDT is nil, the RSDT from NewRSDT is used.
+	Revision uint8
+	// Tables are the tables the XSDT points to.
+	Tables []Marshaler
+	// Align is the alignment of each of the Tables, as for Place.
+	// 0 means they are packed together.
+	Align int
+}
+
+// Assemble marshals the RSDP, the XSDT, the RSDT if there is one, and
+// the Tables, and lays them out in that order starting at base. The
+// RSDP points to the XSDT and RSDT, and they to the Tables. It returns
+// the marshaled tables, and a map from signature to address, including
+// the RSDP (as "RSD PTR "), XSDT and RSDT.
+// If more than one table has the same signature, e.g. SSDTs, the map
+// holds the address of the first.
+// base should be 16-byte aligned, as the RSDP must be. With an RSDT,
+// all the tables must be below 4GiB.
+func (ts *TableSet) Assemble(base uint64) ([]byte, map[string]uint64, error) {
+	b, addrs, _, err := ts.assemble(base)
+	return b, addrs, err
+}
+
+// assemble does the work of Assemble, and also returns where each of
+// the Tables went.
+func (ts *TableSet) assemble(base uint64) ([]byte, map[string]uint64, []Placement, error) {
+	x, rs, rev, err := ts.roots()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	rsdpLen := uint64(HeaderLength)
+	if rev == 0 {
+		rsdpLen = rSDPV1Len
+	}
+
+	// Lay out the root tables, then the Tables after them.
+	var (
+		roots     []*SDT
+		rootAddrs []uint64
+		next      = base + rsdpLen
+	)
+	for _, s := range []struct {
+		s *SDT
+		n int
+	}{{x, 8}, {rs, 4}} {
+		if s.s == nil {
+			continue
+		}
+		roots, rootAddrs = append(roots, s.s), append(rootAddrs, next)
+		next += HeaderLength + uint64(s.n*len(ts.Tables))
+	}
+
+	align := ts.Align
+	if align == 0 {
+		align = 1
+	}
+	pl, err := Place(next, ts.Tables, align)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("TableSet: %v", err)
+	}
+	addrs := map[string]uint64{}
+	var ptrs []int64
+	for _, p := range pl {
+		if _, ok := addrs[string(p.data[:4])]; !ok {
+			addrs[string(p.data[:4])] = p.Address
+		}
+		ptrs = append(ptrs, int64(p.Address))
+	}
+
+	var (
+		b      = make([]byte, rsdpLen)
+		xaddr  uint64
+		rsaddr uint32
+	)
+	for i, s := range roots {
+		s.Tables = append([]int64{}, ptrs...)
+		sb, err := Marshal(s)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("TableSet %s: %v", s.Sig(), err)
+		}
+		addrs[string(sb[:4])] = rootAddrs[i]
+		if s == x {
+			xaddr = rootAddrs[i]
+		} else {
+			if rootAddrs[i] > math.MaxUint32 {
+				return nil, nil, nil, fmt.Errorf("TableSet RSDT address %#x does not fit in 32 bits", rootAddrs[i])
+			}
+			rsaddr = uint32(rootAddrs[i])
+		}
+		b = append(b, sb...)
+	}
+	r := newRSDP(rev, rsaddr, xaddr)
+	copy(b[:rsdpLen], r)
+	addrs[string(r[:8])] = base
+
+	for _, p := range pl {
+		b = append(b, make([]byte, p.Address-base-uint64(len(b)))...)
+		b = append(b, p.data...)
+	}
+	Debug("TableSet: %d tables, %d bytes at %#x", len(pl), len(b), base)
+	return b, addrs, pl, nil
+}
+
+// validateBase is where Validate assembles a TableSet. It is not 0, so
+// that a pointer which was never filled in does not point at the RSDP.
+const validateBase = 0x100000
+
+// Validate assembles the TableSet, as Assemble does, and checks the
+// result: that the RSDP points to the root tables, that their entries
+// point to the Tables, where they were placed, and that each table's
+// checksum is right. It is for catching assembler bugs, e.g. a pointer
+// which went stale when the tables were laid out again. Then, as for
+// IBFT.Validate, each of the Tables which has a Validate method is
+// checked with it; the first problem which is not a Warning is
+// returned, or if there are only Warnings, the first Warning.
+func (ts *TableSet) Validate() error {
+	b, _, pl, err := ts.assemble(validateBase)
+	if err != nil {
+		return err
+	}
+	var want []uint64
+	for _, p := range pl {
+		want = append(want, p.Address)
+	}
+	if err := checkAssembled(b, validateBase, want); err != nil {
+		return err
+	}
+	var warn error
+	for i, t := range ts.Tables {
+		v, ok := t.(interface{ Validate() error })
+		if !ok {
+			continue
+		}
+		err := v.Validate()
+		switch {
+		case err == nil:
+		case !IsWarning(err):
+			return fmt.Errorf("TableSet table %d (%T): %v", i, t, err)
+		case warn == nil:
+			warn = Warning(fmt.Sprintf("TableSet table %d (%T): %v", i, t, err))
+		}
+	}
+	return warn
+}
+
+// checkAssembled checks the tables b, assembled at base, for Validate.
+// want is where the Tables should be.
+func checkAssembled(b []byte, base uint64, want []uint64) error {
+	end := base + uint64(len(b))
+	table := func(a uint64) ([]byte, error) {
+		if a < base || a+HeaderLength > end {
+			return nil, fmt.Errorf("%#x is outside the set, at %#x-%#x", a, base, end)
+		}
+		o := a - base
+		l := uint64(binary.LittleEndian.Uint32(b[o+LengthOffset:]))
+		if a+l > end {
+			return nil, fmt.Errorf("%q table at %#x is %d bytes, which runs past the end of the set at %#x", b[o:o+4], a, l, end)
+		}
+		t := b[o : o+l]
+		return t, VerifyChecksum(t)
+	}
+
+	if len(b) < rSDPV1Len || string(b[:8]) != "RSD PTR " {
+		return fmt.Errorf("TableSet: no RSDP at %#x", base)
+	}
+	if c := gencsum(b[:rSDPV1Len]); c != 0 {
+		return fmt.Errorf("TableSet: RSDP checksum is off by %#02x", c)
+	}
+	roots := []struct {
+		sig  string
+		addr uint64
+		size int
+	}{{"RSDT", uint64(binary.LittleEndian.Uint32(b[rSDTAddrOff:])), 4}}
+	if b[rSDPRevOff] != 0 {
+		if len(b) < HeaderLength {
+			return fmt.Errorf("TableSet: RSDP Revision is %d, but there are only %d bytes", b[rSDPRevOff], len(b))
+		}
+		if l := binary.LittleEndian.Uint32(b[xSDTLenOff:]); l != HeaderLength {
+			return fmt.Errorf("TableSet: RSDP Length is %d, want %d", l, HeaderLength)
+		}
+		if c := gencsum(b[:HeaderLength]); c != 0 {
+			return fmt.Errorf("TableSet: RSDP extended checksum is off by %#02x", c)
+		}
+		roots = append(roots, struct {
+			sig  string
+			addr uint64
+			size int
+		}{"XSDT", binary.LittleEndian.Uint64(b[xSDTAddrOff:]), 8})
+	}
+
+	found := false
+	for _, r := range roots {
+		if r.addr == 0 {
+			continue
+		}
+		found = true
+		t, err := table(r.addr)
+		if err != nil {
+			return fmt.Errorf("TableSet: RSDP %s address: %v", r.sig, err)
+		}
+		if string(t[:4]) != r.sig {
+			return fmt.Errorf("TableSet: RSDP %s address %#x: points to a %q table", r.sig, r.addr, t[:4])
+		}
+		e := t[HeaderLength:]
+		if len(e) != r.size*len(want) {
+			return fmt.Errorf("TableSet: %s has %d bytes of entries, want %d for %d tables", r.sig, len(e), r.size*len(want), len(want))
+		}
+		for i, w := range want {
+			var p [8]byte
+			copy(p[:r.size], e[i*r.size:])
+			a := binary.LittleEndian.Uint64(p[:])
+			if a != w {
+				return fmt.Errorf("TableSet: %s entry %d is %#x, but table %d is at %#x", r.sig, i, a, i, w)
+			}
+			if _, err := table(a); err != nil {
+				return fmt.Errorf("TableSet: %s entry %d: %v", r.sig, i, err)
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("TableSet: RSDP points to no RSDT or XSDT")
+	}
+	return nil
+}
+
+// roots returns the XSDT and RSDT to assemble, either of which may be
+// nil, and the RSDP revision to go with them.
+func (ts *TableSet) roots() (*SDT, *SDT, uint8, error) {
+	var (
+		x, rs = ts.XSDT, ts.RSDT
+		err   error
+	)
+	switch ts.Revision {
+	case 0, 2:
+		if x == nil {
+			if x, err = NewSDT(); err != nil {
+				return nil, nil, 0, err
+			}
+		}
+		return x, rs, 2, nil
+	case 1:
+		// The ACPI 1.0 RSDP Revision is 0.
+		if x != nil {
+			return nil, nil, 0, fmt.Errorf("TableSet Revision 1: has an XSDT, which an ACPI 1.0 RSDP can not point to")
+		}
+		if rs == nil {
+			if rs, err = NewRSDT(); err != nil {
+				return nil, nil, 0, err
+			}
+		}
+		return nil, rs, 0, nil
+	}
+	return nil, nil, 0, fmt.Errorf("TableSet Revision %d: must be 1 or 2", ts.Revision)
+}
+
+// Placement is where Place puts a table.
+type Placement struct {
+	// Offset is from the base passed to Place.
+	Offset  uint64
+	Size    int
+	Address uint64
+
+	data []byte
+}
+
+// Place marshals the tables and lays them out in order, starting at
+// base, with each table's address a multiple of align. It does no more
+// than work out where each table goes: what is between the tables, and
+// what points to them, is up to the caller.
+func Place(base uint64, tables []Marshaler, align int) ([]Placement, error) {
+	if align <= 0 {
+		return nil, fmt.Errorf("Place: alignment %d must be > 0", align)
+	}
+	var (
+		pl   []Placement
+		a    = uint64(align)
+		next = base
+	)
+	for i, t := range tables {
+		b, err := Marshal(t)
+		if err != nil {
+			return nil, fmt.Errorf("table %d (%T): %v", i, t, err)
+		}
+		if r := next % a; r != 0 {
+			next += a - r
+		}
+		pl = append(pl, Placement{Offset: next - base, Size: len(b), Address: next, data: b})
+		next += uint64(len(b))
+	}
+	return pl, nil
+}
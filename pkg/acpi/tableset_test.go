@@ -0,0 +1,300 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acpi
+
+import (
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTableSetAssemble(t *testing.T) {
+	ssdt, err := NewRaw(genssdt([]byte{1, 2, 3, 4}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := &TableSet{Tables: []Marshaler{testIBFT(), ssdt}}
+	const base = 0xe0000
+	b, addrs, err := ts.Assemble(base)
+	if err != nil {
+		t.Fatalf("Assemble: got %v, want nil", err)
+	}
+	at := func(a uint64) []byte {
+		return b[a-base:]
+	}
+
+	r := at(addrs["RSD PTR "])
+	if addrs["RSD PTR "] != base || string(r[:8]) != "RSD PTR " {
+		t.Fatalf("RSDP: got %q at %#x, want %q at %#x", r[:8], addrs["RSD PTR "], "RSD PTR ", base)
+	}
+	if c := gencsum(r[:20]); c != 0 {
+		t.Errorf("RSDP v1 checksum: off by %#x", c)
+	}
+	if c := gencsum(r[:HeaderLength]); c != 0 {
+		t.Errorf("RSDP extended checksum: off by %#x", c)
+	}
+	if a := binary.LittleEndian.Uint64(r[xSDTAddrOff:]); a != addrs["XSDT"] {
+		t.Errorf("RSDP XSDT address: got %#x, want %#x", a, addrs["XSDT"])
+	}
+
+	x := &SDT{}
+	if err := x.Unmarshal(at(addrs["XSDT"])); err != nil {
+		t.Fatalf("Unmarshal XSDT: got %v, want nil", err)
+	}
+	if err := VerifyChecksum(x.AllData()); err != nil {
+		t.Errorf("XSDT: %v", err)
+	}
+	if len(x.Tables) != 2 {
+		t.Fatalf("XSDT tables: got %d, want 2", len(x.Tables))
+	}
+	for i, sig := range []string{"IBFT", "SSDT"} {
+		if uint64(x.Tables[i]) != addrs[sig] {
+			t.Errorf("XSDT table %d: got %#x, want %s at %#x", i, x.Tables[i], sig, addrs[sig])
+		}
+		tab, err := NewRaw(at(addrs[sig]))
+		if err != nil {
+			t.Errorf("%s at %#x: got %v, want nil", sig, addrs[sig], err)
+			continue
+		}
+		if tab.Sig() != sig {
+			t.Errorf("Table at %#x: got %q, want %q", addrs[sig], tab.Sig(), sig)
+		}
+		if err := VerifyChecksum(tab.AllData()); err != nil {
+			t.Errorf("%s: %v", sig, err)
+		}
+	}
+	if end := addrs["SSDT"] + uint64(ssdt.Len()); end != base+uint64(len(b)) {
+		t.Errorf("End of tables: got %#x, want %#x", end, base+uint64(len(b)))
+	}
+}
+
+func TestTableSetRSDT(t *testing.T) {
+	const base = 0xe0000
+	var tests = []struct {
+		n    string
+		ts   *TableSet
+		rev  uint8
+		xsdt bool
+		rsdp uint64
+	}{
+		{"Revision 1", &TableSet{Revision: 1, Tables: []Marshaler{testIBFT()}}, 0, false, rSDPV1Len},
+		{"Revision 2 with RSDT", &TableSet{RSDT: func() *SDT {
+			s, err := NewRSDT()
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		}(), Tables: []Marshaler{testIBFT()}}, 2, true, HeaderLength},
+	}
+	for _, tt := range tests {
+		b, addrs, err := tt.ts.Assemble(base)
+		if err != nil {
+			t.Fatalf("%s: Assemble: got %v, want nil", tt.n, err)
+		}
+		at := func(a uint64) []byte {
+			return b[a-base:]
+		}
+		r := at(addrs["RSD PTR "])
+		if r[rSDPRevOff] != tt.rev {
+			t.Errorf("%s: RSDP Revision: got %d, want %d", tt.n, r[rSDPRevOff], tt.rev)
+		}
+		if c := gencsum(r[:rSDPV1Len]); c != 0 {
+			t.Errorf("%s: RSDP v1 checksum: off by %#x", tt.n, c)
+		}
+		if a := binary.LittleEndian.Uint32(r[rSDTAddrOff:]); uint64(a) != addrs["RSDT"] {
+			t.Errorf("%s: RSDP RSDT address: got %#x, want %#x", tt.n, a, addrs["RSDT"])
+		}
+		// The root tables follow the RSDP, which is only 20 bytes
+		// for ACPI 1.0.
+		first := addrs["RSDT"]
+		if x, ok := addrs["XSDT"]; ok != tt.xsdt {
+			t.Errorf("%s: XSDT: got %v, want %v", tt.n, ok, tt.xsdt)
+		} else if ok {
+			first = x
+			if a := binary.LittleEndian.Uint64(r[xSDTAddrOff:]); a != x {
+				t.Errorf("%s: RSDP XSDT address: got %#x, want %#x", tt.n, a, x)
+			}
+		}
+		if first-base != tt.rsdp {
+			t.Errorf("%s: RSDP: got %d bytes, want %d", tt.n, first-base, tt.rsdp)
+		}
+
+		rs := &SDT{}
+		if err := rs.Unmarshal(at(addrs["RSDT"])); err != nil {
+			t.Fatalf("%s: Unmarshal RSDT: got %v, want nil", tt.n, err)
+		}
+		if err := VerifyChecksum(rs.AllData()); err != nil {
+			t.Errorf("%s: RSDT: %v", tt.n, err)
+		}
+		if rs.Sig() != "RSDT" || len(rs.Tables) != 1 || uint64(rs.Tables[0]) != addrs["IBFT"] {
+			t.Errorf("%s: RSDT: got %q with tables %#x, want RSDT with IBFT at %#x", tt.n, rs.Sig(), rs.Tables, addrs["IBFT"])
+		}
+		if err := VerifyChecksum(at(addrs["IBFT"])[:len(b)-int(addrs["IBFT"]-base)]); err != nil {
+			t.Errorf("%s: IBFT: %v", tt.n, err)
+		}
+	}
+}
+
+func TestTableSetRSDTTooHigh(t *testing.T) {
+	ts := &TableSet{Revision: 1, Tables: []Marshaler{testIBFT()}}
+	if _, _, err := ts.Assemble(1 << 32); err == nil {
+		t.Errorf("Assemble with an RSDT above 4GiB: got nil, want error")
+	}
+	if _, _, err := (&TableSet{Revision: 3}).Assemble(0xe0000); err == nil {
+		t.Errorf("Assemble with Revision 3: got nil, want error")
+	}
+	x, err := NewSDT()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := (&TableSet{Revision: 1, XSDT: x}).Assemble(0xe0000); err == nil {
+		t.Errorf("Assemble with Revision 1 and an XSDT: got nil, want error")
+	}
+}
+
+func TestPlace(t *testing.T) {
+	ssdt, err := NewRaw(genssdt([]byte{1, 2, 3, 4}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ibft, err := Marshal(testIBFT())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		base  uint64
+		align int
+		want  []Placement
+	}{
+		{0x1000, 1, []Placement{
+			{Offset: 0, Size: len(ibft), Address: 0x1000},
+			{Offset: uint64(len(ibft)), Size: int(ssdt.Len()), Address: 0x1000 + uint64(len(ibft))},
+		}},
+		{0x1001, 16, []Placement{
+			{Offset: 0xf, Size: len(ibft), Address: 0x1010},
+			{Offset: 0x22f, Size: int(ssdt.Len()), Address: 0x1230},
+		}},
+	}
+	for _, tt := range tests {
+		pl, err := Place(tt.base, []Marshaler{testIBFT(), ssdt}, tt.align)
+		if err != nil {
+			t.Fatalf("Place(%#x, %d): got %v, want nil", tt.base, tt.align, err)
+		}
+		if len(pl) != len(tt.want) {
+			t.Fatalf("Place(%#x, %d): got %d placements, want %d", tt.base, tt.align, len(pl), len(tt.want))
+		}
+		for i := range pl {
+			pl[i].data = nil
+			if !reflect.DeepEqual(pl[i], tt.want[i]) {
+				t.Errorf("Place(%#x, %d) table %d: got %+v, want %+v", tt.base, tt.align, i, pl[i], tt.want[i])
+			}
+		}
+	}
+	for _, a := range []int{0, -1} {
+		if _, err := Place(0x1000, nil, a); err == nil {
+			t.Errorf("Place with alignment %d: got nil, want error", a)
+		}
+	}
+}
+
+func TestTableSetAlign(t *testing.T) {
+	ssdt, err := NewRaw(genssdt([]byte{1, 2, 3, 4}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const base = 0xe0000
+	ts := &TableSet{Tables: []Marshaler{testIBFT(), ssdt}, Align: 4096}
+	b, addrs, err := ts.Assemble(base)
+	if err != nil {
+		t.Fatalf("Assemble: got %v, want nil", err)
+	}
+	for _, sig := range []string{"IBFT", "SSDT"} {
+		a := addrs[sig]
+		if a%4096 != 0 {
+			t.Errorf("%s: got address %#x, want it 4096 byte aligned", sig, a)
+		}
+		if s := string(b[a-base : a-base+4]); s != sig {
+			t.Errorf("Table at %#x: got %q, want %q", a, s, sig)
+		}
+	}
+}
+
+func TestTableSetValidate(t *testing.T) {
+	ssdt, err := NewRaw(genssdt([]byte{1, 2, 3, 4}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rev := range []uint8{1, 2} {
+		ts := &TableSet{Revision: rev, Tables: []Marshaler{validIBFT(), ssdt}, Align: 16}
+		if err := ts.Validate(); err != nil {
+			t.Errorf("Revision %d: Validate: got %v, want nil", rev, err)
+		}
+	}
+
+	rsdt, err := NewRSDT()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := &TableSet{RSDT: rsdt, Tables: []Marshaler{validIBFT(), ssdt}}
+	b, addrs, pl, err := ts.assemble(validateBase)
+	if err != nil {
+		t.Fatalf("assemble: got %v, want nil", err)
+	}
+	var want []uint64
+	for _, p := range pl {
+		want = append(want, p.Address)
+	}
+	if err := checkAssembled(b, validateBase, want); err != nil {
+		t.Fatalf("checkAssembled: got %v, want nil", err)
+	}
+	var tests = []struct {
+		n    string
+		f    func(b []byte)
+		want string
+	}{
+		{"stale XSDT entry", func(b []byte) {
+			o := addrs["XSDT"] - validateBase
+			binary.LittleEndian.PutUint64(b[o+HeaderLength+8:], want[1]+8)
+			fixChecksum(t, b[o:o+HeaderLength+16])
+		}, "TableSet: XSDT entry 1 is"},
+		{"stale RSDT entry", func(b []byte) {
+			o := addrs["RSDT"] - validateBase
+			binary.LittleEndian.PutUint32(b[o+HeaderLength:], uint32(want[1]))
+			fixChecksum(t, b[o:o+HeaderLength+8])
+		}, "TableSet: RSDT entry 0 is"},
+		{"RSDP points to the RSDT", func(b []byte) {
+			binary.LittleEndian.PutUint64(b[xSDTAddrOff:], addrs["RSDT"])
+			b[cSUM2Off] = 0
+			b[cSUM2Off] = gencsum(b[:HeaderLength])
+		}, `TableSet: RSDP XSDT address 0x`},
+		{"RSDP points outside", func(b []byte) {
+			binary.LittleEndian.PutUint32(b[rSDTAddrOff:], 0x1000)
+			b[cSUM1Off] = 0
+			b[cSUM1Off] = gencsum(b[:rSDPV1Len])
+			b[cSUM2Off] = 0
+			b[cSUM2Off] = gencsum(b[:HeaderLength])
+		}, "TableSet: RSDP RSDT address: 0x1000 is outside the set"},
+		{"bad table checksum", func(b []byte) {
+			b[addrs["SSDT"]-validateBase+HeaderLength]++
+		}, "TableSet: RSDT entry 1: VerifyChecksum"},
+	}
+	for _, tt := range tests {
+		c := append([]byte{}, b...)
+		tt.f(c)
+		err := checkAssembled(c, validateBase, want)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("%s: checkAssembled: got %v, want an error starting %q", tt.n, err, tt.want)
+		}
+	}
+
+	// Each table is validated too.
+	i := validIBFT()
+	i.Initiator.Name = "myinitor"
+	ts = &TableSet{Tables: []Marshaler{ssdt, i}}
+	if err := ts.Validate(); !IsWarning(err) || !strings.Contains(err.Error(), "table 1 (*acpi.IBFT): Initiator Name") {
+		t.Errorf("Validate with a bad IBFT: got %v, want an Initiator Name Warning", err)
+	}
+}
@@ -4,6 +4,14 @@
 
 package acpi
 
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 // These types are used in emitting binary from a JSON.
 // You can serialize from JSON into structs with these types, and then
 // they can be used in a type switch to serialize out different ways.
@@ -23,8 +31,67 @@ type (
 	u16      string // 2 byte unsigned
 	u32      string // 4 byte unsigned
 	u64      string // 8 byte unsigned
+	lun      string // 8 byte SCSI LUN, as a u64 or a 16 hex digit word
 )
 
+// Sheap is the type of the heap entry fields of an IBFT, e.g.
+// IBFTTarget.TargetName, for callers which hold one, e.g. from
+// RawSheap, before setting a field to it.
+type Sheap = sheap
+
+// RawSheap returns a heap entry with exactly the bytes in b, e.g. with a
+// particular terminator or padding, for reproducing a table from
+// firmware byte for byte. It is an escape hatch, for testing: heap
+// entries are normally strings, which is what firmware should use.
+// A raw entry is still followed by a NUL if HeapNUL says its kind is;
+// for bytes exactly as in a table, set HeapNUL to match the table.
+// In JSON, a heap entry which is not UTF-8 is written as
+// {"raw": "<base64>"}, and can be read that way.
+func RawSheap(b []byte) Sheap {
+	return sheap(b)
+}
+
+// lunBytesPrefix marks a LUN given as its bytes in table order.
+const lunBytesPrefix = "lun:"
+
+// parseLUN returns the value of a LUN, as it goes in the table: a little
+// endian quad word. A LUN is a number, e.g. "1" or "0x100", or, as
+// open-iscsi and firmware tools show it, 16 hex digits with no 0x,
+// which are the 8 bytes of the LUN in table order, e.g.
+// "0001000000000000", which is LUN 1 in SCSI's encoding, and 0x100 as a
+// number. The digits can have a "lun:" prefix, to make that plain.
+// Exactly 16 digits are always the bytes, even if they are all decimal;
+// write a 16 digit decimal LUN in hex, with 0x, as formatLUN does.
+// An unset LUN is 0.
+func parseLUN(l lun) (uint64, error) {
+	if l == "" {
+		return 0, nil
+	}
+	h := strings.TrimPrefix(string(l), lunBytesPrefix)
+	if h != string(l) || (len(h) == 16 && !strings.HasPrefix(strings.ToLower(h), "0x")) {
+		b, err := hex.DecodeString(h)
+		if err != nil || len(b) != 8 {
+			return 0, fmt.Errorf("BootLUN %q: 16 digits are the bytes of a LUN, and must be hex", l)
+		}
+		return binary.LittleEndian.Uint64(b), nil
+	}
+	v, err := strconv.ParseUint(string(l), 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("BootLUN %q: %v", l, err)
+	}
+	return v, nil
+}
+
+// formatLUN returns v as a lun which parseLUN reads back as v: in
+// decimal, unless that is 16 digits, which parseLUN takes as bytes.
+func formatLUN(v uint64) lun {
+	s := strconv.FormatUint(v, 10)
+	if len(s) == 16 {
+		s = fmt.Sprintf("%#x", v)
+	}
+	return lun(s)
+}
+
 // Tabler is the interface to ACPI tables, be they
 // held in memory as a byte slice, header and byte slice,
 // or more complex struct.
@@ -43,6 +110,20 @@ type Tabler interface {
 	Marshal() ([]byte, error)
 }
 
+// Marshaler is implemented by tables which can marshal themselves
+// to a byte slice. Anything which builds a table should implement it,
+// so that tables can be assembled into a set without knowing what
+// they are.
+type Marshaler interface {
+	Marshal() ([]byte, error)
+}
+
+// Unmarshaler is implemented by tables which can unmarshal themselves
+// from a byte slice.
+type Unmarshaler interface {
+	Unmarshal([]byte) error
+}
+
 // Header is the standard header for all ACPI tables, except the
 // ones that don't use it. (That's a joke. So is ACPI.)
 // We use types that we hope are easy to read; they in turn
@@ -0,0 +1,112 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acpi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// TestMarshalers round trips each of our tables through its
+// Marshaler and Unmarshaler.
+func TestMarshalers(t *testing.T) {
+	s, err := NewSDT()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Tables = []int64{0x1000, 0x2000}
+	sdt, err := Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ssdt := genssdt([]byte{1, 2, 3, 4})
+	// testdata/ibft.bin does not follow names with a NUL.
+	defer func(n bool) { HeapNUL[HeapName] = n }(HeapNUL[HeapName])
+	HeapNUL[HeapName] = false
+	var tests = []struct {
+		n string
+		b []byte
+		u Unmarshaler
+	}{
+		{"Raw", ssdt, &Raw{}},
+		{"Generic", ssdt, &Generic{}},
+		{"SDT", sdt, &SDT{}},
+		{"IBFT", readTestIBFT(t), &IBFT{}},
+	}
+	for _, tt := range tests {
+		if err := tt.u.Unmarshal(tt.b); err != nil {
+			t.Errorf("%s: Unmarshal: got %v, want nil", tt.n, err)
+			continue
+		}
+		b, err := tt.u.(Marshaler).Marshal()
+		if err != nil {
+			t.Errorf("%s: Marshal: got %v, want nil", tt.n, err)
+			continue
+		}
+		if tab, ok := tt.u.(Tabler); ok {
+			// Marshal does not always fix the length and checksum; acpi.Marshal does.
+			if b, err = Marshal(tab); err != nil {
+				t.Errorf("%s: acpi.Marshal: got %v, want nil", tt.n, err)
+				continue
+			}
+		}
+		if !bytes.Equal(b, tt.b) {
+			t.Errorf("%s: round trip: got %#x, want %#x", tt.n, b, tt.b)
+		}
+		if err := tt.u.Unmarshal(tt.b[:len(tt.b)-1]); err == nil {
+			t.Errorf("%s: Unmarshal of a short table: got nil, want error", tt.n)
+		}
+	}
+}
+
+func TestParseLUN(t *testing.T) {
+	var tests = []struct {
+		l     lun
+		want  uint64
+		bytes []byte
+	}{
+		{"", 0, []byte{0, 0, 0, 0, 0, 0, 0, 0}},
+		{"1234", 1234, []byte{0xd2, 0x04, 0, 0, 0, 0, 0, 0}},
+		{"0x100", 0x100, []byte{0, 1, 0, 0, 0, 0, 0, 0}},
+		// LUN 1, as open-iscsi shows it: the bytes in table order.
+		{"0001000000000000", 0x100, []byte{0, 1, 0, 0, 0, 0, 0, 0}},
+		{"lun:0001000000000000", 0x100, []byte{0, 1, 0, 0, 0, 0, 0, 0}},
+		{"0102030405060708", 0x0807060504030201, []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{"4000ABCD00000000", 0xcdab0040, []byte{0x40, 0, 0xab, 0xcd, 0, 0, 0, 0}},
+		// 16 digits are bytes, even if they are all decimal.
+		{"1000000000000000", 0x10, []byte{0x10, 0, 0, 0, 0, 0, 0, 0}},
+		// Such a number is written in hex.
+		{"0x38d7ea4c68000", 1000000000000000, []byte{0x00, 0x80, 0xc6, 0xa4, 0x7e, 0x8d, 0x03, 0x00}},
+	}
+	for _, tt := range tests {
+		v, err := parseLUN(tt.l)
+		if err != nil || v != tt.want {
+			t.Errorf("parseLUN(%q): got (%#x, %v), want (%#x, nil)", tt.l, v, err, tt.want)
+		}
+		i := testIBFT()
+		i.Target0.BootLUN = tt.l
+		b, err := Marshal(i)
+		if err != nil {
+			t.Fatalf("Marshal with BootLUN %q: got %v, want nil", tt.l, err)
+		}
+		// Target0 is the third structure; BootLUN is at 24.
+		o := int(binary.LittleEndian.Uint16(b[ibftHeaderLen+12:])) + 24
+		if got := b[o : o+8]; !bytes.Equal(got, tt.bytes) {
+			t.Errorf("Marshal with BootLUN %q: got bytes %#x, want %#x", tt.l, got, tt.bytes)
+		}
+	}
+	for _, l := range []lun{"00010000000000zz", "lun:000100", "0x10000000000000000", "lun"} {
+		if v, err := parseLUN(l); err == nil {
+			t.Errorf("parseLUN(%q): got (%#x, nil), want error", l, v)
+		}
+	}
+	for _, v := range []uint64{0, 1, 1000000000000000, 9999999999999999, math.MaxUint64} {
+		if got, err := parseLUN(formatLUN(v)); err != nil || got != v {
+			t.Errorf("parseLUN(formatLUN(%d)) = parseLUN(%q): got (%d, %v), want (%d, nil)", v, formatLUN(v), got, err, v)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acpi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strconv"
+	"testing"
+)
+
+// TestWordSize checks the places where the width of int could matter:
+// addresses above 2GiB and 4GiB, and 64 bit fields. It runs on every
+// GOARCH; CI runs it for 386 and builds it for arm, where an int is 32
+// bits, as u-root's 32 bit targets have.
+func TestWordSize(t *testing.T) {
+	t.Logf("int is %d bits", strconv.IntSize)
+
+	// A LUN with every bit set is a 64 bit field, whatever int is.
+	i := validIBFT()
+	i.Target0.BootLUN = "0xffffffffffffffff"
+	b, err := Marshal(i)
+	if err != nil {
+		t.Fatalf("Marshal: got %v, want nil", err)
+	}
+	o := int(binary.LittleEndian.Uint16(b[ibftHeaderLen+12:])) + 24
+	if got := b[o : o+8]; !bytes.Equal(got, bytes.Repeat([]byte{0xff}, 8)) {
+		t.Errorf("BootLUN: got %#x, want all ones", got)
+	}
+	r := &IBFT{}
+	if err := r.Unmarshal(b); err != nil {
+		t.Fatalf("Unmarshal: got %v, want nil", err)
+	}
+	if want := lun(strconv.FormatUint(math.MaxUint64, 10)); r.Target0.BootLUN != want {
+		t.Errorf("Unmarshal: BootLUN got %q, want %q", r.Target0.BootLUN, want)
+	}
+
+	// Tables above 2GiB, where a 32 bit int goes negative, and above
+	// 4GiB, where it wraps.
+	for _, tt := range []struct {
+		rev  uint8
+		base uint64
+	}{
+		{1, 0x80000000},
+		{2, 0x80000000},
+		{2, 0x100000000},
+		{2, 0xffffffff00000000},
+	} {
+		ts := &TableSet{Revision: tt.rev, Tables: []Marshaler{i, i}, Align: 4096}
+		b, addrs, pl, err := ts.assemble(tt.base)
+		if err != nil {
+			t.Fatalf("Revision %d at %#x: assemble: got %v, want nil", tt.rev, tt.base, err)
+		}
+		var want []uint64
+		for _, p := range pl {
+			want = append(want, p.Address)
+		}
+		if err := checkAssembled(b, tt.base, want); err != nil {
+			t.Errorf("Revision %d at %#x: %v", tt.rev, tt.base, err)
+		}
+		if a := addrs["IBFT"]; a <= tt.base || a%4096 != 0 {
+			t.Errorf("Revision %d at %#x: IBFT at %#x, want a 4096 byte aligned address above the base", tt.rev, tt.base, a)
+		}
+	}
+	// An RSDT can not point above 4GiB. Here the RSDT is just below
+	// it, and the IBFT, aligned, just above.
+	ts := &TableSet{Revision: 1, Tables: []Marshaler{i}, Align: 16}
+	if _, _, err := ts.Assemble(0xffffffc0); err == nil {
+		t.Errorf("Revision 1 Assemble at %#x: got nil, want error", uint64(0xffffffc0))
+	}
+
+	// A Length with the top bit set is too big, not negative.
+	b = append([]byte{}, b...)
+	binary.LittleEndian.PutUint32(b[LengthOffset:], math.MaxUint32)
+	if _, err := NewIBFTReader(b); err == nil {
+		t.Errorf("NewIBFTReader with Length %#x: got nil, want error", uint32(math.MaxUint32))
+	}
+	if b, err := MarshalForceLength(i, math.MaxUint32); err != nil || gencsum(b) != 0 {
+		t.Errorf("MarshalForceLength(%#x): got (checksum off by %#x, %v), want a table which sums to zero", uint32(math.MaxUint32), gencsum(b), err)
+	}
+}